@@ -0,0 +1,215 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+)
+
+// PassphraseHeader carries the passphrase for session archives.
+const PassphraseHeader = "X-Archive-Passphrase"
+
+type importSessionResponse struct {
+	ID string `json:"id"`
+}
+
+type adminQRResponse struct {
+	QR       string            `json:"qr"`
+	State    session.ConnState `json:"state"`
+	LoggedIn bool              `json:"logged_in"`
+	JID      string            `json:"jid,omitempty"`
+}
+
+type rotateTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// RegisterAdminRoutes mounts operator endpoints under /admin, protected by
+// the admin token. With an empty token they answer 404.
+func RegisterAdminRoutes(r chi.Router, adminToken string) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(authAdmin(adminToken))
+		r.Get("/sessions", handleListSessions)
+		r.Post("/sessions", handleAdminCreateSession)
+		r.Delete("/sessions/{id}", handleDeleteSession)
+		r.Post("/sessions/{id}/logout", handleLogoutSession)
+		r.Get("/sessions/{id}/qr", handleAdminQR)
+		r.Post("/sessions/{id}/send", handleAdminSend)
+		r.Post("/sessions/{id}/token", handleRotateToken)
+		r.Put("/sessions/{id}/log-level", handleAdminSetLogLevel)
+		r.Post("/sessions/{id}/export", handleExportSession)
+		r.Post("/sessions/import", handleImportSession)
+	})
+}
+
+func authAdmin(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				writeErrorCode(w, r, codeNotFound, "admin API is disabled")
+				return
+			}
+			token := extractBearerToken(r)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				writeErrorCode(w, r, codeUnauthorized, "invalid admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func handleAdminCreateSession(w http.ResponseWriter, r *http.Request) {
+	createSession(w, r, true)
+}
+
+func handleExportSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, id))
+
+	archive, err := session.GetManager().ExportSession(r.Context(), id, r.Header.Get(PassphraseHeader))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session_%s.wasession"`, id))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(archive)
+}
+
+func handleImportSession(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, session.MaxArchiveSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorCode(w, r, codeTooLarge, "archive too large")
+		} else {
+			writeErrorCode(w, r, codeInvalidRequest, "failed to read archive")
+		}
+		return
+	}
+
+	id, err := session.GetManager().ImportSession(r.Context(), data, r.Header.Get(PassphraseHeader))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, importSessionResponse{ID: id})
+}
+
+// adminSession resolves the {id} URL parameter to an active session, writing
+// a 404 if there is none.
+func adminSession(w http.ResponseWriter, r *http.Request) (*session.Session, bool) {
+	id := chi.URLParam(r, "id")
+	setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, id))
+	sess, ok := session.GetManager().GetSession(id)
+	if !ok {
+		writeError(w, r, session.ErrSessionNotFound)
+		return nil, false
+	}
+	return sess, true
+}
+
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, id))
+
+	if err := session.GetManager().DeleteSession(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "deleted"})
+}
+
+func handleLogoutSession(w http.ResponseWriter, r *http.Request) {
+	sess, ok := adminSession(w, r)
+	if !ok {
+		return
+	}
+
+	if err := session.GetManager().Logout(r.Context(), sess.ID); err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "logout failed", "error", err)
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "logged_out"})
+}
+
+// handleAdminQR returns the raw QR payload rather than an image so that
+// clients such as the CLI can render it themselves. qr is empty while no
+// code is available.
+func handleAdminQR(w http.ResponseWriter, r *http.Request) {
+	sess, ok := adminSession(w, r)
+	if !ok {
+		return
+	}
+
+	sess.Mutex.RLock()
+	resp := adminQRResponse{QR: sess.QR, State: sess.State, LoggedIn: sess.LoggedIn, JID: sess.JID}
+	sess.Mutex.RUnlock()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleAdminSend(w http.ResponseWriter, r *http.Request) {
+	sess, ok := adminSession(w, r)
+	if !ok {
+		return
+	}
+	sendMessage(w, r, sess.ID)
+}
+
+// handleAdminSetLogLevel overrides a session's log level. It is an admin
+// route as the level decides how much of the session the server's logs
+// record; sessions can read theirs through GET /session/log-level.
+func handleAdminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	sess, ok := adminSession(w, r)
+	if !ok {
+		return
+	}
+
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+
+	if strings.TrimSpace(req.Level) == "" {
+		logging.ClearSessionLevel(sess.ID)
+	} else {
+		level, err := logging.ParseLevel(req.Level)
+		if err != nil {
+			writeErrorCode(w, r, codeInvalidRequest, err.Error())
+			return
+		}
+		logging.SetSessionLevel(sess.ID, level)
+	}
+
+	level, override := logging.SessionLevel(sess.ID)
+	sess.Log.InfoContext(r.Context(), "log level changed", "level", level.String(), "override", override)
+	writeJSON(w, http.StatusOK, logLevelResponse{Level: strings.ToLower(level.String()), Override: override})
+}
+
+func handleRotateToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, id))
+
+	token, err := session.GetManager().RotateToken(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rotateTokenResponse{Token: token})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"wa-mvp-api/internal/session"
+)
+
+// previewLength caps, in characters, the last message text shown with a
+// chat.
+const previewLength = 100
+
+type chatInfo struct {
+	JID          string           `json:"jid"`
+	Name         string           `json:"name,omitempty"`
+	IsGroup      bool             `json:"is_group"`
+	LastMessage  *chatMessageInfo `json:"last_message,omitempty"`
+	UnreadCount  int              `json:"unread_count"`
+	MarkedUnread bool             `json:"marked_unread"`
+	Muted        bool             `json:"muted"`
+	// MutedUntil is absent for chats muted until they are unmuted.
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+}
+
+type chatMessageInfo struct {
+	ID        string    `json:"id"`
+	FromMe    bool      `json:"from_me"`
+	Preview   string    `json:"preview"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type listChatsResponse struct {
+	Chats      []chatInfo `json:"chats"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	HasMore    bool       `json:"has_more"`
+}
+
+func newChatInfo(chat session.Chat, now time.Time) chatInfo {
+	info := chatInfo{
+		JID:          chat.JID,
+		Name:         chat.Name,
+		IsGroup:      strings.HasSuffix(chat.JID, "@"+types.GroupServer),
+		UnreadCount:  chat.UnreadCount,
+		MarkedUnread: chat.MarkedUnread,
+		Muted:        chat.Muted(now),
+		Archived:     chat.Archived,
+		Pinned:       chat.Pinned,
+	}
+	if info.Muted && chat.MutedUntil != session.MutedForever {
+		until := time.Unix(chat.MutedUntil, 0).UTC()
+		info.MutedUntil = &until
+	}
+	if chat.LastMessageID != "" {
+		info.LastMessage = &chatMessageInfo{
+			ID:        chat.LastMessageID,
+			FromMe:    chat.LastFromMe,
+			Preview:   preview(chat.LastText),
+			Timestamp: time.Unix(chat.LastMessageAt, 0).UTC(),
+		}
+	}
+	return info
+}
+
+// preview shortens a message text to one line of at most previewLength
+// characters.
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > previewLength {
+		return string(runes[:previewLength-1]) + "…"
+	}
+	return text
+}
+
+// Chat cursors continue a listing after a chat; they encode its position.
+func encodeChatCursor(pos session.ChatPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("c%d.%s", pos.LastMessageAt, pos.JID)))
+}
+
+func decodeChatCursor(cursor string) (*session.ChatPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) < 2 || raw[0] != 'c' {
+		return nil, errInvalidCursor
+	}
+	ts, jid, ok := strings.Cut(string(raw[1:]), ".")
+	pos := &session.ChatPosition{JID: jid}
+	if pos.LastMessageAt, err = strconv.ParseInt(ts, 10, 64); !ok || err != nil || jid == "" {
+		return nil, errInvalidCursor
+	}
+	return pos, nil
+}
+
+func parseChatFilter(r *http.Request) (session.ChatFilter, error) {
+	var filter session.ChatFilter
+	var err error
+	if filter.Archived, err = parseBool(r, "archived"); err != nil {
+		return filter, err
+	}
+	if filter.Unread, err = parseBool(r, "unread"); err != nil {
+		return filter, err
+	}
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		if filter.After, err = decodeChatCursor(raw); err != nil {
+			return filter, err
+		}
+	}
+	if filter.Limit, err = parseLimit(r, defaultListLimit, maxListLimit); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// handleListChats lists the session's chats, most recent first.
+func handleListChats(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	filter, err := parseChatFilter(r)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	limit := filter.Limit
+	filter.Limit++
+	chats, err := sess.ListChats(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list chats: %w", err))
+		return
+	}
+
+	resp := listChatsResponse{Chats: make([]chatInfo, 0, limit)}
+	if len(chats) > limit {
+		chats = chats[:limit]
+		resp.HasMore = true
+		resp.NextCursor = encodeChatCursor(chats[len(chats)-1].Position())
+	}
+	now := time.Now()
+	for _, chat := range chats {
+		resp.Chats = append(resp.Chats, newChatInfo(chat, now))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/session"
+)
+
+type listContactsResponse struct {
+	Contacts []session.Contact `json:"contacts"`
+}
+
+// handleListContacts lists the names the session knows its contacts by.
+func handleListContacts(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	contacts, err := sess.ListContacts(r.Context())
+	if err != nil {
+		writeError(w, r, fmt.Errorf("list contacts: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, listContactsResponse{Contacts: contacts})
+}
+
+// handleGetContact returns a user's profile, fetched from WhatsApp when it is
+// not cached or ?refresh=true.
+func handleGetContact(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	jid, err := session.ParseJID(chi.URLParam(r, "jid"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	refresh, err := parseBool(r, "refresh")
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	profile, err := sess.GetContact(r.Context(), jid, refresh != nil && *refresh)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
@@ -1,14 +1,220 @@
 package api
 
-import "net/http"
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The docs page is rendered from the OpenAPI document, with a few guide
+// sections that are not about a single route.
+
+const docsBaseURL = "http://localhost:9090"
+
+type docsData struct {
+	Groups             []docsGroup
+	ErrorCodes         []docsErrorCode
+	LegacyDeprecatedAt int64
+}
+
+type docsErrorCode struct {
+	Code   string
+	Status int
+}
+
+type docsGroup struct {
+	Tag        string
+	Operations []docsOperation
+}
+
+type docsOperation struct {
+	ID          string
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Params      []openAPIParameter
+	Curl        string
+	Request     string
+	Status      string
+	Response    string
+	ContentType string
+}
+
+var docsHTML struct {
+	once sync.Once
+	page []byte
+	err  error
+}
 
 func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	docsHTML.once.Do(func() {
+		var buf bytes.Buffer
+		docsHTML.err = docsPage.Execute(&buf, buildDocs())
+		docsHTML.page = buf.Bytes()
+	})
+	if docsHTML.err != nil {
+		loggerFromContext(r).ErrorContext(r.Context(), "render docs failed", "error", docsHTML.err)
+		http.Error(w, "failed to render docs", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(docsHTML))
+	_, _ = w.Write(docsHTML.page)
+}
+
+// buildDocs groups the document's operations by tag, in the order of
+// apiOperations.
+func buildDocs() docsData {
+	doc, _ := openAPISpec()
+	data := docsData{LegacyDeprecatedAt: legacyDeprecatedAt.Unix()}
+	groups := map[string]int{}
+	for _, tag := range doc.Tags {
+		groups[tag.Name] = len(data.Groups)
+		data.Groups = append(data.Groups, docsGroup{Tag: tag.Name})
+	}
+	for code, status := range errorStatus {
+		if code != codeInternal {
+			data.ErrorCodes = append(data.ErrorCodes, docsErrorCode{Code: code, Status: status})
+		}
+	}
+	sort.Slice(data.ErrorCodes, func(i, j int) bool {
+		a, b := data.ErrorCodes[i], data.ErrorCodes[j]
+		return a.Status < b.Status || a.Status == b.Status && a.Code < b.Code
+	})
+	// Routes shared by several versions are shown once, under the oldest.
+	for _, ref := range apiOperations {
+		path := ref.Path
+		if !ref.Unversioned {
+			version := apiVersions[0]
+			if len(ref.Versions) > 0 {
+				version = ref.Versions[0]
+			}
+			path = versionPrefix(version) + path
+		}
+		op := doc.Paths[path][strings.ToLower(ref.Method)]
+		g := &data.Groups[groups[op.Tags[0]]]
+		g.Operations = append(g.Operations, docsOperationFor(ref.Method, path, op))
+	}
+	return data
+}
+
+func docsOperationFor(method string, path string, op *openAPIOperation) docsOperation {
+	d := docsOperation{
+		ID:          op.OperationID,
+		Method:      method,
+		Path:        path,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Params:      op.Parameters,
+	}
+
+	var requestContent string
+	if op.RequestBody != nil {
+		for content, media := range op.RequestBody.Content {
+			requestContent = content
+			d.Request = string(media.Example)
+		}
+	}
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	d.Status = codes[0]
+	var responseContent string
+	for content, media := range op.Responses[d.Status].Content {
+		if responseContent == "" || content == "application/json" {
+			responseContent = content
+			d.Response = string(media.Example)
+		}
+	}
+	if responseContent != "application/json" {
+		d.ContentType = responseContent
+	}
+
+	d.Curl = docsCurl(method, path, op, requestContent, d.Request, responseContent)
+	return d
+}
+
+// docsCurl builds a curl command for op from the examples in the document.
+func docsCurl(method string, path string, op *openAPIOperation, requestContent string, request string, responseContent string) string {
+	var query []string
+	var headers []string
+	for _, p := range op.Parameters {
+		switch {
+		case p.In == "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", p.Example)
+		case p.In == "query" && p.Example != "":
+			query = append(query, p.Name+"="+p.Example)
+		case p.In == "header":
+			headers = append(headers, p.Name+": "+p.Example)
+		}
+	}
+	for _, sec := range op.Security {
+		switch {
+		case sec[string(securitySession)] != nil:
+			headers = append([]string{"Authorization: Bearer YOUR_TOKEN"}, headers...)
+		case sec[string(securityAdmin)] != nil:
+			headers = append([]string{"Authorization: Bearer ADMIN_TOKEN"}, headers...)
+		}
+	}
+
+	target := docsBaseURL + path
+	if len(query) > 0 {
+		target = `"` + target + "?" + strings.Join(query, "&") + `"`
+	}
+	parts := []string{"curl"}
+	if method != http.MethodGet {
+		parts = append(parts, "-X "+method)
+	}
+	parts = append(parts, target)
+	lines := []string{strings.Join(parts, " ")}
+	for _, h := range headers {
+		lines = append(lines, fmt.Sprintf(`-H "%s"`, h))
+	}
+	switch requestContent {
+	case "":
+	case "application/json":
+		lines = append(lines, `-H "Content-Type: application/json"`,
+			`-d "`+strings.ReplaceAll(request, `"`, `\"`)+`"`)
+	default:
+		lines = append(lines, "--data-binary @abc123.wasession")
+	}
+	if responseContent == "application/octet-stream" {
+		lines = append(lines, "-o abc123.wasession")
+	}
+	return strings.Join(lines, " \\\n  ")
 }
 
-const docsHTML = `<!doctype html>
+// docText renders a description: paragraphs are separated by blank lines and
+// backticks mark code.
+func docText(s string) template.HTML {
+	var b strings.Builder
+	for _, para := range strings.Split(s, "\n\n") {
+		b.WriteString("<p>" + string(docInline(para)) + "</p>")
+	}
+	return template.HTML(b.String())
+}
+
+// docInline renders a single line with backticks marking code.
+func docInline(s string) template.HTML {
+	var b strings.Builder
+	for i, part := range strings.Split(s, "`") {
+		if i%2 == 1 {
+			b.WriteString("<code>" + template.HTMLEscapeString(part) + "</code>")
+		} else {
+			b.WriteString(template.HTMLEscapeString(part))
+		}
+	}
+	return template.HTML(b.String())
+}
+
+var docsPage = template.Must(template.New("docs").Funcs(template.FuncMap{"text": docText, "inline": docInline}).Parse(`<!doctype html>
 <html lang="en">
 <head>
   <meta charset="utf-8">
@@ -121,6 +327,29 @@ const docsHTML = `<!doctype html>
     .warn {
       color: #8b6a1f;
     }
+    .ref {
+      list-style: none;
+      padding: 0;
+      margin: 4px 0 12px;
+      font-size: 14px;
+    }
+    .ref a {
+      color: var(--muted);
+      text-decoration: none;
+    }
+    .group {
+      margin: 12px 0 0;
+      color: var(--accent);
+    }
+    .path {
+      font-size: 15px;
+      margin: 4px 0 0;
+    }
+    .params {
+      margin: 8px 0 0;
+      padding-left: 18px;
+      color: var(--muted);
+    }
     @media (max-width: 900px) {
       .grid { grid-template-columns: 1fr; }
       .side { position: static; }
@@ -130,86 +359,89 @@ const docsHTML = `<!doctype html>
 <body>
   <div class="wrap">
     <h1>WA MVP API Documentation</h1>
-    <p>Base URL: <code>http://localhost:9090</code></p>
+    <p>Base URL: <code>http://localhost:9090</code>. Machine-readable: <a href="/openapi.json"><code>/openapi.json</code></a> (OpenAPI 3).</p>
 
     <div class="grid">
       <aside class="side">
         <h2>Steps</h2>
         <ul class="steps">
-          <li><a href="#create"><span>1</span>Create a session</a></li>
-          <li><a href="#qr"><span>2</span>Fetch QR and scan</a></li>
-          <li><a href="#status"><span>3</span>Check status</a></li>
-          <li><a href="#send"><span>4</span>Send a message</a></li>
-          <li><a href="#receive"><span>5</span>Receive messages (polling)</a></li>
+          <li><a href="#createSession"><span>1</span>Create a session</a></li>
+          <li><a href="#getSessionQR"><span>2</span>Fetch QR and scan</a></li>
+          <li><a href="#getSessionStatus"><span>3</span>Check status</a></li>
+          <li><a href="#sendMessage"><span>4</span>Send a message</a></li>
+          <li><a href="#receiveMessages"><span>5</span>Receive messages (polling)</a></li>
         </ul>
+        {{range .Groups}}
+        <h3 class="group">{{.Tag}}</h3>
+        <ul class="ref">
+          {{range .Operations}}<li><a href="#{{.ID}}">{{.Summary}}</a></li>
+          {{end}}
+        </ul>
+        {{end}}
       </aside>
 
       <main class="main">
         <div class="card section" id="auth">
           <h2>Authentication</h2>
-          <p>Session-specific endpoints require a Bearer token returned by <code>POST /sessions</code>.</p>
-          <pre>Authorization: Bearer &lt;YOUR_TOKEN&gt;</pre>
-        </div>
-
-        <div class="card section" id="create">
-          <h2>Create Session <span class="tag">POST</span></h2>
-          <p>Creates a new WhatsApp session. The response includes a bearer token that identifies this session. Store it securely and send it in the <code>Authorization</code> header for all session-specific calls.</p>
-          <pre>curl -X POST http://localhost:9090/sessions</pre>
-          <p>Response:</p>
-          <pre>{"token":"YOUR_TOKEN"}</pre>
+          <p>Session-specific endpoints require a Bearer token returned by <code>POST /v1/sessions</code>. Admin endpoints take the server's <code>ADMIN_TOKEN</code> instead, and QR and onboarding pages take the signed token in their link.</p>
+          <pre>Authorization: Bearer YOUR_TOKEN</pre>
+          <p>Errors share one JSON shape. Branch on <code>code</code>; <code>message</code> is for humans, <code>details</code> carries context such as the configured rate limit, and <code>request_id</code> matches the <code>X-Request-ID</code> header and the server logs.</p>
+          <pre>{"code":"rate_limited","message":"rate limit exceeded","details":{"limit_per_minute":30,"retry_after_seconds":2},"request_id":"38e40cc7c5ea0be8"}</pre>
+          <p>Codes and their statuses: {{range .ErrorCodes}}<code>{{.Code}}</code> ({{.Status}}), {{end}}and <code>internal_error</code> (500) for anything unexpected.</p>
         </div>
-
-        <div class="card section" id="list">
-          <h2>List Sessions <span class="tag">GET</span></h2>
-          <p>Lists all sessions (for admin/debug). No auth required. Useful to see connection state and JID.</p>
-          <pre>curl http://localhost:9090/sessions</pre>
-          <p>Response:</p>
-          <pre>[{"id":"abc123","connected":true,"jid":"9198xxx@s.whatsapp.net"}]</pre>
+        <div class="card section" id="versions">
+          <h2>Versions</h2>
+          <p>Routes are served under <code>/v1</code> and <code>/v2</code>. Every route below exists in both unless it is listed with a <code>/v2</code> path: v2 returns messages with their chat, sender JID, ISO timestamp and a cursor, and receives them by cursor instead of dequeuing them. The pages, health checks and this documentation are not versioned.</p>
+          <p>The v1 routes also answer without a prefix, as they did before versioning. These aliases are deprecated: their responses carry a <code>Deprecation</code> header and a <code>Link</code> header naming the <code>/v1</code> route to move to.</p>
+          <pre>Deprecation: @{{.LegacyDeprecatedAt}}
+Link: &lt;/v1/session/send&gt;; rel="successor-version"</pre>
         </div>
-
-        <div class="card section" id="qr">
-          <h2>Get QR <span class="tag">GET</span></h2>
-          <p>Returns a base64 PNG QR for the session. Open the URL in a browser and render the image, or decode the base64 to show it in your UI.</p>
-          <pre>curl http://localhost:9090/session/qr \
-  -H "Authorization: Bearer YOUR_TOKEN"</pre>
-          <p>Response:</p>
-          <pre>{"qr":"data:image/png;base64,..."}</pre>
-        </div>
-
-        <div class="card section" id="status">
-          <h2>Get Status <span class="tag">GET</span></h2>
-          <p>Returns login and connection status for the session. Use this after scanning the QR to confirm the session is active.</p>
-          <pre>curl http://localhost:9090/session/status \
-  -H "Authorization: Bearer YOUR_TOKEN"</pre>
-          <p>Response:</p>
-          <pre>{"logged_in":true,"connected":true,"jid":"9198xxx@s.whatsapp.net"}</pre>
+        {{range .Groups}}
+        <h2 class="group">{{.Tag}}</h2>
+        {{range .Operations}}
+        <div class="card section" id="{{.ID}}">
+          <h2>{{.Summary}} <span class="tag">{{.Method}}</span></h2>
+          <p class="path"><code>{{.Method}} {{.Path}}</code></p>
+          {{text .Description}}
+          {{if .Params}}
+          <ul class="params">
+            {{range .Params}}<li><code>{{.Name}}</code> ({{.In}}{{if .Required}}, required{{end}}){{if .Description}}: {{inline .Description}}{{end}}</li>
+            {{end}}
+          </ul>
+          {{end}}
+          <pre>{{.Curl}}</pre>
+          {{if .Response}}
+          <p>Response ({{.Status}}):</p>
+          <pre>{{.Response}}</pre>
+          {{else if .ContentType}}
+          <p>Response ({{.Status}}): <code>{{.ContentType}}</code></p>
+          {{end}}
         </div>
+        {{end}}
+        {{end}}
 
-        <div class="card section" id="send">
-          <h2>Send Message <span class="tag">POST</span></h2>
-          <p>Sends a text message from the session. Phone should be in international format without <code>+</code>.</p>
-          <pre>curl -X POST http://localhost:9090/session/send \
-  -H "Authorization: Bearer YOUR_TOKEN" \
-  -H "Content-Type: application/json" \
-  -d "{\"phone\":\"919999999999\",\"message\":\"hello\"}"</pre>
-          <p>Response:</p>
-          <pre>{"status":"sent"}</pre>
+        <h2 class="group">Guides</h2>
+        <div class="card section" id="cli">
+          <h2>Command Line</h2>
+          <p>The binary runs the server by default (<code>wa-mvp-api serve</code>) and also manages sessions: listing, creating, deleting and logging out sessions, pairing by scanning a QR code rendered in the terminal, sending a message, rotating a session's token, export and import, store migrations and master keys. Run <code>wa-mvp-api help</code> for the full list. Commands open the local stores, which requires the server to be stopped, unless <code>--remote</code> (or <code>REMOTE_URL</code>) points at a running instance; they then go through its admin API with <code>ADMIN_TOKEN</code>.</p>
+          <pre>wa-mvp-api sessions create --name Support --label acme
+wa-mvp-api session qr abc123
+wa-mvp-api send abc123 919999999999 "hello"
+wa-mvp-api --remote http://gw:9090 sessions list
+wa-mvp-api --remote http://gw:9090 token rotate abc123</pre>
+          <p>With the server stopped, sessions can be exported and imported offline; the passphrase is read from <code>ARCHIVE_PASSPHRASE</code>:</p>
+          <pre>ARCHIVE_PASSPHRASE=... wa-mvp-api export abc123 abc123.wasession
+ARCHIVE_PASSPHRASE=... wa-mvp-api import abc123.wasession</pre>
         </div>
 
-        <div class="card section" id="receive">
-          <h2>Receive Messages (Polling) <span class="tag">GET</span></h2>
-          <p>Returns and clears queued incoming messages for the session. Call this endpoint periodically to fetch new messages.</p>
-          <pre>curl http://localhost:9090/session/receive \
-  -H "Authorization: Bearer YOUR_TOKEN"</pre>
-          <p>Optional limit:</p>
-          <pre>curl "http://localhost:9090/session/receive?limit=50" \
-  -H "Authorization: Bearer YOUR_TOKEN"</pre>
-          <p>Response:</p>
-          <pre>{"messages":[{"from":"919xxxxxxx","name":"Contact Name","message":"hello","timestamp":1700000000}]}</pre>
-          <p class="warn">Notes: Only text messages are captured. Media is ignored. Messages are stored in memory and removed when fetched.</p>
+        <div class="card section" id="encryption">
+          <h2>Encryption at Rest</h2>
+          <p>Set <code>MASTER_KEY</code> (or <code>MASTER_KEY_FILE</code>) to a base64 32-byte key to encrypt message bodies, sender names and session settings. Each session has its own data key, stored wrapped by the master key; bearer tokens are only stored as hashes. To rotate, stop the server, run the command below with the current key in <code>MASTER_KEY</code> and the new one in <code>NEW_MASTER_KEY</code>, and restart with the new key. The same command encrypts data written before encryption was enabled. Files under <code>store/</code> are created readable by the gateway user only.</p>
+          <pre>NEW_MASTER_KEY=$(wa-mvp-api key generate)
+MASTER_KEY=OLD_KEY NEW_MASTER_KEY=$NEW_MASTER_KEY wa-mvp-api key rotate</pre>
         </div>
       </main>
     </div>
   </div>
 </body>
-</html>`
+</html>`))
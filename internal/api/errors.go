@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"wa-mvp-api/internal/crypt"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+)
+
+// errorResponse is the body of every error answer. Clients branch on Code;
+// Message is for humans and may change.
+type errorResponse struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Codes for errors raised by the API itself rather than the session package.
+const (
+	codeInvalidRequest = "invalid_request"
+	codeUnauthorized   = "unauthorized"
+	codeForbidden      = "forbidden"
+	codeNotFound       = "not_found"
+	codeInvalidLink    = "invalid_link"
+	codeTooLarge       = "payload_too_large"
+	codeWeakPassphrase = "weak_passphrase"
+	codeInternal       = "internal_error"
+)
+
+// errorStatus maps every error code to its HTTP status.
+var errorStatus = map[string]int{
+	session.CodeSessionNotFound:  http.StatusNotFound,
+	session.CodeSessionExists:    http.StatusConflict,
+	session.CodeNotConnected:     http.StatusConflict,
+	session.CodeNotLoggedIn:      http.StatusConflict,
+	session.CodeAlreadyPaired:    http.StatusConflict,
+	session.CodeSuspended:        http.StatusConflict,
+	session.CodeQRNotAvailable:   http.StatusConflict,
+	session.CodeInvalidRecipient: http.StatusBadRequest,
+	session.CodeInvalidPhone:     http.StatusBadRequest,
+	session.CodeInvalidJID:       http.StatusBadRequest,
+	session.CodeContactNotFound:  http.StatusNotFound,
+	session.CodeInvalidProfile:   http.StatusBadRequest,
+	session.CodeInvalidImage:     http.StatusBadRequest,
+	session.CodeInvalidPrivacy:   http.StatusBadRequest,
+	session.CodeInvalidOptions:   http.StatusBadRequest,
+	session.CodeInvalidArchive:   http.StatusBadRequest,
+	session.CodeRateLimited:      http.StatusTooManyRequests,
+	session.CodeTooManyWaiters:   http.StatusTooManyRequests,
+	session.CodeLinkNotFound:     http.StatusNotFound,
+	session.CodeLinkExpired:      http.StatusGone,
+	session.CodeLinkUsed:         http.StatusGone,
+	session.CodeShuttingDown:     http.StatusServiceUnavailable,
+	session.CodeUpstream:         http.StatusBadGateway,
+
+	codeInvalidRequest: http.StatusBadRequest,
+	codeUnauthorized:   http.StatusUnauthorized,
+	codeForbidden:      http.StatusForbidden,
+	codeNotFound:       http.StatusNotFound,
+	codeInvalidLink:    http.StatusUnauthorized,
+	codeTooLarge:       http.StatusRequestEntityTooLarge,
+	codeWeakPassphrase: http.StatusBadRequest,
+	codeInternal:       http.StatusInternalServerError,
+}
+
+// classifyError returns the code and details for err. Errors that are not
+// meant for clients come back as codeInternal.
+func classifyError(err error) (string, map[string]any) {
+	var serr *session.Error
+	switch {
+	case errors.As(err, &serr):
+		return serr.Code, serr.Details
+	case errors.Is(err, crypt.ErrWeakPassphrase):
+		return codeWeakPassphrase, nil
+	case errors.Is(err, ErrLinkInvalid):
+		return codeInvalidLink, nil
+	case errors.Is(err, ErrLinkExpired):
+		return session.CodeLinkExpired, nil
+	}
+	return codeInternal, nil
+}
+
+// writeError answers with the status for err's code. Internal errors are
+// logged and their message is not passed on.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, details := classifyError(err)
+	message := err.Error()
+	if code == codeInternal {
+		loggerFromContext(r).ErrorContext(r.Context(), "request failed", "error", err)
+		message = "internal error"
+	}
+	writeErrorDetails(w, r, code, message, details)
+}
+
+// writeErrorCode answers with an error raised by the API itself.
+func writeErrorCode(w http.ResponseWriter, r *http.Request, code string, message string) {
+	writeErrorDetails(w, r, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, code string, message string, details map[string]any) {
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if secs, ok := details["retry_after_seconds"].(int); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	}
+	writeJSON(w, status, errorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: logging.RequestID(r.Context()),
+	})
+}
+
+// errorStatusFor is the status writeError would use for err.
+func errorStatusFor(err error) int {
+	code, _ := classifyError(err)
+	return errorStatus[code]
+}
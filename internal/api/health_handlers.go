@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/session"
+)
+
+// readyzPingTimeout bounds the database check, so that a probe gets an
+// answer while the database is unreachable or busy.
+const readyzPingTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func RegisterHealthRoutes(r chi.Router) {
+	r.Get("/healthz", handleHealthz)
+	r.Get("/readyz", handleReadyz)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+
+	if session.GetManager().Ready() {
+		checks["restore"] = "ok"
+	} else {
+		checks["restore"] = "pending"
+		ready = false
+	}
+
+	if err := session.CheckStoreWritable(); err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "store not writable", "error", err)
+		checks["store"] = err.Error()
+		ready = false
+	} else {
+		checks["store"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyzPingTimeout)
+	defer cancel()
+	if err := session.GetManager().PingStore(ctx); err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "database unreachable", "error", err)
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Checks: checks})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", Checks: checks})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"wa-mvp-api/internal/session"
+)
+
+func TestReadyzPingsDatabase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := session.OpenSQLStore(context.Background(), session.DialectSQLite, "file:"+filepath.Join(t.TempDir(), "gateway.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := session.GetManager()
+	m.UseStore(st)
+	t.Cleanup(func() { m.UseStore(nil) })
+	if err := m.RestoreSessionsOnStartup(); err != nil {
+		t.Fatal(err)
+	}
+
+	readyz := func() (int, healthResponse) {
+		rec := httptest.NewRecorder()
+		handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp healthResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := readyz(); code != http.StatusOK || resp.Checks["database"] != "ok" {
+		t.Errorf("readyz = %d %+v, want 200 with the database ok", code, resp)
+	}
+
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Checks["database"] == "ok" {
+		t.Errorf("readyz with the database closed = %d %+v, want 503 with a database error", code, resp)
+	}
+}
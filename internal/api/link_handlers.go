@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+)
+
+// Onboarding links are one-time links to the pairing page for a single
+// session. Unlike QR page links they are stored, so they can be listed,
+// audited and invalidated once the session pairs.
+
+const onboardLink = "onboard"
+
+type linkKey struct{}
+
+type createLinkRequest struct {
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+type createLinkResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type linkInfo struct {
+	session.Link
+	Status string `json:"status"`
+}
+
+type listLinksResponse struct {
+	Links []linkInfo `json:"links"`
+}
+
+type pairCodeRequest struct {
+	Phone string `json:"phone"`
+}
+
+type pairCodeResponse struct {
+	Code string `json:"code"`
+}
+
+// handleCreateLink issues an onboarding link for the caller's session.
+func handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req createLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if req.TTLSeconds != 0 && (ttl < time.Minute || ttl > session.MaxLinkTTL) {
+		writeErrorCode(w, r, codeInvalidRequest, "ttl_seconds must be between 60 and 604800")
+		return
+	}
+
+	link, err := session.GetManager().CreateLink(r.Context(), sess.ID, ttl)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	token := signLink(onboardLink, link.ID, link.ExpiresAt)
+	writeJSON(w, http.StatusCreated, createLinkResponse{
+		ID:        link.ID,
+		URL:       linkURL(r, apiPath(r, "/link"), token),
+		ExpiresAt: link.ExpiresAt,
+	})
+}
+
+// handleListLinks returns the caller's onboarding links with their audit
+// trail.
+func handleListLinks(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	links, err := session.GetManager().ListLinks(r.Context(), sess.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now()
+	resp := listLinksResponse{Links: make([]linkInfo, 0, len(links))}
+	for _, link := range links {
+		resp.Links = append(resp.Links, linkInfo{Link: link, Status: link.Status(now)})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// authOnboardLink authenticates requests by an onboarding link token in ?t=.
+// Used and expired links are refused, except that the page's state poll is
+// told the session is linked so it can finish.
+func authOnboardLink(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, err := verifyLink(onboardLink, r.URL.Query().Get("t"))
+		if err != nil {
+			writeLinkError(w, r, err)
+			return
+		}
+		link, sess, err := session.GetManager().ResolveLink(r.Context(), id)
+		switch {
+		case errors.Is(err, session.ErrLinkUsed) && routePath(r) == "/link/state":
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, qrPageState{State: "linked"})
+			return
+		case err != nil:
+			writeLinkError(w, r, err)
+			return
+		}
+
+		setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, sess.ID))
+		ctx := context.WithValue(r.Context(), sessionKey{}, sess)
+		ctx = context.WithValue(ctx, linkKey{}, link)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// handleLinkPage records who opened the link and serves the pairing page.
+func handleLinkPage(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	link, ok := r.Context().Value(linkKey{}).(session.Link)
+	if sess == nil || !ok {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	forwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+	err := session.GetManager().RecordLinkOpen(r.Context(), link, session.LinkOpen{
+		RemoteAddr:   remote,
+		ForwardedFor: strings.TrimSpace(forwarded),
+		UserAgent:    r.UserAgent(),
+	})
+	if err != nil {
+		loggerFromContext(r).ErrorContext(r.Context(), "record link open failed", "error", err)
+	}
+
+	token := url.Values{"t": {r.URL.Query().Get("t")}}.Encode()
+	servePairingPage(w, r, sess, qrPageData{
+		StateURL:    apiPath(r, "/link/state?"+token),
+		RetryURL:    apiPath(r, "/link/retry?"+token),
+		PairCodeURL: apiPath(r, "/link/pair-code?"+token),
+	})
+}
+
+// handleLinkPairCode requests a pairing code for the phone number entered on
+// the onboarding page.
+func handleLinkPairCode(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req pairCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	code, err := session.GetManager().PairPhone(r.Context(), sess.ID, req.Phone)
+	if err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "pairing code request failed", "error", err)
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pairCodeResponse{Code: code})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Links let a browser open a session page without the bearer token. They
+// carry a subject (a session or link ID) and an expiry, signed with a server
+// secret.
+
+var (
+	ErrLinkInvalid = errors.New("invalid link")
+	ErrLinkExpired = errors.New("link expired")
+)
+
+var links struct {
+	mu        sync.RWMutex
+	key       []byte
+	publicURL string
+}
+
+func init() {
+	links.key = make([]byte, 32)
+	if _, err := rand.Read(links.key); err != nil {
+		panic(err)
+	}
+}
+
+// ConfigureLinks sets the secret used to sign links and the public base URL
+// they are built on. Without a secret a random one is used, so links stop
+// working when the process restarts. Without a public URL links are built
+// from the request's Host header.
+func ConfigureLinks(secret string, publicURL string) {
+	links.mu.Lock()
+	defer links.mu.Unlock()
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		links.key = sum[:]
+	}
+	links.publicURL = strings.TrimRight(publicURL, "/")
+}
+
+func linkMAC(payload string) []byte {
+	links.mu.RLock()
+	mac := hmac.New(sha256.New, links.key)
+	links.mu.RUnlock()
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// signLink returns a token for purpose and subject that is valid until
+// expires.
+func signLink(purpose string, subject string, expires time.Time) string {
+	payload := purpose + "|" + subject + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(linkMAC(payload))
+}
+
+// verifyLink checks a token made by signLink and returns its subject and
+// expiry.
+func verifyLink(purpose string, token string) (string, time.Time, error) {
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encMAC)
+	if err != nil {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+	payload := string(rawPayload)
+	if !hmac.Equal(sum, linkMAC(payload)) {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 || parts[0] != purpose {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+	unix, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrLinkInvalid
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return "", expires, ErrLinkExpired
+	}
+	return parts[1], expires, nil
+}
+
+// linkURL builds an absolute URL for path with the link token as ?t=.
+func linkURL(r *http.Request, path string, token string) string {
+	links.mu.RLock()
+	base := links.publicURL
+	links.mu.RUnlock()
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + path + "?t=" + token
+}
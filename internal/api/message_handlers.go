@@ -0,0 +1,382 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/session"
+)
+
+const (
+	defaultReceiveLimit = 20
+	maxReceiveLimit     = 100
+	defaultListLimit    = 50
+	maxListLimit        = 200
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// messageInfo is the v2 message model. Unlike v1's IncomingMessage it keeps
+// the chat and full JIDs, says who sent it, and carries the cursor to resume
+// after it.
+type messageInfo struct {
+	ID         string    `json:"id"`
+	Chat       string    `json:"chat"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"`
+	FromMe     bool      `json:"from_me"`
+	Type       string    `json:"type"`
+	Text       string    `json:"text"`
+	Timestamp  time.Time `json:"timestamp"`
+	Cursor     string    `json:"cursor,omitempty"`
+}
+
+type sendMessageV2Response struct {
+	Message messageInfo `json:"message"`
+}
+
+type receiveMessagesV2Response struct {
+	Messages []messageInfo `json:"messages"`
+	// NextCursor is passed back as ?cursor= to acknowledge these messages
+	// and get the following ones.
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+type listMessagesResponse struct {
+	Messages []messageInfo `json:"messages"`
+	// NextCursor is passed back as ?cursor= for the next, older page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+type conversationResponse struct {
+	Chat string `json:"chat"`
+	// Messages are in the order they were exchanged; NextCursor pages back
+	// to older ones.
+	Messages   []messageInfo `json:"messages"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	HasMore    bool          `json:"has_more"`
+}
+
+func newMessageInfo(msg session.Message) messageInfo {
+	info := messageInfo{
+		ID:         msg.ID,
+		Chat:       msg.Chat,
+		Sender:     msg.Sender,
+		SenderName: msg.SenderName,
+		FromMe:     msg.FromMe,
+		Type:       msg.Type,
+		Text:       msg.Text,
+		Timestamp:  time.Unix(msg.Timestamp, 0).UTC(),
+	}
+	if msg.Seq > 0 {
+		info.Cursor = encodeCursor(msg.Seq)
+	}
+	return info
+}
+
+// Cursors are opaque to clients; they encode a message's sequence number.
+func encodeCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("m" + strconv.FormatInt(seq, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) < 2 || raw[0] != 'm' {
+		return 0, errInvalidCursor
+	}
+	seq, err := strconv.ParseInt(string(raw[1:]), 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, errInvalidCursor
+	}
+	return seq, nil
+}
+
+// Page cursors continue a listing after a message; they encode its
+// position.
+func encodePageCursor(pos session.MessagePosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("p%d.%d", pos.Timestamp, pos.Seq)))
+}
+
+func decodePageCursor(cursor string) (*session.MessagePosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) < 2 || raw[0] != 'p' {
+		return nil, errInvalidCursor
+	}
+	ts, seq, ok := strings.Cut(string(raw[1:]), ".")
+	pos := &session.MessagePosition{}
+	if pos.Timestamp, err = strconv.ParseInt(ts, 10, 64); !ok || err != nil {
+		return nil, errInvalidCursor
+	}
+	if pos.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil || pos.Seq <= 0 {
+		return nil, errInvalidCursor
+	}
+	return pos, nil
+}
+
+// parseLimit reads ?limit=, which must be between 1 and maxLimit.
+func parseLimit(r *http.Request, def int, maxLimit int) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 || v > maxLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+	}
+	return v, nil
+}
+
+// parseWait reads ?wait=, a duration such as 30s or a number of seconds, up
+// to session.MaxReceiveWait.
+func parseWait(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil {
+		secs, serr := strconv.Atoi(raw)
+		if serr != nil {
+			return 0, errors.New("wait must be a duration such as 30s")
+		}
+		wait = time.Duration(secs) * time.Second
+	}
+	if wait < 0 || wait > session.MaxReceiveWait {
+		return 0, fmt.Errorf("wait must be between 0s and %s", session.MaxReceiveWait)
+	}
+	return wait, nil
+}
+
+// handleReceiveMessagesV2 returns inbound messages after ?cursor=. Passing a
+// cursor acknowledges everything up to it; without one the queued messages
+// are returned. Messages stay queued until acknowledged, so a client that
+// fails to process a batch gets it again. ?wait= works as in v1.
+func handleReceiveMessagesV2(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	limit, err := parseLimit(r, defaultReceiveLimit, maxReceiveLimit)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	var after int64
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if after, err = decodeCursor(cursor); err != nil {
+			writeErrorCode(w, r, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	wait, err := parseWait(r)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+
+	// Ask for one more than the limit to learn whether more are waiting.
+	var msgs []session.Message
+	err = sess.WaitMessages(r.Context(), wait, func() (bool, error) {
+		var err error
+		if msgs, err = sess.ReceiveMessages(r.Context(), after, limit+1); err != nil {
+			return false, fmt.Errorf("read messages: %w", err)
+		}
+		return len(msgs) > 0, nil
+	})
+	if r.Context().Err() != nil {
+		return // the client went away while waiting
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := receiveMessagesV2Response{Messages: make([]messageInfo, 0, len(msgs))}
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+		resp.HasMore = true
+	}
+	for _, msg := range msgs {
+		resp.Messages = append(resp.Messages, newMessageInfo(msg))
+	}
+	switch {
+	case len(msgs) > 0:
+		resp.NextCursor = encodeCursor(msgs[len(msgs)-1].Seq)
+	case after > 0:
+		resp.NextCursor = encodeCursor(after)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseBool reads an optional boolean query parameter.
+func parseBool(r *http.Request, name string) (*bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &value, nil
+}
+
+// parseTime accepts RFC 3339 times and Unix timestamps in seconds.
+func parseTime(raw string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// parseMessageFilter reads the filters of GET /session/messages.
+func parseMessageFilter(r *http.Request) (session.MessageFilter, error) {
+	query := r.URL.Query()
+	var filter session.MessageFilter
+	var err error
+	if raw := query.Get("chat"); raw != "" {
+		if filter.Chat, err = session.ParseJID(raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("sender"); raw != "" {
+		if filter.Sender, err = session.ParseJID(raw); err != nil {
+			return filter, err
+		}
+	}
+	for _, value := range query["type"] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t == "" {
+				continue
+			}
+			if !slices.Contains(session.MessageTypes, t) {
+				return filter, fmt.Errorf("type must be one of %s", strings.Join(session.MessageTypes, ", "))
+			}
+			filter.Types = append(filter.Types, t)
+		}
+	}
+	if filter.FromMe, err = parseBool(r, "from_me"); err != nil {
+		return filter, err
+	}
+	for key, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := query.Get(key); raw != "" {
+			if *dst, err = parseTime(raw); err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time or a Unix timestamp", key)
+			}
+		}
+	}
+	filter.Text = strings.TrimSpace(query.Get("text"))
+	filter.Search = strings.TrimSpace(query.Get("q"))
+	if raw := query.Get("cursor"); raw != "" {
+		if filter.After, err = decodePageCursor(raw); err != nil {
+			return filter, err
+		}
+	}
+	if filter.Limit, err = parseLimit(r, defaultListLimit, maxListLimit); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// listMessages runs filter with one extra message to learn whether there are
+// more, and returns the page with the cursor for the next one.
+func listMessages(r *http.Request, sess *session.Session, filter session.MessageFilter) ([]session.Message, string, bool, error) {
+	limit := filter.Limit
+	filter.Limit++
+	msgs, err := sess.ListMessages(r.Context(), filter)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("list messages: %w", err)
+	}
+	if len(msgs) <= limit {
+		return msgs, "", false, nil
+	}
+	msgs = msgs[:limit]
+	return msgs, encodePageCursor(msgs[len(msgs)-1].Position()), true, nil
+}
+
+// handleListMessages searches the session's stored messages, newest first.
+func handleListMessages(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		writeFilterError(w, r, err)
+		return
+	}
+	msgs, next, more, err := listMessages(r, sess, filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := listMessagesResponse{Messages: make([]messageInfo, 0, len(msgs)), NextCursor: next, HasMore: more}
+	for _, msg := range msgs {
+		resp.Messages = append(resp.Messages, newMessageInfo(msg))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetConversation returns the latest messages of one chat in both
+// directions, oldest first, paging back with ?cursor=.
+func handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	chat, err := session.ParseJID(chi.URLParam(r, "chat"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter := session.MessageFilter{Chat: chat}
+	if raw := r.URL.Query().Get("cursor"); raw != "" {
+		if filter.After, err = decodePageCursor(raw); err != nil {
+			writeErrorCode(w, r, codeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if filter.Limit, err = parseLimit(r, defaultListLimit, maxListLimit); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	msgs, next, more, err := listMessages(r, sess, filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := conversationResponse{Chat: chat, Messages: make([]messageInfo, len(msgs)), NextCursor: next, HasMore: more}
+	for i, msg := range msgs {
+		resp.Messages[len(msgs)-1-i] = newMessageInfo(msg)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writeFilterError answers with err's own code for session errors and as an
+// invalid request otherwise.
+func writeFilterError(w http.ResponseWriter, r *http.Request, err error) {
+	var serr *session.Error
+	if errors.As(err, &serr) {
+		writeError(w, r, err)
+		return
+	}
+	writeErrorCode(w, r, codeInvalidRequest, err.Error())
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"wa-mvp-api/internal/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+const maxRequestIDLength = 128
+
+type loggerKey struct{}
+
+// requestLog is shared by pointer so that middleware further down the chain
+// (e.g. authSession) can enrich the logger used for the final access log.
+type requestLog struct {
+	log *slog.Logger
+}
+
+// RequestLogger assigns every request an ID (propagating a valid incoming
+// X-Request-ID), echoes it in the response and logs the request on completion.
+func RequestLogger(log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+
+			rl := &requestLog{log: log}
+			ctx := logging.WithRequestID(r.Context(), id)
+			ctx = context.WithValue(ctx, loggerKey{}, rl)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			rl.log.InfoContext(ctx, "http request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"duration", time.Since(start),
+				"remote", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+func loggerFromContext(r *http.Request) *slog.Logger {
+	if rl, ok := r.Context().Value(loggerKey{}).(*requestLog); ok {
+		return rl.log
+	}
+	return slog.Default()
+}
+
+func setRequestLogger(r *http.Request, log *slog.Logger) {
+	if rl, ok := r.Context().Value(loggerKey{}).(*requestLog); ok {
+		rl.log = log
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/session"
+)
+
+// The OpenAPI document is built from apiOperations: each entry names a route
+// and gives example values of its request and response types, from which the
+// schemas are derived by reflection. The docs page is rendered from the same
+// document, and checkOpenAPI, run by the tests, makes sure no route is left
+// out.
+
+type apiAuth string
+
+const (
+	securityNone    apiAuth = ""
+	securitySession apiAuth = "session"
+	securityAdmin   apiAuth = "admin"
+	securityLink    apiAuth = "link"
+)
+
+// apiOperation describes one route.
+type apiOperation struct {
+	ID          string
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Auth        apiAuth
+	Params      []apiParam
+
+	// Request and Response are example values; their types give the schemas.
+	// A nil Request means the route takes no body.
+	Request        any
+	RequestContent string
+	Response       any
+	// ResponseStatus defaults to 200 and ResponseContent to JSON.
+	ResponseStatus  int
+	ResponseContent string
+	// AltContent lists other media types the route can answer with.
+	AltContent []string
+	Errors     []int
+
+	// Path is relative to the version prefix. Versions lists the versions
+	// serving the route, all of apiVersions when empty; Unversioned routes
+	// are served at the root only.
+	Versions    []int
+	Unversioned bool
+}
+
+type apiParam struct {
+	Name        string
+	In          string // query, path or header
+	Description string
+	Example     string
+	Required    bool
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []openAPITag                            `json:"tags"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPITag struct {
+	Name string `json:"name"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Tags        []string                    `json:"tags"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+	Example     string      `json:"example,omitempty"`
+}
+
+type openAPIBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema  *jsonSchema     `json:"schema,omitempty"`
+	Example json.RawMessage `json:"example,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// schemaEnums lists the values of string types with a fixed set of values.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(session.ConnState("")): {
+		string(session.StateCreated), string(session.StateConnecting), string(session.StateConnected),
+		string(session.StateDisconnected), string(session.StateLoggedOut), string(session.StateSuspended),
+	},
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder turns Go types into JSON schemas, registering named structs
+// as components.
+type schemaBuilder struct {
+	schemas map[string]*jsonSchema
+	names   map[reflect.Type]string
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if values, ok := schemaEnums[t]; ok {
+		return &jsonSchema{Type: "string", Enum: values}
+	}
+	switch {
+	case t == timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case t == rawType:
+		return &jsonSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &jsonSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name, ok := b.names[t]
+		if !ok {
+			name = strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+			b.names[t] = name
+			b.schemas[name] = &jsonSchema{}
+			*b.schemas[name] = *b.object(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &jsonSchema{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	b.addFields(s, t)
+	return s
+}
+
+// addFields adds t's JSON fields to s, flattening embedded structs the way
+// encoding/json does.
+func (b *schemaBuilder) addFields(s *jsonSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = b.schema(f.Type)
+	}
+}
+
+func mediaType(b *schemaBuilder, content string, example any) *openAPIMediaType {
+	if content != "application/json" {
+		if content == "application/octet-stream" || strings.HasPrefix(content, "image/png") {
+			return &openAPIMediaType{Schema: &jsonSchema{Type: "string", Format: "binary"}}
+		}
+		return &openAPIMediaType{Schema: &jsonSchema{Type: "string"}}
+	}
+	if example == nil {
+		return &openAPIMediaType{}
+	}
+	m := &openAPIMediaType{Schema: b.schema(reflect.TypeOf(example))}
+	if raw, err := json.Marshal(example); err == nil {
+		m.Example = raw
+	}
+	return m
+}
+
+// versionedOperations expands ops into one operation per version, with the
+// version prefix on the path. Copies of an operation shared by several
+// versions get the version appended to their ID, except the oldest.
+func versionedOperations(ops []apiOperation) []apiOperation {
+	var out []apiOperation
+	for _, op := range ops {
+		if op.Unversioned {
+			out = append(out, op)
+			continue
+		}
+		versions := op.Versions
+		if len(versions) == 0 {
+			versions = apiVersions
+		}
+		for i, version := range versions {
+			v := op
+			v.Path = versionPrefix(version) + op.Path
+			if i > 0 {
+				v.ID = fmt.Sprintf("%sV%d", op.ID, version)
+			}
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func buildOpenAPI(ops []apiOperation) *openAPIDocument {
+	b := &schemaBuilder{schemas: map[string]*jsonSchema{}, names: map[reflect.Type]string{}}
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "WA MVP API",
+			Version:     "1.0.0",
+			Description: "Multi-session WhatsApp gateway. Session endpoints take the bearer token returned by `POST /v1/sessions`. The v1 routes are also served without the `/v1` prefix; those aliases are deprecated.",
+		},
+		Paths: map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: b.schemas,
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				string(securitySession): {Type: "http", Scheme: "bearer", Description: "Session token returned when the session is created."},
+				string(securityAdmin):   {Type: "http", Scheme: "bearer", Description: "The server's ADMIN_TOKEN."},
+				string(securityLink):    {Type: "apiKey", In: "query", Name: "t", Description: "Signed token from a QR page or onboarding link."},
+			},
+		},
+	}
+	errorMedia := mediaType(b, "application/json", errorResponse{
+		Code:      session.CodeSessionNotFound,
+		Message:   "session not found",
+		RequestID: "38e40cc7c5ea0be8",
+	})
+
+	seenTags := map[string]bool{}
+	for _, op := range ops {
+		if !seenTags[op.Tag] {
+			seenTags[op.Tag] = true
+			doc.Tags = append(doc.Tags, openAPITag{Name: op.Tag})
+		}
+		o := &openAPIOperation{
+			OperationID: op.ID,
+			Tags:        []string{op.Tag},
+			Summary:     op.Summary,
+			Description: op.Description,
+			Responses:   map[string]*openAPIResponse{},
+		}
+		if op.Auth != securityNone {
+			o.Security = []map[string][]string{{string(op.Auth): {}}}
+		}
+		for _, p := range op.Params {
+			o.Parameters = append(o.Parameters, openAPIParameter{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				Required:    p.Required || p.In == "path",
+				Schema:      &jsonSchema{Type: "string"},
+				Example:     p.Example,
+			})
+		}
+		if op.Request != nil || op.RequestContent != "" {
+			content := op.RequestContent
+			if content == "" {
+				content = "application/json"
+			}
+			o.RequestBody = &openAPIBody{
+				Required: op.RequestContent != "",
+				Content:  map[string]*openAPIMediaType{content: mediaType(b, content, op.Request)},
+			}
+		}
+
+		status := op.ResponseStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := &openAPIResponse{Description: http.StatusText(status), Content: map[string]*openAPIMediaType{}}
+		content := op.ResponseContent
+		if content == "" {
+			content = "application/json"
+		}
+		resp.Content[content] = mediaType(b, content, op.Response)
+		for _, alt := range op.AltContent {
+			resp.Content[alt] = mediaType(b, alt, nil)
+		}
+		o.Responses[fmt.Sprint(status)] = resp
+		for _, code := range op.Errors {
+			o.Responses[fmt.Sprint(code)] = &openAPIResponse{
+				Description: http.StatusText(code),
+				Content:     map[string]*openAPIMediaType{"application/json": errorMedia},
+			}
+		}
+
+		if doc.Paths[op.Path] == nil {
+			doc.Paths[op.Path] = map[string]*openAPIOperation{}
+		}
+		doc.Paths[op.Path][strings.ToLower(op.Method)] = o
+	}
+	return doc
+}
+
+var openAPI struct {
+	once sync.Once
+	doc  *openAPIDocument
+	json []byte
+}
+
+func openAPISpec() (*openAPIDocument, []byte) {
+	openAPI.once.Do(func() {
+		openAPI.doc = buildOpenAPI(versionedOperations(apiOperations))
+		raw, err := json.MarshalIndent(openAPI.doc, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		openAPI.json = raw
+	})
+	return openAPI.doc, openAPI.json
+}
+
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	_, raw := openAPISpec()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(raw)
+}
+
+// checkOpenAPI compares the routes registered on r with the OpenAPI
+// document and reports routes without a spec entry and entries without a
+// route. The legacy aliases of the v1 routes are expected too.
+func checkOpenAPI(r chi.Routes) error {
+	documented := map[string]bool{}
+	for _, op := range versionedOperations(apiOperations) {
+		documented[op.Method+" "+op.Path] = true
+	}
+	legacy := versionPrefix(1)
+	for key := range documented {
+		method, path, _ := strings.Cut(key, " ")
+		if rest, ok := strings.CutPrefix(path, legacy+"/"); ok {
+			documented[method+" /"+rest] = true
+		}
+	}
+
+	var missing []string
+	err := chi.Walk(r, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + route
+		if documented[key] {
+			delete(documented, key)
+		} else {
+			missing = append(missing, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stale := make([]string, 0, len(documented))
+	for key := range documented {
+		stale = append(stale, key)
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "routes missing from the OpenAPI spec: "+strings.Join(missing, ", "))
+	}
+	if len(stale) > 0 {
+		problems = append(problems, "OpenAPI entries without a route: "+strings.Join(stale, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestOpenAPICoversRoutes checks that each route the server serves has a
+// spec entry and each entry a route.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	r := NewRouter(slog.New(slog.NewTextHandler(io.Discard, nil)), "admin-token")
+	if err := checkOpenAPI(r); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/session"
+)
+
+type blocklistResponse struct {
+	JIDs []string `json:"jids"`
+}
+
+func handleGetPrivacy(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	settings, err := sess.GetPrivacySettings(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// handleUpdatePrivacy changes the settings present in the body and answers
+// with all of them.
+func handleUpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req session.PrivacyUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	settings, err := sess.UpdatePrivacySettings(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func handleGetBlocklist(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	jids, err := sess.GetBlocklist(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, blocklistResponse{JIDs: jids})
+}
+
+func handleBlockContact(w http.ResponseWriter, r *http.Request) {
+	setBlocked(w, r, true)
+}
+
+func handleUnblockContact(w http.ResponseWriter, r *http.Request) {
+	setBlocked(w, r, false)
+}
+
+func setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	jid, err := session.ParseJID(chi.URLParam(r, "jid"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	jids, err := sess.SetBlocked(r.Context(), jid, blocked)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, blocklistResponse{JIDs: jids})
+}
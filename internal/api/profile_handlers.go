@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+type profilePictureResponse struct {
+	PictureID string `json:"picture_id"`
+}
+
+func handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	profile, err := sess.GetProfile(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// handleUpdateProfile changes the fields present in the body and answers with
+// the resulting profile.
+func handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req session.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	if err := sess.UpdateProfile(r.Context(), req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	profile, err := sess.GetProfile(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// handleSetProfilePicture takes the image as the request body, or as the
+// "image" field of a multipart form.
+func handleSetProfilePicture(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	image, err := readPicture(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeErrorCode(w, r, codeTooLarge, "image too large")
+		} else {
+			writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		}
+		return
+	}
+	id, err := sess.SetProfilePicture(r.Context(), image)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, profilePictureResponse{PictureID: id})
+}
+
+func handleDeleteProfilePicture(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, err := sess.SetProfilePicture(r.Context(), nil); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Status: "removed"})
+}
+
+func readPicture(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, whatsapp.MaxPictureUpload)
+	var src io.Reader = body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		r.Body = body
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, err
+			}
+			return nil, errors.New("multipart form needs an image field")
+		}
+		defer file.Close()
+		src = file
+	}
+	image, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(image) == 0 {
+		return nil, errors.New("image is empty")
+	}
+	return image, nil
+}
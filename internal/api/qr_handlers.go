@@ -0,0 +1,474 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+const (
+	qrPageLink = "qr-page"
+
+	defaultQRLinkTTL = 10 * time.Minute
+	maxQRLinkTTL     = time.Hour
+)
+
+type sessionQRResponse struct {
+	QR        string     `json:"qr"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type qrLinkRequest struct {
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+type qrLinkResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// qrPageState is polled by the QR page. State is one of waiting (no code
+// yet), code, expired (WhatsApp stopped issuing codes) or linked.
+type qrPageState struct {
+	State     string `json:"state"`
+	SVG       string `json:"svg,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+	JID       string `json:"jid,omitempty"`
+}
+
+// qrPageData fills the pairing page template. The phone number form is only
+// shown when PairCodeURL is set.
+type qrPageData struct {
+	Title       string
+	StateURL    string
+	RetryURL    string
+	PairCodeURL string
+}
+
+// qrOptions reads ?size= (pixels) and ?ecc= (low, medium, high, highest).
+func qrOptions(r *http.Request) (whatsapp.QROptions, error) {
+	query := r.URL.Query()
+	opts := whatsapp.QROptions{Size: whatsapp.DefaultQRSize}
+	if raw := query.Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < whatsapp.MinQRSize || size > whatsapp.MaxQRSize {
+			return opts, errors.New("size must be between " + strconv.Itoa(whatsapp.MinQRSize) + " and " + strconv.Itoa(whatsapp.MaxQRSize))
+		}
+		opts.Size = size
+	}
+	level, err := whatsapp.ParseQRLevel(query.Get("ecc"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Level = level
+	return opts, nil
+}
+
+// handleGetSessionQR renders the current pairing code. Without ?format= it
+// returns a PNG data URI in JSON, as it always has; png, svg and ascii return
+// the image itself and raw returns the code string for client-side rendering.
+func handleGetSessionQR(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	opts, err := qrOptions(r)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "png", "svg", "raw", "ascii":
+	default:
+		writeErrorCode(w, r, codeInvalidRequest, "format must be png, svg, raw or ascii")
+		return
+	}
+
+	status := sess.QRStatus()
+	if status.Code == "" {
+		err := error(session.ErrQRNotAvailable)
+		if status.LoggedIn {
+			err = session.ErrPaired
+		}
+		writeError(w, r, err)
+		return
+	}
+	var expiresAt *time.Time
+	if !status.ExpiresAt.IsZero() {
+		expiresAt = &status.ExpiresAt
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	switch format {
+	case "":
+		png, err := whatsapp.QRToPNG(status.Code, opts)
+		if err != nil {
+			writeQRError(w, r, err)
+			return
+		}
+		uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+		writeJSON(w, http.StatusOK, sessionQRResponse{QR: uri, ExpiresAt: expiresAt})
+	case "raw":
+		writeJSON(w, http.StatusOK, sessionQRResponse{QR: status.Code, ExpiresAt: expiresAt})
+	case "png":
+		png, err := whatsapp.QRToPNG(status.Code, opts)
+		if err != nil {
+			writeQRError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	case "svg":
+		svg, err := whatsapp.QRToSVG(status.Code, opts)
+		if err != nil {
+			writeQRError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_, _ = io.WriteString(w, svg)
+	case "ascii":
+		text, err := whatsapp.QRToTerminal(status.Code, opts)
+		if err != nil {
+			writeQRError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, text)
+	}
+}
+
+func writeQRError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, fmt.Errorf("render QR code: %w", err))
+}
+
+// handleCreateQRLink returns a short-lived link to the QR page, which can be
+// opened in a browser without the bearer token.
+func handleCreateQRLink(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req qrLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	ttl := defaultQRLinkTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl < time.Minute || ttl > maxQRLinkTTL {
+			writeErrorCode(w, r, codeInvalidRequest, "ttl_seconds must be between 60 and 3600")
+			return
+		}
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token := signLink(qrPageLink, sess.ID, expires)
+	writeJSON(w, http.StatusOK, qrLinkResponse{
+		URL:       linkURL(r, apiPath(r, "/session/qr/page"), token),
+		ExpiresAt: expires,
+	})
+}
+
+// authLink authenticates requests by a link token in ?t= instead of a bearer
+// token.
+func authLink(purpose string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _, err := verifyLink(purpose, r.URL.Query().Get("t"))
+			if err != nil {
+				writeLinkError(w, r, err)
+				return
+			}
+			sess, ok := session.GetManager().GetSession(id)
+			if !ok {
+				writeLinkError(w, r, session.ErrSessionNotFound)
+				return
+			}
+
+			setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, sess.ID))
+			ctx := context.WithValue(r.Context(), sessionKey{}, sess)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// writeLinkError answers browsers opening a page with a readable message and
+// scripts with JSON.
+func writeLinkError(w http.ResponseWriter, r *http.Request, err error) {
+	if path := routePath(r); r.Method != http.MethodGet || (path != "/session/qr/page" && path != "/link") {
+		writeError(w, r, err)
+		return
+	}
+	status := errorStatusFor(err)
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		loggerFromContext(r).ErrorContext(r.Context(), "request failed", "error", err)
+		msg = "internal error"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = linkErrorPage.Execute(w, msg)
+}
+
+func handleQRPage(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	// Carry the token and rendering options over to the page's requests.
+	query := url.Values{}
+	for _, key := range []string{"t", "size", "ecc"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+	servePairingPage(w, r, sess, qrPageData{
+		StateURL: apiPath(r, "/session/qr/page/state?"+query.Encode()),
+		RetryURL: apiPath(r, "/session/qr/page/retry?"+url.Values{"t": {query.Get("t")}}.Encode()),
+	})
+}
+
+func servePairingPage(w http.ResponseWriter, r *http.Request, sess *session.Session, data qrPageData) {
+	data.Title = sess.Snapshot().Name
+	if data.Title == "" {
+		data.Title = sess.ID
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := qrPage.Execute(w, data); err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "render QR page failed", "error", err)
+	}
+}
+
+func handleQRPageState(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	opts, err := qrOptions(r)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
+
+	status := sess.QRStatus()
+	resp := qrPageState{State: "waiting"}
+	switch {
+	case status.LoggedIn:
+		resp = qrPageState{State: "linked", JID: status.JID}
+	case status.Code != "":
+		svg, err := whatsapp.QRToSVG(status.Code, opts)
+		if err != nil {
+			writeQRError(w, r, err)
+			return
+		}
+		resp = qrPageState{State: "code", SVG: svg}
+		if !status.ExpiresAt.IsZero() {
+			resp.ExpiresIn = max(0, int(time.Until(status.ExpiresAt).Seconds()))
+		}
+	case status.TimedOut:
+		resp.State = "expired"
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleQRPageRetry starts a new round of QR codes after they ran out.
+func handleQRPageRetry(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	if sess.QRStatus().LoggedIn {
+		writeError(w, r, session.ErrPaired)
+		return
+	}
+	go session.GetManager().Resume(sess)
+	writeJSON(w, http.StatusAccepted, statusResponse{Status: "reconnecting"})
+}
+
+var linkErrorPage = template.Must(template.New("link-error").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Link unavailable</title></head>
+<body style="font-family: system-ui, sans-serif; text-align: center; margin-top: 80px; color: #0f1a12">
+  <h1>This link can't be used</h1>
+  <p style="color: #5a6b5f">{{.}}. Ask for a new link.</p>
+</body>
+</html>`))
+
+var qrPage = template.Must(template.New("qr-page").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>Link WhatsApp: {{.Title}}</title>
+  <style>
+    body { margin: 0; background: #f7fbf7; color: #0f1a12; font-family: ui-sans-serif, system-ui, -apple-system, Segoe UI, Roboto, Helvetica, Arial, sans-serif; }
+    .card { max-width: 420px; margin: 48px auto; background: #fff; border: 1px solid #dbe6df; border-radius: 12px; padding: 24px; text-align: center; }
+    h1 { font-size: 22px; margin: 0 0 6px; }
+    p { color: #5a6b5f; margin: 6px 0; }
+    #qr { min-height: 256px; display: flex; align-items: center; justify-content: center; margin: 16px 0; }
+    #qr svg { max-width: 100%; height: auto; }
+    .linked { font-size: 64px; color: #1f8b4c; }
+    button { background: #1f8b4c; color: #fff; border: 0; border-radius: 8px; padding: 10px 18px; font-size: 15px; cursor: pointer; }
+    form { border-top: 1px solid #dbe6df; margin-top: 20px; padding-top: 16px; }
+    input { border: 1px solid #dbe6df; border-radius: 8px; padding: 9px 12px; font-size: 15px; width: 180px; }
+    #code { font: 600 26px ui-monospace, monospace; letter-spacing: 3px; margin: 12px 0 0; color: #0f1a12; }
+  </style>
+</head>
+<body>
+  <div class="card">
+    <h1>Link WhatsApp</h1>
+    <p>{{.Title}}</p>
+    <div id="qr"><p>Loading…</p></div>
+    <p id="status">Open WhatsApp, go to Settings &gt; Linked devices &gt; Link a device and scan the code.</p>
+    <p id="timer"></p>
+    <button id="retry" hidden>Get a new code</button>
+    {{if .PairCodeURL}}
+    <form id="pair">
+      <p>Can't scan? Link with your phone number instead.</p>
+      <input id="phone" type="tel" inputmode="numeric" placeholder="15551234567" autocomplete="tel" required>
+      <button type="submit">Get code</button>
+      <p id="code" hidden></p>
+      <p id="code-help" hidden>In WhatsApp, go to Settings &gt; Linked devices &gt; Link a device &gt; Link with phone number instead and enter this code.</p>
+    </form>
+    {{end}}
+  </div>
+  <script>
+    const stateURL = {{.StateURL}};
+    const retryURL = {{.RetryURL}};
+    const pairCodeURL = {{.PairCodeURL}};
+    const qr = document.getElementById("qr");
+    const status = document.getElementById("status");
+    const timer = document.getElementById("timer");
+    const retry = document.getElementById("retry");
+    let deadline = 0;
+    let done = false;
+
+    function render(s) {
+      retry.hidden = s.state !== "expired";
+      switch (s.state) {
+      case "code":
+        qr.innerHTML = s.svg;
+        deadline = s.expires_in ? Date.now() + s.expires_in * 1000 : 0;
+        status.textContent = "Open WhatsApp, go to Settings > Linked devices > Link a device and scan the code.";
+        break;
+      case "linked":
+        done = true;
+        deadline = 0;
+        if (pairCodeURL) {
+          document.getElementById("pair").hidden = true;
+        }
+        qr.innerHTML = '<div class="linked">&#10003;</div>';
+        status.textContent = "Linked" + (s.jid ? " as " + s.jid.split("@")[0].split(":")[0] : "") + ". You can close this page.";
+        break;
+      case "expired":
+        deadline = 0;
+        qr.innerHTML = "<p>The code expired.</p>";
+        status.textContent = "No code was scanned in time.";
+        break;
+      default:
+        deadline = 0;
+        qr.innerHTML = "<p>Waiting for a code…</p>";
+      }
+      tick();
+    }
+
+    function tick() {
+      if (!deadline) {
+        timer.textContent = "";
+        return;
+      }
+      const left = Math.max(0, Math.round((deadline - Date.now()) / 1000));
+      timer.textContent = left > 0 ? "Code refreshes in " + left + "s" : "Refreshing…";
+    }
+
+    async function poll() {
+      try {
+        const resp = await fetch(stateURL, { cache: "no-store" });
+        if (resp.status === 401 || resp.status === 404 || resp.status === 410) {
+          const body = await resp.json().catch(() => ({}));
+          done = true;
+          deadline = 0;
+          qr.innerHTML = "";
+          status.textContent = "This link can't be used anymore" + (body.message ? " (" + body.message + ")" : "") + ".";
+          tick();
+          return;
+        }
+        if (resp.ok) {
+          render(await resp.json());
+        }
+      } catch (e) {
+        // Keep polling through network hiccups.
+      }
+      if (!done) {
+        setTimeout(poll, 2000);
+      }
+    }
+
+    retry.addEventListener("click", async () => {
+      retry.hidden = true;
+      qr.innerHTML = "<p>Waiting for a code…</p>";
+      await fetch(retryURL, { method: "POST" }).catch(() => {});
+    });
+
+    if (pairCodeURL) {
+      const code = document.getElementById("code");
+      const help = document.getElementById("code-help");
+      document.getElementById("pair").addEventListener("submit", async (e) => {
+        e.preventDefault();
+        code.hidden = false;
+        help.hidden = true;
+        code.textContent = "…";
+        try {
+          const resp = await fetch(pairCodeURL, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ phone: document.getElementById("phone").value.replace(/[^0-9]/g, "") }),
+          });
+          const body = await resp.json().catch(() => ({}));
+          if (!resp.ok) {
+            code.textContent = body.message || "Could not get a code.";
+            return;
+          }
+          code.textContent = body.code.slice(0, 4) + "-" + body.code.slice(4);
+          help.hidden = false;
+        } catch (err) {
+          code.textContent = "Could not get a code.";
+        }
+      });
+    }
+
+    setInterval(tick, 500);
+    poll();
+  </script>
+</body>
+</html>`))
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"log/slog"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// NewRouter returns the gateway's HTTP handler: the docs, the OpenAPI
+// document, the health checks and every version of the session and admin
+// API, with requests logged to logger. The admin routes answer 404 when
+// adminToken is empty.
+func NewRouter(logger *slog.Logger, adminToken string) *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(RequestLogger(logger))
+	r.Get("/", HandleDocs)
+	r.Get("/openapi.json", HandleOpenAPI)
+	RegisterHealthRoutes(r)
+	RegisterAPIRoutes(r, adminToken)
+	return r
+}
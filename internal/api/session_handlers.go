@@ -3,32 +3,50 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"wa-mvp-api/internal/logging"
 	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/whatsapp"
 )
 
+type createSessionRequest struct {
+	Name     string           `json:"name"`
+	Labels   []string         `json:"labels"`
+	Settings session.Settings `json:"settings"`
+}
+
 type createSessionResponse struct {
+	ID    string `json:"id"`
 	Token string `json:"token"`
 }
 
 type sessionStatusResponse struct {
-	LoggedIn  bool   `json:"logged_in"`
-	Connected bool   `json:"connected"`
-	JID       string `json:"jid"`
-}
-
-type sessionQRResponse struct {
-	QR string `json:"qr"`
+	LoggedIn        bool              `json:"logged_in"`
+	Connected       bool              `json:"connected"`
+	JID             string            `json:"jid"`
+	State           session.ConnState `json:"state"`
+	SuspendedReason string            `json:"suspended_reason,omitempty"`
+	SuspendedUntil  *time.Time        `json:"suspended_until,omitempty"`
+	// History is present once the phone has started syncing history.
+	History *session.HistoryProgress `json:"history,omitempty"`
 }
 
 type sessionListItem struct {
-	ID        string `json:"id"`
-	Connected bool   `json:"connected"`
-	JID       string `json:"jid"`
+	ID        string            `json:"id"`
+	Name      string            `json:"name,omitempty"`
+	Labels    []string          `json:"labels"`
+	State     session.ConnState `json:"state"`
+	Connected bool              `json:"connected"`
+	JID       string            `json:"jid"`
+	CreatedAt time.Time         `json:"created_at"`
 }
 
 type sendMessageRequest struct {
@@ -38,79 +56,191 @@ type sendMessageRequest struct {
 
 type sendMessageResponse struct {
 	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+}
+
+// statusResponse acknowledges a request that has nothing else to return.
+type statusResponse struct {
+	Status string `json:"status"`
 }
 
 type receiveMessagesResponse struct {
 	Messages []session.IncomingMessage `json:"messages"`
 }
 
-func RegisterSessionRoutes(r chi.Router) {
+type proxyRequest struct {
+	ProxyURL string `json:"proxy_url"`
+}
+
+type proxyResponse struct {
+	ProxyURL string `json:"proxy_url"`
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level    string `json:"level"`
+	Override bool   `json:"override"`
+}
+
+// RegisterSessionRoutes registers the public and session routes for the
+// given API version.
+func RegisterSessionRoutes(r chi.Router, version int) {
 	r.Post("/sessions", handleCreateSession)
 	r.Get("/sessions", handleListSessions)
 	r.With(authSession).Get("/session/qr", handleGetSessionQR)
+	r.With(authSession).Post("/session/qr/link", handleCreateQRLink)
+	r.With(authLink(qrPageLink)).Get("/session/qr/page", handleQRPage)
+	r.With(authLink(qrPageLink)).Get("/session/qr/page/state", handleQRPageState)
+	r.With(authLink(qrPageLink)).Post("/session/qr/page/retry", handleQRPageRetry)
+	r.With(authSession).Post("/session/link", handleCreateLink)
+	r.With(authSession).Get("/session/links", handleListLinks)
+	r.With(authOnboardLink).Get("/link", handleLinkPage)
+	r.With(authOnboardLink).Get("/link/state", handleQRPageState)
+	r.With(authOnboardLink).Post("/link/retry", handleQRPageRetry)
+	r.With(authOnboardLink).Post("/link/pair-code", handleLinkPairCode)
 	r.With(authSession).Get("/session/status", handleGetSessionStatus)
 	r.With(authSession).Post("/session/send", handleSendMessage)
-	r.With(authSession).Get("/session/receive", handleReceiveMessages)
+	if version >= 2 {
+		r.With(authSession).Get("/session/receive", handleReceiveMessagesV2)
+	} else {
+		r.With(authSession).Get("/session/receive", handleReceiveMessages)
+	}
+	r.With(authSession).Get("/session/messages", handleListMessages)
+	r.With(authSession).Get("/session/conversations/{chat}", handleGetConversation)
+	r.With(authSession).Get("/session/chats", handleListChats)
+	r.With(authSession).Get("/session/contacts", handleListContacts)
+	r.With(authSession).Get("/session/contacts/{jid}", handleGetContact)
+	r.With(authSession).Get("/session/profile", handleGetProfile)
+	r.With(authSession).Put("/session/profile", handleUpdateProfile)
+	r.With(authSession).Put("/session/profile/picture", handleSetProfilePicture)
+	r.With(authSession).Delete("/session/profile/picture", handleDeleteProfilePicture)
+	r.With(authSession).Get("/session/privacy", handleGetPrivacy)
+	r.With(authSession).Put("/session/privacy", handleUpdatePrivacy)
+	r.With(authSession).Get("/session/blocklist", handleGetBlocklist)
+	r.With(authSession).Put("/session/blocklist/{jid}", handleBlockContact)
+	r.With(authSession).Delete("/session/blocklist/{jid}", handleUnblockContact)
+	r.With(authSession).Get("/session/diagnostics", handleGetSessionDiagnostics)
+	r.With(authSession).Post("/session/reconnect", handleReconnectSession)
+	r.With(authSession).Get("/session/proxy", handleGetProxy)
+	r.With(authSession).Put("/session/proxy", handleSetProxy)
+	r.With(authSession).Get("/session/call-settings", handleGetCallSettings)
+	r.With(authSession).Put("/session/call-settings", handleSetCallSettings)
+	r.With(authSession).Get("/session/log-level", handleGetLogLevel)
 }
 
 func handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	createSession(w, r, false)
+}
+
+// createSession creates a session from the request body. Only admins may
+// give it a proxy, as checking one makes the gateway connect to any address.
+func createSession(w http.ResponseWriter, r *http.Request, admin bool) {
+	var req createSessionRequest
+	// The body is optional: an empty POST creates an unnamed session.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	if strings.TrimSpace(req.Settings.ProxyURL) != "" && !admin {
+		writeErrorCode(w, r, codeForbidden, "proxy_url can only be set through POST /admin/sessions")
+		return
+	}
+
 	manager := session.GetManager()
-	token, err := manager.CreateSession()
+	id, token, err := manager.CreateSession(r.Context(), session.SessionOptions{
+		Name:     req.Name,
+		Labels:   req.Labels,
+		Settings: req.Settings,
+	})
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, createSessionResponse{Token: token})
+	writeJSON(w, http.StatusOK, createSessionResponse{ID: id, Token: token})
 }
 
+// handleListSessions supports ?label= (repeatable or comma-separated; all
+// must match), ?offset= and ?limit=. The total number of matching sessions is
+// returned in the X-Total-Count header.
 func handleListSessions(w http.ResponseWriter, r *http.Request) {
-	manager := session.GetManager()
-	list := manager.ListSessions()
+	query := r.URL.Query()
+
+	var filter session.SessionFilter
+	for _, value := range query["label"] {
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				filter.Labels = append(filter.Labels, label)
+			}
+		}
+	}
+	var ok bool
+	if filter.Offset, ok = parseNonNegative(query.Get("offset")); !ok {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid offset")
+		return
+	}
+	if filter.Limit, ok = parseNonNegative(query.Get("limit")); !ok {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid limit")
+		return
+	}
+
+	list, total := session.GetManager().ListSessions(filter)
 
 	resp := make([]sessionListItem, 0, len(list))
 	for _, s := range list {
+		labels := s.Labels
+		if labels == nil {
+			labels = []string{}
+		}
 		resp = append(resp, sessionListItem{
 			ID:        s.ID,
+			Name:      s.Name,
+			Labels:    labels,
+			State:     s.State,
 			Connected: s.Connected,
 			JID:       s.JID,
+			CreatedAt: s.CreatedAt,
 		})
 	}
 
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	writeJSON(w, http.StatusOK, resp)
 }
 
-func handleGetSessionQR(w http.ResponseWriter, r *http.Request) {
-	sess := getSessionFromContext(r)
-	if sess == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
-		return
+func parseNonNegative(raw string) (int, bool) {
+	if raw == "" {
+		return 0, true
 	}
-
-	qr, err := session.GetManager().GetQRByToken(sess.GetToken())
-	if err != nil {
-		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
-		return
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return 0, false
 	}
-
-	writeJSON(w, http.StatusOK, sessionQRResponse{QR: qr})
+	return v, true
 }
 
 func handleGetSessionStatus(w http.ResponseWriter, r *http.Request) {
 	sess := getSessionFromContext(r)
 	if sess == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
 		return
 	}
 
 	sess.Mutex.RLock()
 	resp := sessionStatusResponse{
-		LoggedIn:  sess.LoggedIn,
-		Connected: sess.Connected,
-		JID:       sess.JID,
+		LoggedIn:        sess.LoggedIn,
+		Connected:       sess.Connected,
+		JID:             sess.JID,
+		State:           sess.State,
+		SuspendedReason: sess.SuspendedReason,
+	}
+	if !sess.SuspendedUntil.IsZero() {
+		until := sess.SuspendedUntil
+		resp.SuspendedUntil = &until
 	}
 	sess.Mutex.RUnlock()
+	resp.History = sess.HistoryProgress()
 
 	writeJSON(w, http.StatusOK, resp)
 }
@@ -118,13 +248,18 @@ func handleGetSessionStatus(w http.ResponseWriter, r *http.Request) {
 func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	sess := getSessionFromContext(r)
 	if sess == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
 		return
 	}
 
+	sendMessage(w, r, sess.ID)
+}
+
+// sendMessage handles a send request for the session with the given ID.
+func sendMessage(w http.ResponseWriter, r *http.Request, sessionID string) {
 	var req sendMessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, http.StatusBadRequest, sendMessageResponse{Status: "error", Error: "invalid json"})
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
 		return
 	}
 
@@ -132,51 +267,174 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	req.Phone = strings.TrimPrefix(req.Phone, "+")
 	req.Message = strings.TrimSpace(req.Message)
 	if req.Phone == "" || req.Message == "" {
-		writeJSON(w, http.StatusBadRequest, sendMessageResponse{Status: "error", Error: "phone and message are required"})
+		writeErrorCode(w, r, codeInvalidRequest, "phone and message are required")
 		return
 	}
 
-	if err := session.GetManager().SendTextByToken(r.Context(), sess.GetToken(), req.Phone, req.Message); err != nil {
-		writeJSON(w, http.StatusBadRequest, sendMessageResponse{Status: "error", Error: err.Error()})
+	msg, err := session.GetManager().SendText(r.Context(), sessionID, req.Phone, req.Message)
+	if err != nil {
+		loggerFromContext(r).WarnContext(r.Context(), "send message failed", "error", err)
+		writeError(w, r, err)
 		return
 	}
 
+	if versionFromContext(r).version >= 2 {
+		writeJSON(w, http.StatusOK, sendMessageV2Response{Message: newMessageInfo(msg)})
+		return
+	}
 	writeJSON(w, http.StatusOK, sendMessageResponse{Status: "sent"})
 }
 
+// handleReceiveMessages pops queued messages. With ?wait= it holds the
+// request until a message arrives or the wait is over.
 func handleReceiveMessages(w http.ResponseWriter, r *http.Request) {
 	sess := getSessionFromContext(r)
 	if sess == nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
 		return
 	}
 
-	limit := 20
+	limit := defaultReceiveLimit
 	if q := r.URL.Query().Get("limit"); q != "" {
 		if v, err := strconv.Atoi(q); err == nil && v > 0 {
 			limit = v
 		}
 	}
+	wait, err := parseWait(r)
+	if err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, err.Error())
+		return
+	}
 
-	msgs := sess.PopMessages(limit)
+	var msgs []session.IncomingMessage
+	err = sess.WaitMessages(r.Context(), wait, func() (bool, error) {
+		var err error
+		if msgs, err = sess.PopMessages(r.Context(), limit); err != nil {
+			return false, fmt.Errorf("read messages: %w", err)
+		}
+		return len(msgs) > 0, nil
+	})
+	if r.Context().Err() != nil {
+		return // the client went away while waiting
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if msgs == nil {
+		msgs = []session.IncomingMessage{}
+	}
 	writeJSON(w, http.StatusOK, receiveMessagesResponse{Messages: msgs})
 }
 
+func handleGetSessionDiagnostics(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sess.Diagnostics())
+}
+
+func handleReconnectSession(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	go session.GetManager().Resume(sess)
+	writeJSON(w, http.StatusAccepted, statusResponse{Status: "reconnecting"})
+}
+
+func handleGetProxy(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, proxyResponse{ProxyURL: whatsapp.RedactProxyURL(sess.GetSettings().ProxyURL)})
+}
+
+func handleSetProxy(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req proxyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+
+	if err := session.GetManager().SetProxy(r.Context(), sess, req.ProxyURL); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, proxyResponse{ProxyURL: whatsapp.RedactProxyURL(sess.GetSettings().ProxyURL)})
+}
+
+func handleGetCallSettings(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sess.CallSettings())
+}
+
+func handleSetCallSettings(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	var req session.CallSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorCode(w, r, codeInvalidRequest, "invalid json")
+		return
+	}
+	if err := session.GetManager().SetCallSettings(r.Context(), sess, req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sess.CallSettings())
+}
+
+func handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	sess := getSessionFromContext(r)
+	if sess == nil {
+		writeErrorCode(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+
+	level, override := logging.SessionLevel(sess.ID)
+	writeJSON(w, http.StatusOK, logLevelResponse{Level: strings.ToLower(level.String()), Override: override})
+}
+
 func authSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := extractBearerToken(r)
 		if token == "" {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing bearer token"})
+			writeErrorCode(w, r, codeUnauthorized, "missing bearer token")
 			return
 		}
 
 		manager := session.GetManager()
 		sess, ok := manager.GetSessionByToken(token)
 		if !ok {
-			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+			writeErrorCode(w, r, codeUnauthorized, "invalid token")
 			return
 		}
 
+		setRequestLogger(r, loggerFromContext(r).With(logging.SessionKey, sess.ID))
 		ctx := context.WithValue(r.Context(), sessionKey{}, sess)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Checking a proxy dials it, so only admins may create sessions with one,
+// and why the dial failed is not passed on.
+func TestCreateSessionProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	body := `{"settings":{"proxy_url":"socks5://` + addr + `"}}`
+
+	create := func(handler http.HandlerFunc) (int, errorResponse) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/sessions", strings.NewReader(body)))
+		var resp errorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := create(handleCreateSession); code != http.StatusForbidden || resp.Code != codeForbidden {
+		t.Errorf("public create = %d %+v, want 403 forbidden", code, resp)
+	}
+	code, resp := create(handleAdminCreateSession)
+	if code != http.StatusBadRequest || resp.Message != "invalid session options: proxy unreachable" {
+		t.Errorf("admin create with an unreachable proxy = %d %+v, want 400 without the dial error", code, resp)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The session and admin API is served under /v1 and /v2. v2 differs only
+// where it says so in apiOperations: messages use the richer messageInfo
+// model and are received by cursor. The routes at the root are the v1 routes
+// from before versioning, kept as aliases for deployed integrations; they
+// answer with Deprecation and Link headers pointing at their /v1 successor.
+
+// apiVersions lists the versions served, oldest first.
+var apiVersions = []int{1, 2}
+
+// legacyDeprecatedAt is when the unprefixed routes were deprecated, sent as
+// the Deprecation header (RFC 9745).
+var legacyDeprecatedAt = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+
+type versionKey struct{}
+
+// apiVersion is the version a request was routed to and the prefix it came in
+// under; legacy requests have version 1 and no prefix.
+type apiVersion struct {
+	version int
+	prefix  string
+}
+
+func versionPrefix(version int) string {
+	return fmt.Sprintf("/v%d", version)
+}
+
+// RegisterAPIRoutes mounts the session and admin routes under every version
+// prefix and as deprecated aliases at the root.
+func RegisterAPIRoutes(r chi.Router, adminToken string) {
+	for _, version := range apiVersions {
+		prefix := versionPrefix(version)
+		r.Route(prefix, func(r chi.Router) {
+			r.Use(withAPIVersion(apiVersion{version: version, prefix: prefix}))
+			RegisterSessionRoutes(r, version)
+			RegisterAdminRoutes(r, adminToken)
+		})
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(deprecatedAlias)
+		RegisterSessionRoutes(r, 1)
+		RegisterAdminRoutes(r, adminToken)
+	})
+}
+
+func withAPIVersion(v apiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), versionKey{}, v)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// deprecatedAlias marks a response as coming from a legacy route.
+func deprecatedAlias(next http.Handler) http.Handler {
+	successor := versionPrefix(1)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successor, r.URL.Path))
+		loggerFromContext(r).DebugContext(r.Context(), "legacy route called", "path", r.URL.Path)
+
+		ctx := context.WithValue(r.Context(), versionKey{}, apiVersion{version: 1})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func versionFromContext(r *http.Request) apiVersion {
+	if v, ok := r.Context().Value(versionKey{}).(apiVersion); ok {
+		return v
+	}
+	return apiVersion{version: 1}
+}
+
+// routePath is the request path without its version prefix.
+func routePath(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, versionFromContext(r).prefix)
+}
+
+// apiPath returns path under the request's version prefix. Legacy requests
+// get /v1 paths, so links handed out from them do not use legacy routes.
+func apiPath(r *http.Request, path string) string {
+	return versionPrefix(versionFromContext(r).version) + path
+}
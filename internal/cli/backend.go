@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+	"time"
+
+	"wa-mvp-api/internal/session"
+)
+
+// backend carries out session commands, either on the local stores (with the
+// server stopped) or on a running instance through its admin API.
+type backend interface {
+	List(ctx context.Context) ([]sessionRow, error)
+	Create(ctx context.Context, opts session.SessionOptions) (id string, token string, err error)
+	Delete(ctx context.Context, id string) error
+	Logout(ctx context.Context, id string) error
+	// QR calls show with every new QR code until the session is paired, and
+	// returns the paired JID.
+	QR(ctx context.Context, id string, show func(code string)) (string, error)
+	Send(ctx context.Context, id string, to string, text string) error
+	RotateToken(ctx context.Context, id string) (string, error)
+	Export(ctx context.Context, id string, passphrase string) ([]byte, error)
+	Import(ctx context.Context, data []byte, passphrase string) (string, error)
+	Close()
+}
+
+// sessionRow matches the JSON of GET /sessions.
+type sessionRow struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Labels    []string  `json:"labels"`
+	State     string    `json:"state"`
+	Connected bool      `json:"connected"`
+	JID       string    `json:"jid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	// pairTimeout bounds how long "session qr" waits for the code to be
+	// scanned; WhatsApp stops issuing codes after a few minutes anyway.
+	pairTimeout = 3 * time.Minute
+	// loginTimeout bounds how long offline commands wait for a paired
+	// session to connect before acting on it.
+	loginTimeout = 30 * time.Second
+)
@@ -0,0 +1,429 @@
+// Package cli implements the wa-mvp-api command line: the server itself and
+// operator commands that work on the local stores or on a running instance.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"wa-mvp-api/internal/config"
+	"wa-mvp-api/internal/crypt"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+const usage = `Usage: wa-mvp-api [--remote URL] [--admin-token TOKEN] <command> [arguments]
+
+Commands:
+  serve                                 run the HTTP API (default)
+  sessions list [--label L]             list sessions
+  sessions create [--name N] [--label L] [--settings JSON]
+                                        create a session and print its token
+  sessions delete <id>                  unlink the device and delete the session
+  sessions logout <id>                  unlink the device, keeping the session
+  session qr <id>                       pair a session by scanning a QR code shown in the terminal
+  send <id> <to> <text>                 send a text message
+  token rotate <id>                     replace a session's bearer token
+  export <id> <file>                    write an encrypted session archive
+  import <file>                         restore a session archive
+  migrate [devices]                     upgrade the stores, or copy per-session devices to the shared store
+  key generate                          print a new master key
+  key rotate                            re-encrypt stored data with NEW_MASTER_KEY
+
+Without --remote (or REMOTE_URL) commands open the local stores; stop the
+server first. With it they use the admin API of a running instance, which
+needs ADMIN_TOKEN. Archive passphrases are read from ARCHIVE_PASSPHRASE.
+migrate and key only work on the local stores.
+`
+
+// Run executes the command in args (os.Args without the program name).
+func Run(cfg config.Config, args []string) error {
+	err := whatsapp.ConfigureDefaultDevice(whatsapp.DeviceInfo{
+		Name:     cfg.DeviceName,
+		Platform: cfg.DevicePlatform,
+		Version:  cfg.DeviceVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid device configuration: %w", err)
+	}
+
+	fs := flag.NewFlagSet("wa-mvp-api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	remote := fs.String("remote", cfg.RemoteURL, "")
+	adminToken := fs.String("admin-token", cfg.AdminToken, "")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	args = fs.Args()
+
+	if len(args) == 0 || args[0] == "serve" {
+		logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+		if err != nil {
+			return fmt.Errorf("invalid logging configuration: %w", err)
+		}
+		slog.SetDefault(logger)
+		return serve(cfg, logger)
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(usage)
+		return nil
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.CLILogLevel)
+	if err != nil {
+		return fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "migrate", "key":
+		if *remote != "" {
+			return fmt.Errorf("%s only works on the local stores", args[0])
+		}
+		if args[0] == "migrate" {
+			return runMigrate(ctx, cfg, logger, args[1:])
+		}
+		return runKey(ctx, cfg, args[1:])
+	case "sessions", "session", "send", "token", "export", "import":
+	default:
+		return usageError(fmt.Sprintf("unknown command %q", args[0]))
+	}
+
+	var b backend
+	if *remote != "" {
+		b, err = openRemote(*remote, *adminToken)
+	} else {
+		b, err = openLocal(cfg, logger)
+	}
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	switch args[0] {
+	case "sessions":
+		return runSessions(ctx, b, args[1:])
+	case "session":
+		if len(args) != 3 || args[1] != "qr" {
+			return usageError("usage: session qr <id>")
+		}
+		return runQR(ctx, b, args[2])
+	case "send":
+		if len(args) != 4 {
+			return usageError("usage: send <id> <to> <text>")
+		}
+		if err := b.Send(ctx, args[1], strings.TrimPrefix(args[2], "+"), args[3]); err != nil {
+			return err
+		}
+		fmt.Println("Sent.")
+		return nil
+	case "token":
+		if len(args) != 3 || args[1] != "rotate" {
+			return usageError("usage: token rotate <id>")
+		}
+		token, err := b.RotateToken(ctx, args[2])
+		if err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	case "export":
+		return runExport(ctx, b, args[1:])
+	default:
+		return runImport(ctx, b, args[1:])
+	}
+}
+
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e) + "\n\n" + usage
+}
+
+// stringList is a flag that may be repeated or given comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func runSessions(ctx context.Context, b backend, args []string) error {
+	if len(args) == 0 {
+		return usageError("usage: sessions list|create|delete|logout")
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("sessions list", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var labels stringList
+		fs.Var(&labels, "label", "")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 0 {
+			return usageError("usage: sessions list [--label L]")
+		}
+		rows, err := b.List(ctx)
+		if err != nil {
+			return err
+		}
+		printSessions(os.Stdout, rows, labels)
+		return nil
+	case "create":
+		fs := flag.NewFlagSet("sessions create", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		name := fs.String("name", "", "")
+		settings := fs.String("settings", "", "")
+		var labels stringList
+		fs.Var(&labels, "label", "")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 0 {
+			return usageError("usage: sessions create [--name N] [--label L] [--settings JSON]")
+		}
+		opts := session.SessionOptions{Name: *name, Labels: labels}
+		if *settings != "" {
+			dec := json.NewDecoder(strings.NewReader(*settings))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&opts.Settings); err != nil {
+				return fmt.Errorf("invalid --settings: %w", err)
+			}
+		}
+		id, token, err := b.Create(ctx, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ID:    %s\nToken: %s\n", id, token)
+		return nil
+	case "delete", "logout":
+		if len(args) != 2 {
+			return usageError(fmt.Sprintf("usage: sessions %s <id>", args[0]))
+		}
+		if args[0] == "delete" {
+			if err := b.Delete(ctx, args[1]); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted session %s.\n", args[1])
+			return nil
+		}
+		if err := b.Logout(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Logged out session %s.\n", args[1])
+		return nil
+	default:
+		return usageError(fmt.Sprintf("unknown sessions command %q", args[0]))
+	}
+}
+
+func printSessions(w io.Writer, rows []sessionRow, labels []string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATE\tJID\tLABELS\tCREATED")
+	for _, row := range rows {
+		if !containsAll(row.Labels, labels) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			row.ID, orDash(row.Name), row.State, orDash(row.JID),
+			orDash(strings.Join(row.Labels, ",")), row.CreatedAt.Local().Format(time.DateTime))
+	}
+	_ = tw.Flush()
+}
+
+func containsAll(have []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func runQR(ctx context.Context, b backend, id string) error {
+	interactive := isTerminal(os.Stdout)
+	jid, err := b.QR(ctx, id, func(code string) {
+		rendered, err := whatsapp.QRToTerminal(code, whatsapp.QROptions{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to render QR code: %v\n", err)
+			return
+		}
+		if interactive {
+			// Replace the previous code instead of scrolling.
+			fmt.Print("\033[H\033[2J")
+		}
+		fmt.Print(rendered)
+		fmt.Println("Scan with WhatsApp: Settings > Linked devices > Link a device.")
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Linked as %s.\n", jid)
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func runExport(ctx context.Context, b backend, args []string) error {
+	if len(args) != 2 {
+		return usageError("usage: export <id> <file>")
+	}
+	archive, err := b.Export(ctx, args[0], os.Getenv("ARCHIVE_PASSPHRASE"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(args[1], archive, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("Exported session %s to %s. It stays suspended on the source until resumed.\n", args[0], args[1])
+	return nil
+}
+
+func runImport(ctx context.Context, b backend, args []string) error {
+	if len(args) != 1 {
+		return usageError("usage: import <file>")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	id, err := b.Import(ctx, data, os.Getenv("ARCHIVE_PASSPHRASE"))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported session %s.\n", id)
+	return nil
+}
+
+// runMigrate upgrades the gateway (and shared device) store schemas, which
+// otherwise happens when the server starts. "migrate devices" copies every
+// per-session device database into the shared device store configured by
+// DEVICE_STORE_DIALECT and DEVICE_STORE_ADDRESS.
+func runMigrate(ctx context.Context, cfg config.Config, logger *slog.Logger, args []string) error {
+	switch {
+	case len(args) == 0:
+		st, err := openGatewayStore(cfg)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		if cfg.DeviceStore == config.DeviceStoreShared {
+			shared, err := openSharedStore(cfg, logger)
+			if err != nil {
+				return err
+			}
+			defer shared.Close()
+		}
+		fmt.Println("Stores are up to date.")
+		return nil
+	case len(args) == 1 && args[0] == "devices":
+	default:
+		return usageError("usage: migrate [devices]")
+	}
+
+	shared, err := openSharedStore(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer shared.Close()
+
+	results, err := session.MigrateToSharedStore(ctx, shared, logger)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, res := range results {
+		fmt.Println(res.String())
+		if res.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions failed to migrate", failed, len(results))
+	}
+	fmt.Println("Start the server with DEVICE_STORE=shared to use the migrated devices.")
+	return nil
+}
+
+// runKey manages the at-rest master key. "key rotate" re-wraps all session
+// data keys with NEW_MASTER_KEY (or NEW_MASTER_KEY_FILE) and encrypts data
+// still stored in plaintext. The current key, if any, comes from MASTER_KEY or
+// MASTER_KEY_FILE. Run it with the server stopped, then restart with the new
+// key as MASTER_KEY.
+func runKey(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return usageError("usage: key generate|rotate")
+	}
+	switch args[0] {
+	case "generate":
+		key, err := crypt.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	case "rotate":
+	default:
+		return usageError(fmt.Sprintf("unknown key command %q", args[0]))
+	}
+
+	next, err := crypt.LoadMasterKey(cfg.NewMasterKey, cfg.NewMasterKeyFile)
+	if err != nil {
+		return fmt.Errorf("new master key: %w", err)
+	}
+	if next == nil {
+		return errors.New("set NEW_MASTER_KEY or NEW_MASTER_KEY_FILE")
+	}
+
+	st, err := openGatewayStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	report, err := st.RotateMasterKey(ctx, next)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rotated %d sessions to master key %s: %d keys re-wrapped, %d created, %d messages, %d chats and %d settings encrypted.\n",
+		report.Sessions, next.ID(), report.RewrappedKeys, report.CreatedKeys, report.EncryptedMessages, report.EncryptedChats, report.EncryptedSettings)
+	fmt.Println("Restart the server with the new key as MASTER_KEY.")
+	return nil
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"wa-mvp-api/internal/config"
+	"wa-mvp-api/internal/crypt"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+// localBackend works directly on the gateway and device stores. The server
+// must not be running at the same time: a session connected from here would
+// replace the server's connection.
+type localBackend struct {
+	manager *session.Manager
+	store   *session.SQLStore
+	log     *slog.Logger
+}
+
+func openLocal(cfg config.Config, logger *slog.Logger) (*localBackend, error) {
+	manager, st, err := openOffline(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &localBackend{manager: manager, store: st, log: logger}, nil
+}
+
+func (b *localBackend) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b.manager.Shutdown(ctx)
+}
+
+func (b *localBackend) List(ctx context.Context) ([]sessionRow, error) {
+	records, err := b.store.ListSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.Before(records[j].CreatedAt)
+		}
+		return records[i].ID < records[j].ID
+	})
+
+	rows := make([]sessionRow, 0, len(records))
+	for _, rec := range records {
+		row := sessionRow{
+			ID:        rec.ID,
+			Name:      rec.Name,
+			Labels:    rec.Labels,
+			State:     "stopped",
+			CreatedAt: rec.CreatedAt,
+		}
+		if !rec.ExportedAt.IsZero() {
+			row.State = "exported"
+		}
+		jid, err := b.manager.PairedJID(ctx, rec.ID)
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %w", rec.ID, err)
+		}
+		if !jid.IsEmpty() {
+			row.JID = jid.String()
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (b *localBackend) Create(ctx context.Context, opts session.SessionOptions) (string, string, error) {
+	return b.manager.CreateSession(ctx, opts)
+}
+
+// Delete unlinks the device from the phone when the session is paired and
+// can connect, then removes the session locally either way.
+func (b *localBackend) Delete(ctx context.Context, id string) error {
+	sess, err := b.manager.OpenSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if sess.Client.Store.ID != nil && sess.GetState() != session.StateSuspended {
+		if err := b.connect(ctx, sess); err != nil {
+			b.log.Warn("could not connect to unlink the device, deleting locally only", "error", err)
+		}
+	}
+	return b.manager.DeleteSession(ctx, id)
+}
+
+func (b *localBackend) Logout(ctx context.Context, id string) error {
+	sess, err := b.manager.OpenSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := b.connect(ctx, sess); err != nil {
+		return err
+	}
+	return b.manager.Logout(ctx, id)
+}
+
+func (b *localBackend) QR(ctx context.Context, id string, show func(code string)) (string, error) {
+	sess, err := b.manager.OpenSession(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if sess.GetState() == session.StateSuspended {
+		return "", fmt.Errorf("session %s is suspended: %s", id, sess.Diagnostics().SuspendedReason)
+	}
+	if sess.Client.Store.ID != nil {
+		return "", fmt.Errorf("session %s is already paired as %s", id, sess.Client.Store.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pairTimeout)
+	defer cancel()
+	go b.manager.Connect(sess)
+
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+	last := ""
+	for {
+		if jid := sess.Client.Store.ID; jid != nil {
+			// Let the post-pairing reconnect finish so that the device is
+			// fully registered before the process exits.
+			if err := b.connect(ctx, sess); err != nil {
+				b.log.Warn("paired but not connected yet", "error", err)
+			}
+			return jid.String(), nil
+		}
+		if code := sess.GetQR(); code != "" && code != last {
+			last = code
+			show(code)
+		} else if code == "" && last != "" && !sess.Client.IsConnected() {
+			return "", errors.New("the QR code was not scanned in time")
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for the QR code to be scanned: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *localBackend) Send(ctx context.Context, id string, to string, text string) error {
+	sess, err := b.manager.OpenSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := b.connect(ctx, sess); err != nil {
+		return err
+	}
+	_, err = b.manager.SendText(ctx, id, to, text)
+	return err
+}
+
+func (b *localBackend) RotateToken(ctx context.Context, id string) (string, error) {
+	return b.manager.RotateToken(ctx, id)
+}
+
+func (b *localBackend) Export(ctx context.Context, id string, passphrase string) ([]byte, error) {
+	return b.manager.ExportSession(ctx, id, passphrase)
+}
+
+func (b *localBackend) Import(ctx context.Context, data []byte, passphrase string) (string, error) {
+	return b.manager.ImportSession(ctx, data, passphrase)
+}
+
+// connect connects a paired session and waits until it is logged in.
+func (b *localBackend) connect(ctx context.Context, sess *session.Session) error {
+	if sess.Client.Store.ID == nil {
+		return session.ErrNotLoggedIn
+	}
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	go b.manager.Connect(sess)
+	return b.manager.WaitLoggedIn(ctx, sess)
+}
+
+// openOffline prepares the manager for commands that work on the local stores
+// while the server is not running. Sessions are not restored or connected.
+func openOffline(cfg config.Config, logger *slog.Logger) (*session.Manager, *session.SQLStore, error) {
+	manager := session.GetManager()
+	manager.SetLogger(logger)
+
+	st, err := openGatewayStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	manager.UseStore(st)
+
+	if cfg.DeviceStore == config.DeviceStoreShared {
+		shared, err := openSharedStore(cfg, logger)
+		if err != nil {
+			_ = st.Close()
+			return nil, nil, err
+		}
+		manager.UseSharedStore(shared)
+	}
+	return manager, st, nil
+}
+
+func openGatewayStore(cfg config.Config) (*session.SQLStore, error) {
+	key, err := crypt.LoadMasterKey(cfg.MasterKey, cfg.MasterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.EnsureStoreRoot(); err != nil {
+		return nil, err
+	}
+	st, err := session.OpenSQLStore(context.Background(), cfg.StateStoreDialect, cfg.StateStoreAddress)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		st.UseMasterKey(key)
+	}
+	return st, session.TightenStorePermissions()
+}
+
+func openSharedStore(cfg config.Config, logger *slog.Logger) (*whatsapp.SharedStore, error) {
+	if err := session.EnsureStoreRoot(); err != nil {
+		return nil, err
+	}
+	shared, err := whatsapp.OpenSharedStore(context.Background(), cfg.DeviceStoreDialect, cfg.DeviceStoreAddress, logging.WALogger(logger, "devicestore"))
+	if err != nil {
+		return nil, err
+	}
+	return shared, session.TightenStorePermissions()
+}
@@ -0,0 +1,205 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"wa-mvp-api/internal/api"
+	"wa-mvp-api/internal/session"
+)
+
+const (
+	remotePollInterval = 2 * time.Second
+	remoteSessionsPath = "/v1/admin/sessions"
+)
+
+// remoteBackend talks to a running instance through its admin API.
+type remoteBackend struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func openRemote(base string, adminToken string) (*remoteBackend, error) {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("remote must be an http(s) URL, got %q", base)
+	}
+	if adminToken == "" {
+		return nil, errors.New("remote commands need the admin token (ADMIN_TOKEN or --admin-token)")
+	}
+	return &remoteBackend{
+		base:   strings.TrimRight(base, "/"),
+		token:  adminToken,
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (b *remoteBackend) Close() {}
+
+// do sends a request to the admin API and returns the response body. Error
+// responses are turned into errors carrying the server's message.
+func (b *remoteBackend) do(ctx context.Context, method string, path string, body []byte, header http.Header) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, session.MaxArchiveSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("%s: %s (%s)", resp.Status, apiErr.Message, apiErr.Code)
+		}
+		return nil, errors.New(resp.Status)
+	}
+	return data, nil
+}
+
+func (b *remoteBackend) doJSON(ctx context.Context, method string, path string, in any, out any) error {
+	var body []byte
+	header := http.Header{}
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = raw
+		header.Set("Content-Type", "application/json")
+	}
+	data, err := b.do(ctx, method, path, body, header)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func sessionPath(id string) string {
+	return remoteSessionsPath + "/" + url.PathEscape(id)
+}
+
+func (b *remoteBackend) List(ctx context.Context) ([]sessionRow, error) {
+	var rows []sessionRow
+	err := b.doJSON(ctx, http.MethodGet, remoteSessionsPath, nil, &rows)
+	return rows, err
+}
+
+func (b *remoteBackend) Create(ctx context.Context, opts session.SessionOptions) (string, string, error) {
+	var resp struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	req := map[string]any{"name": opts.Name, "labels": opts.Labels, "settings": opts.Settings}
+	err := b.doJSON(ctx, http.MethodPost, remoteSessionsPath, req, &resp)
+	return resp.ID, resp.Token, err
+}
+
+func (b *remoteBackend) Delete(ctx context.Context, id string) error {
+	return b.doJSON(ctx, http.MethodDelete, sessionPath(id), nil, nil)
+}
+
+func (b *remoteBackend) Logout(ctx context.Context, id string) error {
+	return b.doJSON(ctx, http.MethodPost, sessionPath(id)+"/logout", nil, nil)
+}
+
+// QR polls the instance for QR codes; the session must have been created
+// there and be waiting to be paired.
+func (b *remoteBackend) QR(ctx context.Context, id string, show func(code string)) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pairTimeout)
+	defer cancel()
+
+	var resp struct {
+		QR       string `json:"qr"`
+		LoggedIn bool   `json:"logged_in"`
+		JID      string `json:"jid"`
+	}
+	if err := b.doJSON(ctx, http.MethodGet, sessionPath(id)+"/qr", nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.LoggedIn {
+		return "", fmt.Errorf("session %s is already paired as %s", id, resp.JID)
+	}
+
+	ticker := time.NewTicker(remotePollInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		if resp.LoggedIn {
+			return resp.JID, nil
+		}
+		if resp.QR != "" && resp.QR != last {
+			last = resp.QR
+			show(resp.QR)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for the QR code to be scanned: %w", ctx.Err())
+		case <-ticker.C:
+		}
+		resp.QR, resp.LoggedIn = "", false
+		if err := b.doJSON(ctx, http.MethodGet, sessionPath(id)+"/qr", nil, &resp); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (b *remoteBackend) Send(ctx context.Context, id string, to string, text string) error {
+	req := map[string]string{"phone": to, "message": text}
+	return b.doJSON(ctx, http.MethodPost, sessionPath(id)+"/send", req, nil)
+}
+
+func (b *remoteBackend) RotateToken(ctx context.Context, id string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := b.doJSON(ctx, http.MethodPost, sessionPath(id)+"/token", nil, &resp)
+	return resp.Token, err
+}
+
+func (b *remoteBackend) Export(ctx context.Context, id string, passphrase string) ([]byte, error) {
+	header := http.Header{}
+	header.Set(api.PassphraseHeader, passphrase)
+	return b.do(ctx, http.MethodPost, sessionPath(id)+"/export", nil, header)
+}
+
+func (b *remoteBackend) Import(ctx context.Context, data []byte, passphrase string) (string, error) {
+	header := http.Header{}
+	header.Set(api.PassphraseHeader, passphrase)
+	header.Set("Content-Type", "application/octet-stream")
+	raw, err := b.do(ctx, http.MethodPost, remoteSessionsPath+"/import", data, header)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	return resp.ID, json.Unmarshal(raw, &resp)
+}
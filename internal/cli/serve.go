@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"wa-mvp-api/internal/api"
+	"wa-mvp-api/internal/config"
+	"wa-mvp-api/internal/session"
+)
+
+// serve restores the stored sessions and runs the HTTP API until SIGINT or
+// SIGTERM, then shuts down gracefully.
+func serve(cfg config.Config, logger *slog.Logger) error {
+	api.ConfigureLinks(cfg.LinkSecret, cfg.PublicURL)
+
+	r := api.NewRouter(logger, cfg.AdminToken)
+
+	manager := session.GetManager()
+	manager.SetLogger(logger)
+
+	st, err := openGatewayStore(cfg)
+	if err != nil {
+		return fmt.Errorf("open gateway store: %w", err)
+	}
+	if !st.FullTextSearch() {
+		logger.Warn("SQLite lacks FTS5, message search will scan every message; build with -tags sqlite_fts5")
+	}
+	manager.UseStore(st)
+
+	if cfg.DeviceStore == config.DeviceStoreShared {
+		shared, err := openSharedStore(cfg, logger)
+		if err != nil {
+			return fmt.Errorf("open shared device store: %w", err)
+		}
+		manager.UseSharedStore(shared)
+	}
+
+	if err := manager.RestoreSessionsOnStartup(); err != nil {
+		logger.Error("restore sessions error", "error", err)
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	// Long-polling receive requests would otherwise hold up the shutdown.
+	srv.RegisterOnShutdown(manager.ReleaseWaiters)
+
+	go func() {
+		logger.Info("server listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	logger.Info("shutting down")
+	// Each step gets its own timeout, so that a slow HTTP shutdown does not
+	// leave the sessions no time to drain.
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer httpCancel()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		logger.Warn("http server shutdown error", "error", err)
+	}
+
+	sessionsCtx, sessionsCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer sessionsCancel()
+	report := manager.Shutdown(sessionsCtx)
+	logger.Info("shutdown complete",
+		"sessions", report.Sessions,
+		"disconnected", report.Disconnected,
+		"closed_stores", report.ClosedStores,
+		"pending_messages", report.PendingMessages,
+		"abandoned_sends", report.AbandonedSends,
+		"undelivered_events", report.UndeliveredEvents,
+		"errors", report.Errors,
+	)
+	return nil
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	DeviceStorePerSession = "per-session"
+	DeviceStoreShared     = "shared"
+)
+
+type Config struct {
+	Addr      string
+	LogFormat string
+	LogLevel  string
+
+	// CLILogLevel applies to CLI commands other than serve, which log to
+	// stderr so that their output stays readable.
+	CLILogLevel string
+
+	// RemoteURL makes CLI commands talk to a running instance over its admin
+	// API instead of opening the local stores.
+	RemoteURL string
+
+	// AdminToken protects the /admin endpoints; they are disabled when empty.
+	AdminToken string
+
+	// LinkSecret signs links that open session pages without a bearer token.
+	// When empty a random secret is used and links die with the process.
+	// PublicURL is the base URL such links are built on; by default it is
+	// taken from the request.
+	LinkSecret string
+	PublicURL  string
+
+	// MasterKey (base64, 32 bytes) or the file in MasterKeyFile enables
+	// at-rest encryption of gateway data. NewMasterKey* are only read by the
+	// key rotate command.
+	MasterKey        string
+	MasterKeyFile    string
+	NewMasterKey     string
+	NewMasterKeyFile string
+
+	// DeviceStore selects where whatsmeow device data lives: one SQLite file
+	// per session directory, or a single shared database for all sessions.
+	DeviceStore        string
+	DeviceStoreDialect string
+	DeviceStoreAddress string
+
+	// StateStore holds gateway-owned state (session registry, token hashes,
+	// messages) in SQLite or PostgreSQL.
+	StateStoreDialect string
+	StateStoreAddress string
+
+	// Device* set how linked devices present themselves on the phone unless
+	// a session overrides them. Empty values keep the whatsmeow defaults.
+	DeviceName     string
+	DevicePlatform string
+	DeviceVersion  string
+}
+
+func Load() (Config, error) {
+	cfg := Config{
+		Addr:               getenv("ADDR", ":9090"),
+		LogFormat:          getenv("LOG_FORMAT", "json"),
+		LogLevel:           getenv("LOG_LEVEL", "info"),
+		CLILogLevel:        getenv("CLI_LOG_LEVEL", "warn"),
+		RemoteURL:          os.Getenv("REMOTE_URL"),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		LinkSecret:         os.Getenv("LINK_SECRET"),
+		PublicURL:          os.Getenv("PUBLIC_URL"),
+		MasterKey:          os.Getenv("MASTER_KEY"),
+		MasterKeyFile:      os.Getenv("MASTER_KEY_FILE"),
+		NewMasterKey:       os.Getenv("NEW_MASTER_KEY"),
+		NewMasterKeyFile:   os.Getenv("NEW_MASTER_KEY_FILE"),
+		DeviceStore:        getenv("DEVICE_STORE", DeviceStorePerSession),
+		DeviceStoreDialect: getenv("DEVICE_STORE_DIALECT", "sqlite3"),
+		DeviceStoreAddress: getenv("DEVICE_STORE_ADDRESS", "file:store/devices.db?_foreign_keys=on"),
+		StateStoreDialect:  getenv("STATE_STORE_DIALECT", "sqlite3"),
+		StateStoreAddress:  getenv("STATE_STORE_ADDRESS", "file:store/gateway.db?_foreign_keys=on&_busy_timeout=5000"),
+		DeviceName:         os.Getenv("DEVICE_NAME"),
+		DevicePlatform:     os.Getenv("DEVICE_PLATFORM"),
+		DeviceVersion:      os.Getenv("DEVICE_VERSION"),
+	}
+
+	switch cfg.DeviceStore {
+	case DeviceStorePerSession, DeviceStoreShared:
+	default:
+		return cfg, fmt.Errorf("DEVICE_STORE must be %q or %q", DeviceStorePerSession, DeviceStoreShared)
+	}
+	if !validDialect(cfg.DeviceStoreDialect) {
+		return cfg, fmt.Errorf("DEVICE_STORE_DIALECT must be \"sqlite3\" or \"postgres\"")
+	}
+	if !validDialect(cfg.StateStoreDialect) {
+		return cfg, fmt.Errorf("STATE_STORE_DIALECT must be \"sqlite3\" or \"postgres\"")
+	}
+
+	return cfg, nil
+}
+
+func validDialect(dialect string) bool {
+	return dialect == "sqlite3" || dialect == "postgres"
+}
+
+func getenv(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,149 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	keySize = 32
+
+	// encryptedPrefix versions the values produced by EncryptString.
+	// Plaintext may start with it too, so callers must record separately
+	// which values they encrypted.
+	encryptedPrefix = "enc1:"
+)
+
+var ErrNoKey = errors.New("value is encrypted but no key is configured")
+
+// MasterKey wraps the per-session data keys (envelope encryption). Only the
+// wrapped data keys are stored; rotating the master key re-wraps them without
+// touching the data itself.
+type MasterKey struct {
+	key []byte
+	id  string
+}
+
+// LoadMasterKey reads a base64-encoded 32-byte key from value or, if value is
+// empty, from the file at path. It returns nil when neither is set.
+func LoadMasterKey(value string, path string) (*MasterKey, error) {
+	if value == "" && path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read master key file: %w", err)
+		}
+		value = string(raw)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes encoded as base64", keySize)
+	}
+	return NewMasterKey(key), nil
+}
+
+func NewMasterKey(key []byte) *MasterKey {
+	sum := sha256.Sum256(key)
+	return &MasterKey{key: key, id: hex.EncodeToString(sum[:4])}
+}
+
+// GenerateKey returns a random base64-encoded key suitable for LoadMasterKey.
+func GenerateKey() (string, error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ID identifies the key without revealing it. It is stored next to wrapped
+// data keys so that a wrong master key is reported clearly.
+func (k *MasterKey) ID() string {
+	return k.id
+}
+
+// Wrap encrypts a data key as "<key id>:<base64>".
+func (k *MasterKey) Wrap(dataKey []byte) (string, error) {
+	sealed, err := seal(k.key, nil, dataKey, []byte(k.id))
+	if err != nil {
+		return "", err
+	}
+	return k.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap reverses Wrap.
+func (k *MasterKey) Unwrap(wrapped string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, errors.New("malformed wrapped data key")
+	}
+	if id != k.id {
+		return nil, fmt.Errorf("data key was wrapped with master key %s, not %s", id, k.id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("malformed wrapped data key")
+	}
+	return open(k.key, sealed, []byte(k.id))
+}
+
+// NewDataKey returns a random per-session data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptString encrypts s with a data key. Empty strings are left as is.
+func EncryptString(dataKey []byte, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	sealed, err := seal(dataKey, nil, []byte(s), nil)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptString reverses EncryptString. s must be a value EncryptString
+// returned; anything else fails with ErrDecrypt.
+func DecryptString(dataKey []byte, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if dataKey == nil {
+		return "", ErrNoKey
+	}
+	if !strings.HasPrefix(s, encryptedPrefix) {
+		return "", ErrDecrypt
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s[len(encryptedPrefix):])
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	plain, err := open(dataKey, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted reports whether s looks like a value from EncryptString. It is
+// only conclusive for values whose plaintext cannot start with the prefix,
+// such as JSON documents.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix)
+}
@@ -0,0 +1,105 @@
+// Package crypt encrypts gateway data with AES-256-GCM.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	passphraseMagic   = "WAGWPP1\x00"
+	passphraseSaltLen = 16
+	minPassphraseLen  = 8
+)
+
+var (
+	ErrWeakPassphrase = errors.New("passphrase must be at least 8 characters")
+	ErrDecrypt        = errors.New("decryption failed: wrong key or corrupted data")
+)
+
+// CheckPassphrase rejects passphrases that are too short to protect an
+// archive.
+func CheckPassphrase(passphrase string) error {
+	if len(passphrase) < minPassphraseLen {
+		return ErrWeakPassphrase
+	}
+	return nil
+}
+
+// SealWithPassphrase encrypts plaintext with a key derived from passphrase
+// using scrypt. The salt and nonce are stored in the output.
+func SealWithPassphrase(passphrase string, plaintext []byte) ([]byte, error) {
+	if err := CheckPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, passphraseSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(passphraseMagic), salt...)
+	return seal(key, out, plaintext, []byte(passphraseMagic))
+}
+
+// OpenWithPassphrase reverses SealWithPassphrase.
+func OpenWithPassphrase(passphrase string, data []byte) ([]byte, error) {
+	header := len(passphraseMagic) + passphraseSaltLen
+	if len(data) < header || string(data[:len(passphraseMagic)]) != passphraseMagic {
+		return nil, errors.New("not an encrypted archive")
+	}
+	key, err := passphraseKey(passphrase, data[len(passphraseMagic):header])
+	if err != nil {
+		return nil, err
+	}
+	return open(key, data[header:], []byte(passphraseMagic))
+}
+
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// seal appends nonce and ciphertext to dst.
+func seal(key []byte, dst []byte, plaintext []byte, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additional), nil
+}
+
+func open(key []byte, data []byte, additional []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+const (
+	SessionKey   = "session_id"
+	RequestIDKey = "request_id"
+	ModuleKey    = "module"
+)
+
+var (
+	baseLevel = new(slog.LevelVar)
+
+	sessionLevelsMu sync.RWMutex
+	sessionLevels   = make(map[string]slog.Level)
+)
+
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	baseLevel.Set(lvl)
+
+	// The inner handler accepts everything; filtering happens in sessionHandler
+	// so that per-session overrides can lower the level below the base one.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var inner slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "json":
+		inner = slog.NewJSONHandler(w, opts)
+	case "text":
+		inner = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(&sessionHandler{next: inner}), nil
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	if strings.TrimSpace(s) == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := lvl.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return lvl, nil
+}
+
+func SetSessionLevel(id string, level slog.Level) {
+	sessionLevelsMu.Lock()
+	sessionLevels[id] = level
+	sessionLevelsMu.Unlock()
+}
+
+func ClearSessionLevel(id string) {
+	sessionLevelsMu.Lock()
+	delete(sessionLevels, id)
+	sessionLevelsMu.Unlock()
+}
+
+// SessionLevel returns the effective level for a session and whether it is an override.
+func SessionLevel(id string) (slog.Level, bool) {
+	sessionLevelsMu.RLock()
+	defer sessionLevelsMu.RUnlock()
+	if lvl, ok := sessionLevels[id]; ok {
+		return lvl, true
+	}
+	return baseLevel.Level(), false
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type sessionHandler struct {
+	next    slog.Handler
+	session string
+}
+
+func (h *sessionHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.session == "" {
+		return level >= baseLevel.Level()
+	}
+	min, _ := SessionLevel(h.session)
+	return level >= min
+}
+
+func (h *sessionHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *sessionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	session := h.session
+	for _, a := range attrs {
+		if a.Key == SessionKey {
+			session = a.Value.String()
+		}
+	}
+	return &sessionHandler{next: h.next.WithAttrs(attrs), session: session}
+}
+
+func (h *sessionHandler) WithGroup(name string) slog.Handler {
+	return &sessionHandler{next: h.next.WithGroup(name), session: h.session}
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+type waLogger struct {
+	base   *slog.Logger
+	log    *slog.Logger
+	module string
+}
+
+// WALogger adapts a slog.Logger to the logger interface used by whatsmeow.
+func WALogger(log *slog.Logger, module string) waLog.Logger {
+	return &waLogger{base: log, log: log.With(ModuleKey, module), module: module}
+}
+
+func (l *waLogger) logf(level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.log.Enabled(ctx, level) {
+		return
+	}
+	l.log.Log(ctx, level, fmt.Sprintf(msg, args...))
+}
+
+func (l *waLogger) Errorf(msg string, args ...interface{}) { l.logf(slog.LevelError, msg, args...) }
+func (l *waLogger) Warnf(msg string, args ...interface{})  { l.logf(slog.LevelWarn, msg, args...) }
+func (l *waLogger) Infof(msg string, args ...interface{})  { l.logf(slog.LevelInfo, msg, args...) }
+func (l *waLogger) Debugf(msg string, args ...interface{}) { l.logf(slog.LevelDebug, msg, args...) }
+
+func (l *waLogger) Sub(module string) waLog.Logger {
+	sub := l.module + "/" + module
+	return &waLogger{base: l.base, log: l.base.With(ModuleKey, sub), module: sub}
+}
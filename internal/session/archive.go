@@ -0,0 +1,447 @@
+package session
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"wa-mvp-api/internal/crypt"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+const (
+	archiveVersion = 1
+	// MaxArchiveSize bounds the size of an encrypted session archive.
+	MaxArchiveSize = 512 << 20
+
+	exportedReason = "exported to another instance"
+
+	archiveManifest = "manifest.json"
+	archiveSession  = "session.json"
+	archiveMessages = "messages.json"
+	archiveChats    = "chats.json"
+	archiveDevice   = "device.db"
+)
+
+var (
+	ErrSessionNotFound = newError(CodeSessionNotFound, "session not found")
+	ErrSessionExists   = newError(CodeSessionExists, "session already exists")
+	ErrInvalidArchive  = newError(CodeInvalidArchive, "invalid session archive")
+)
+
+var archiveSessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type manifest struct {
+	Version    int       `json:"version"`
+	SessionID  string    `json:"session_id"`
+	DeviceJID  string    `json:"device_jid,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+type archivedSession struct {
+	ID        string    `json:"id"`
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Labels    []string  `json:"labels"`
+	Settings  Settings  `json:"settings"`
+}
+
+type archivedMessage struct {
+	Message
+	Pending bool `json:"pending"`
+}
+
+// ExportSession packs a session's device, token hash, metadata, messages and
+// chats into an archive encrypted with passphrase. The session is suspended first
+// and stays marked as exported, also across restarts, so that only the
+// instance importing it connects to WhatsApp. Manager.Resume undoes this; if
+// no archive can be made, the session is left as it was.
+func (m *Manager) ExportSession(ctx context.Context, id string, passphrase string) ([]byte, error) {
+	if err := crypt.CheckPassphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	st := m.gatewayStore()
+	rec, err := st.GetSession(ctx, id)
+	if errors.Is(err, ErrStoreNotFound) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	undo, err := m.markExported(ctx, rec, now)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.packSession(ctx, rec, passphrase, now)
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	return data, nil
+}
+
+// markExported suspends the session, so that its device does not change
+// while it is copied, and marks it as exported. The returned function puts
+// back the session's previous suspension and mark.
+func (m *Manager) markExported(ctx context.Context, rec SessionRecord, at time.Time) (func(), error) {
+	sess, live := m.GetSession(rec.ID)
+	var suspended bool
+	var reason string
+	var until time.Time
+	if live {
+		sess.Mutex.RLock()
+		suspended = sess.State == StateSuspended
+		reason, until = sess.SuspendedReason, sess.SuspendedUntil
+		sess.Mutex.RUnlock()
+		m.suspend(sess, exportedReason, time.Time{})
+	}
+
+	undo := func() {
+		switch {
+		case !live:
+		case suspended:
+			m.suspend(sess, reason, until)
+		default:
+			m.unsuspend(sess)
+			go m.Connect(sess)
+		}
+		if err := m.gatewayStore().SetExported(context.WithoutCancel(ctx), rec.ID, rec.ExportedAt); err != nil {
+			m.sessionLogger(rec.ID).Error("failed to restore export mark", "error", err)
+		}
+	}
+	if err := m.gatewayStore().SetExported(ctx, rec.ID, at); err != nil {
+		undo()
+		return nil, err
+	}
+	return undo, nil
+}
+
+// packSession builds and seals the archive of a session marked as exported
+// at now.
+func (m *Manager) packSession(ctx context.Context, rec SessionRecord, passphrase string, now time.Time) ([]byte, error) {
+	id := rec.ID
+	st := m.gatewayStore()
+	msgs, err := st.AllMessages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	chats, err := st.AllChats(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	device, jid, err := m.exportDevice(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("export device: %w", err)
+	}
+
+	archived := make([]archivedMessage, len(msgs))
+	for i, msg := range msgs {
+		archived[i] = archivedMessage{Message: msg, Pending: msg.Pending}
+	}
+	man := manifest{Version: archiveVersion, SessionID: id, ExportedAt: now}
+	if !jid.IsEmpty() {
+		man.DeviceJID = jid.String()
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name string
+		v    any
+	}{
+		{archiveManifest, man},
+		{archiveSession, archivedSession{
+			ID:        rec.ID,
+			TokenHash: rec.TokenHash,
+			CreatedAt: rec.CreatedAt,
+			Name:      rec.Name,
+			Labels:    rec.Labels,
+			Settings:  rec.Settings,
+		}},
+		{archiveMessages, archived},
+		{archiveChats, chats},
+	}
+	for _, f := range files {
+		raw, err := json.Marshal(f.v)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeTarFile(tw, f.name, raw); err != nil {
+			return nil, err
+		}
+	}
+	if device != nil {
+		if err := writeTarFile(tw, archiveDevice, device); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	sealed, err := crypt.SealWithPassphrase(passphrase, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	m.sessionLogger(id).Info("session exported", "device_jid", man.DeviceJID, "messages", len(msgs))
+	return sealed, nil
+}
+
+// exportDevice returns the session's device as a standalone SQLite database,
+// or nil if the session has not been paired.
+func (m *Manager) exportDevice(ctx context.Context, id string) ([]byte, types.JID, error) {
+	log := logging.WALogger(m.sessionLogger(id), "export")
+
+	var src *sql.DB
+	var jid types.JID
+	var err error
+	if shared := m.sharedStore(); shared != nil {
+		src = shared.DB
+		jid, err = shared.DeviceJID(ctx, id)
+	} else {
+		db, openErr := whatsapp.OpenDeviceDB(SessionDir(id))
+		if errors.Is(openErr, os.ErrNotExist) {
+			return nil, types.EmptyJID, nil
+		} else if openErr != nil {
+			return nil, types.EmptyJID, openErr
+		}
+		defer db.Close()
+		src = db
+		jid, err = whatsapp.FirstDeviceJID(ctx, db)
+	}
+	if err != nil || jid.IsEmpty() {
+		return nil, jid, err
+	}
+
+	dir, err := os.MkdirTemp("", "wa-export-")
+	if err != nil {
+		return nil, jid, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, whatsapp.DBFileName)
+	if _, err := whatsapp.ExportDevice(ctx, src, jid, path, log); err != nil {
+		return nil, jid, err
+	}
+	data, err := os.ReadFile(path)
+	return data, jid, err
+}
+
+// ImportSession restores a session from an archive made by ExportSession and
+// returns its ID. It refuses sessions that already exist here. Once the
+// manager is serving (after RestoreSessionsOnStartup) the imported session is
+// connected immediately; otherwise it is picked up on the next start. A failed
+// import leaves nothing behind, so it can be retried.
+func (m *Manager) ImportSession(ctx context.Context, data []byte, passphrase string) (string, error) {
+	if m.isClosing() {
+		return "", ErrShuttingDown
+	}
+
+	plain, err := crypt.OpenWithPassphrase(passphrase, data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	files, err := readTarGz(plain)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	var man manifest
+	var arch archivedSession
+	var msgs []archivedMessage
+	for name, v := range map[string]any{archiveManifest: &man, archiveSession: &arch, archiveMessages: &msgs} {
+		raw, ok := files[name]
+		if !ok {
+			return "", fmt.Errorf("%w: missing %s", ErrInvalidArchive, name)
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+		}
+	}
+	// Archives from before chats were kept have none.
+	var chats []Chat
+	if raw, ok := files[archiveChats]; ok {
+		if err := json.Unmarshal(raw, &chats); err != nil {
+			return "", fmt.Errorf("%w: %s: %v", ErrInvalidArchive, archiveChats, err)
+		}
+	}
+	if man.Version != archiveVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, man.Version)
+	}
+	id := arch.ID
+	if id != man.SessionID || !archiveSessionID.MatchString(id) || arch.TokenHash == "" {
+		return "", fmt.Errorf("%w: bad session record", ErrInvalidArchive)
+	}
+
+	st := m.gatewayStore()
+	if _, ok := m.GetSession(id); ok {
+		return "", fmt.Errorf("%w: %s is active on this instance", ErrSessionExists, id)
+	}
+	if _, err := st.GetSession(ctx, id); err == nil {
+		return "", fmt.Errorf("%w: %s", ErrSessionExists, id)
+	} else if !errors.Is(err, ErrStoreNotFound) {
+		return "", err
+	}
+
+	device, hasDevice := files[archiveDevice]
+	if hasDevice {
+		if err := m.importDevice(ctx, id, device); err != nil {
+			// A device database that was already there is not ours to remove.
+			if !errors.Is(err, ErrSessionExists) {
+				m.discardImport(ctx, id, false)
+			}
+			return "", fmt.Errorf("import device: %w", err)
+		}
+	}
+
+	rec := SessionRecord{
+		ID:        id,
+		TokenHash: arch.TokenHash,
+		CreatedAt: arch.CreatedAt,
+		Name:      arch.Name,
+		Labels:    arch.Labels,
+		Settings:  arch.Settings,
+	}
+	stored := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		stored[i] = msg.Message
+		stored[i].Pending = msg.Pending
+	}
+	log := m.sessionLogger(id)
+	if err := st.ImportSession(ctx, rec, stored, chats); err != nil {
+		if hasDevice {
+			m.discardImport(ctx, id, false)
+		}
+		return "", err
+	}
+	log.Info("session imported", "device_jid", man.DeviceJID, "messages", len(stored), "chats", len(chats))
+
+	if !m.Ready() {
+		return id, nil
+	}
+	sess, err := m.newSession(rec)
+	if err != nil {
+		m.discardImport(ctx, id, true)
+		return "", err
+	}
+	m.mu.Lock()
+	m.sessions[id] = sess
+	m.tokens[rec.TokenHash] = id
+	m.mu.Unlock()
+	go m.Connect(sess)
+	return id, nil
+}
+
+// discardImport removes what a failed import stored: the device and, if
+// stored is set, the session with its messages and chats. Failures are only
+// logged, as the import's own error is the one to report.
+func (m *Manager) discardImport(ctx context.Context, id string, stored bool) {
+	// The import may have failed because ctx was cancelled.
+	ctx = context.WithoutCancel(ctx)
+	log := m.sessionLogger(id)
+	if stored {
+		if err := m.gatewayStore().DeleteSession(ctx, id); err != nil {
+			log.Error("failed to remove partly imported session", "error", err)
+		}
+	}
+	if shared := m.sharedStore(); shared != nil {
+		if err := shared.DeleteDevice(ctx, id); err != nil {
+			log.Error("failed to remove imported device", "error", err)
+		}
+	}
+	if err := os.RemoveAll(SessionDir(id)); err != nil {
+		log.Error("failed to remove imported device", "error", err)
+	}
+}
+
+func (m *Manager) importDevice(ctx context.Context, id string, device []byte) error {
+	if shared := m.sharedStore(); shared != nil {
+		dir, err := os.MkdirTemp("", "wa-import-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err := os.WriteFile(filepath.Join(dir, whatsapp.DBFileName), device, 0o600); err != nil {
+			return err
+		}
+		if err := EnsureSessionDir(id); err != nil {
+			return err
+		}
+		_, _, err = shared.ImportSessionDir(ctx, dir, id, logging.WALogger(m.sessionLogger(id), "import"))
+		return err
+	}
+
+	if err := EnsureSessionDir(id); err != nil {
+		return err
+	}
+	path := filepath.Join(SessionDir(id), whatsapp.DBFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s already has a device database", ErrSessionExists, id)
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.Write(device); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func readTarGz(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(io.LimitReader(gz, MaxArchiveSize))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		raw, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = raw
+	}
+}
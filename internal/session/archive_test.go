@@ -0,0 +1,176 @@
+package session
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wa-mvp-api/internal/crypt"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+const testPassphrase = "correct horse battery"
+
+// failingChatsStore cannot read chats, which fails exports part-way.
+type failingChatsStore struct {
+	Store
+}
+
+var errChatsRefused = errors.New("chats refused")
+
+func (failingChatsStore) AllChats(context.Context, string) ([]Chat, error) {
+	return nil, errChatsRefused
+}
+
+// testArchive seals an archive of session id, with device as its device
+// database if it is not nil.
+func testArchive(t *testing.T, id string, device []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := map[string]any{
+		archiveManifest: manifest{Version: archiveVersion, SessionID: id, ExportedAt: time.Now()},
+		archiveSession:  archivedSession{ID: id, TokenHash: "hash-" + id, CreatedAt: time.Unix(1700000000, 0)},
+		archiveMessages: []archivedMessage{},
+	}
+	for name, v := range files {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeTarFile(tw, name, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if device != nil {
+		if err := writeTarFile(tw, archiveDevice, device); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := crypt.SealWithPassphrase(testPassphrase, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestExportImportSession(t *testing.T) {
+	ctx := context.Background()
+	src, srcStore := newTestManager(t)
+	putTestSession(t, srcStore, "s1")
+	if err := srcStore.AddMessage(ctx, testMessage("s1", "m1", testChatA, false, "hello", 1700000000)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := src.ExportSession(ctx, "s1", testPassphrase)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if rec, _ := srcStore.GetSession(ctx, "s1"); rec.ExportedAt.IsZero() {
+		t.Error("exported session is not marked as exported")
+	}
+
+	dst, dstStore := newTestManager(t)
+	id, err := dst.ImportSession(ctx, data, testPassphrase)
+	if err != nil || id != "s1" {
+		t.Fatalf("import = %q, %v; want s1", id, err)
+	}
+	msgs, err := dstStore.AllMessages(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIDs(t, "imported messages", msgs, "m1")
+}
+
+// An export that fails part-way leaves the session as it was.
+func TestExportSessionFailureRestoresSession(t *testing.T) {
+	ctx := context.Background()
+	m, st := newTestManager(t)
+	putTestSession(t, st, "idle")
+	sess := addTestSession(t, m, st, "live")
+	until := time.Now().Add(time.Hour)
+	m.suspend(sess, "banned", until)
+	m.UseStore(failingChatsStore{st})
+
+	for _, id := range []string{"idle", "live"} {
+		if _, err := m.ExportSession(ctx, id, testPassphrase); !errors.Is(err, errChatsRefused) {
+			t.Fatalf("export %s error = %v, want %v", id, err, errChatsRefused)
+		}
+		rec, err := st.GetSession(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rec.ExportedAt.IsZero() {
+			t.Errorf("%s is marked as exported after a failed export", id)
+		}
+	}
+
+	sess.Mutex.RLock()
+	defer sess.Mutex.RUnlock()
+	if sess.State != StateSuspended || sess.SuspendedReason != "banned" || !sess.SuspendedUntil.Equal(until) {
+		t.Errorf("session %s (%q until %v), want suspended as before", sess.State, sess.SuspendedReason, sess.SuspendedUntil)
+	}
+}
+
+// An import whose device cannot be stored leaves nothing behind that the next
+// start would pick up, and can be retried.
+func TestImportSessionDiscardsFailedDevice(t *testing.T) {
+	ctx := context.Background()
+	m, st := newTestManager(t)
+	shared, err := whatsapp.OpenSharedStore(ctx, DialectSQLite, "file:"+filepath.Join(t.TempDir(), "devices.db")+"?_foreign_keys=on",
+		logging.WALogger(m.log, "devices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shared.Close() })
+	m.UseSharedStore(shared)
+
+	data := testArchive(t, "s1", []byte("not a database"))
+	for attempt := 1; attempt <= 2; attempt++ {
+		_, err := m.ImportSession(ctx, data, testPassphrase)
+		if err == nil || errors.Is(err, ErrSessionExists) {
+			t.Fatalf("import attempt %d error = %v, want a device error", attempt, err)
+		}
+		if _, err := os.Stat(SessionDir("s1")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("session directory left after attempt %d: %v", attempt, err)
+		}
+		if _, err := st.GetSession(ctx, "s1"); !errors.Is(err, ErrStoreNotFound) {
+			t.Errorf("session stored after attempt %d: %v", attempt, err)
+		}
+	}
+}
+
+// A device database that is already there is kept.
+func TestImportSessionKeepsExistingDevice(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestManager(t)
+	if err := EnsureSessionDir("s1"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(SessionDir("s1"), whatsapp.DBFileName)
+	if err := os.WriteFile(path, []byte("existing"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.ImportSession(ctx, testArchive(t, "s1", []byte("imported")), testPassphrase); !errors.Is(err, ErrSessionExists) {
+		t.Fatalf("import error = %v, want %v", err, ErrSessionExists)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "existing" {
+		t.Errorf("device database = %q, %v; want it kept", data, err)
+	}
+}
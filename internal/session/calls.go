@@ -0,0 +1,174 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// The gateway cannot take calls. Incoming ones are reported to the webhook
+// and, depending on the session's settings, declined and answered with a
+// text message.
+
+// callReplyInterval keeps a caller who tries again and again from getting
+// the call reply each time.
+const callReplyInterval = 10 * time.Minute
+
+// CallEvent is the data of a "call" webhook event. Status is "offer" for an
+// incoming call, with Rejected set if the gateway declined it, and "ended"
+// when the call stopped ringing or was hung up. Video is only known for
+// offers.
+type CallEvent struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// From is the caller; Group is set for group calls.
+	From      string    `json:"from"`
+	Group     string    `json:"group,omitempty"`
+	Video     bool      `json:"video"`
+	Rejected  bool      `json:"rejected,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CallSettings are the settings for incoming calls.
+type CallSettings struct {
+	RejectCalls bool   `json:"reject_calls"`
+	CallReply   string `json:"call_reply"`
+}
+
+// callCreator returns who started a call, which is also who a call is
+// rejected with. From only stands in when the event does not name a creator.
+func callCreator(meta types.BasicCallMeta) types.JID {
+	if meta.CallCreator.IsEmpty() {
+		return meta.From
+	}
+	return meta.CallCreator
+}
+
+func newCallEvent(meta types.BasicCallMeta, status string) CallEvent {
+	evt := CallEvent{
+		ID:        meta.CallID,
+		Status:    status,
+		From:      callCreator(meta).ToNonAD().String(),
+		Timestamp: meta.Timestamp,
+	}
+	if !meta.GroupJID.IsEmpty() {
+		evt.Group = meta.GroupJID.String()
+	}
+	return evt
+}
+
+// handleCall applies the session's call settings to an incoming call and
+// reports it. It runs apart from the event handler, as it waits on WhatsApp.
+func (m *Manager) handleCall(sess *Session, meta types.BasicCallMeta, evt CallEvent) {
+	settings := sess.GetSettings()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if settings.RejectCalls {
+		if err := sess.Client.RejectCall(ctx, callCreator(meta), meta.CallID); err != nil {
+			sess.Log.Warn("failed to reject call", "call_id", meta.CallID, "error", err)
+		} else {
+			evt.Rejected = true
+		}
+	}
+	sess.Log.Info("incoming call", "call_id", evt.ID, "from", evt.From, "video", evt.Video, "group", evt.Group != "", "rejected", evt.Rejected)
+	sess.emit("call", evt)
+
+	if settings.CallReply != "" && sess.takeCallReply(evt.From) {
+		m.sendCallReply(ctx, sess, evt.From, settings.CallReply)
+	}
+}
+
+// takeCallReply reports whether the caller is due a call reply, recording
+// that one is sent if so.
+func (s *Session) takeCallReply(caller string) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := time.Now()
+	if last, ok := s.callReplies[caller]; ok && now.Sub(last) < callReplyInterval {
+		return false
+	}
+	if s.callReplies == nil {
+		s.callReplies = make(map[string]time.Time)
+	}
+	for jid, last := range s.callReplies {
+		if now.Sub(last) >= callReplyInterval {
+			delete(s.callReplies, jid)
+		}
+	}
+	s.callReplies[caller] = now
+	return true
+}
+
+func (m *Manager) sendCallReply(ctx context.Context, sess *Session, caller string, text string) {
+	if !sess.limiter.allow() {
+		sess.Log.Warn("rate limit reached, call reply not sent", "to", caller)
+		return
+	}
+	jid, err := types.ParseJID(caller)
+	if err != nil {
+		return
+	}
+	resp, err := sess.Client.SendMessage(ctx, jid, &waProto.Message{Conversation: proto.String(text)})
+	if err != nil {
+		sess.Log.Warn("failed to send call reply", "to", caller, "error", err)
+		return
+	}
+	sess.RecordOutgoing(Message{
+		ID:        resp.ID,
+		Chat:      caller,
+		Sender:    sess.Client.Store.ID.ToNonAD().String(),
+		Type:      MessageText,
+		Text:      text,
+		Timestamp: resp.Timestamp.Unix(),
+	})
+}
+
+// SetCallSettings persists new call settings for the session; they apply
+// from the next call.
+func (m *Manager) SetCallSettings(ctx context.Context, sess *Session, calls CallSettings) error {
+	sess.settingsMu.Lock()
+	defer sess.settingsMu.Unlock()
+
+	settings := sess.GetSettings()
+	settings.RejectCalls = calls.RejectCalls
+	settings.CallReply = calls.CallReply
+	if err := settings.normalize(); err != nil {
+		return err
+	}
+	if err := m.gatewayStore().SetSettings(ctx, sess.ID, settings); err != nil {
+		return err
+	}
+
+	sess.Mutex.Lock()
+	sess.Settings = settings
+	sess.Mutex.Unlock()
+
+	sess.Log.Info("call settings changed", "reject_calls", settings.RejectCalls, "call_reply", settings.CallReply != "")
+	return nil
+}
+
+// CallSettings returns the session's settings for incoming calls.
+func (s *Session) CallSettings() CallSettings {
+	settings := s.GetSettings()
+	return CallSettings{RejectCalls: settings.RejectCalls, CallReply: settings.CallReply}
+}
+
+func callOfferEvent(e *events.CallOffer) CallEvent {
+	evt := newCallEvent(e.BasicCallMeta, "offer")
+	if e.Data != nil {
+		_, evt.Video = e.Data.GetOptionalChildByTag("video")
+	}
+	return evt
+}
+
+func callOfferNoticeEvent(e *events.CallOfferNotice) CallEvent {
+	evt := newCallEvent(e.BasicCallMeta, "offer")
+	evt.Video = e.Media == "video"
+	return evt
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestTakeCallReply(t *testing.T) {
+	const alice, bob = "111@s.whatsapp.net", "222@s.whatsapp.net"
+	sess := &Session{}
+
+	if !sess.takeCallReply(alice) {
+		t.Fatal("first call got no reply")
+	}
+	if sess.takeCallReply(alice) {
+		t.Error("second call within the interval got a reply")
+	}
+	if !sess.takeCallReply(bob) {
+		t.Error("another caller got no reply")
+	}
+
+	sess.callReplies[alice] = time.Now().Add(-callReplyInterval)
+	sess.callReplies[bob] = time.Now().Add(-callReplyInterval)
+	if !sess.takeCallReply(alice) {
+		t.Error("call after the interval got no reply")
+	}
+	if _, ok := sess.callReplies[bob]; ok || len(sess.callReplies) != 1 {
+		t.Errorf("call replies = %v, want only %s", sess.callReplies, alice)
+	}
+}
+
+func TestCallCreator(t *testing.T) {
+	caller := types.NewJID("111", types.DefaultUserServer)
+	device := types.JID{User: "111", Device: 3, Server: types.DefaultUserServer}
+	group := types.NewJID("120363000000000000", types.GroupServer)
+
+	meta := types.BasicCallMeta{From: device, CallCreator: caller, CallID: "c1"}
+	if got := callCreator(meta); got != caller {
+		t.Errorf("creator = %s, want %s", got, caller)
+	}
+	meta = types.BasicCallMeta{From: device, CallID: "c1"}
+	if got := callCreator(meta); got != device {
+		t.Errorf("creator without CallCreator = %s, want %s", got, device)
+	}
+
+	meta = types.BasicCallMeta{From: device, CallCreator: caller, GroupJID: group, CallID: "c1"}
+	if evt := newCallEvent(meta, "offer"); evt.From != caller.String() || evt.Group != group.String() {
+		t.Errorf("event from %q in %q, want %q in %q", evt.From, evt.Group, caller, group)
+	}
+}
+
+// Settings changed at the same time are all kept.
+func TestSettingsChangesSerialized(t *testing.T) {
+	ctx := context.Background()
+	m, sess, st := newSettingsTestSession(t)
+	p := newTestProxy(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := m.SetProxy(ctx, sess, p.URL); err != nil {
+				t.Errorf("set proxy: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := m.SetCallSettings(ctx, sess, CallSettings{RejectCalls: true, CallReply: "Busy"}); err != nil {
+				t.Errorf("set call settings: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rec, err := st.GetSession(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, settings := range map[string]Settings{"stored": rec.Settings, "session": sess.GetSettings()} {
+		if settings.ProxyURL != p.URL || !settings.RejectCalls || settings.CallReply != "Busy" {
+			t.Errorf("%s settings = %+v, want proxy and call settings", name, settings)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Chats summarise a session's conversations for an inbox. Stored messages
+// keep each chat's latest message and unread count current; names, read
+// marks and the muted, archived and pinned flags follow what the account's
+// other devices report through app state and receipts.
+
+// MutedForever is the MutedUntil of a chat muted until it is unmuted.
+const MutedForever = -1
+
+type Chat struct {
+	JID           string `json:"jid"`
+	Name          string `json:"name"`
+	LastMessageID string `json:"last_message_id"`
+	LastMessageAt int64  `json:"last_message_at"`
+	LastFromMe    bool   `json:"last_from_me"`
+	LastText      string `json:"last_text"`
+	// UnreadCount counts inbound messages since the chat was last read.
+	// MarkedUnread is set when the chat was marked as unread by hand.
+	UnreadCount  int  `json:"unread_count"`
+	MarkedUnread bool `json:"marked_unread"`
+	// MutedUntil is a Unix time, or MutedForever; zero when not muted.
+	MutedUntil int64 `json:"muted_until"`
+	Archived   bool  `json:"archived"`
+	Pinned     bool  `json:"pinned"`
+
+	// nameEncrypted and textEncrypted are set while Name and LastText hold
+	// ciphertext. They are kept apart, as a chat named before encryption was
+	// enabled can get an encrypted last message.
+	nameEncrypted bool
+	textEncrypted bool
+}
+
+func (c Chat) Muted(now time.Time) bool {
+	return c.MutedUntil == MutedForever || c.MutedUntil > now.Unix()
+}
+
+// ChatPosition is a chat's place in a listing, which is ordered by the time
+// of the last message and then by JID.
+type ChatPosition struct {
+	LastMessageAt int64
+	JID           string
+}
+
+func (c Chat) Position() ChatPosition {
+	return ChatPosition{LastMessageAt: c.LastMessageAt, JID: c.JID}
+}
+
+// ChatFilter selects chats for ListChats; nil fields match everything.
+type ChatFilter struct {
+	Archived *bool
+	// Unread selects chats with unread messages or marked as unread.
+	Unread *bool
+	// After continues a listing after the chat at that position.
+	After *ChatPosition
+	Limit int
+}
+
+// ChatUpdate changes a chat; an empty Name and nil fields are left as they
+// are. Read true clears the unread count and mark, false marks the chat as
+// unread. UnreadCount replaces the count unless Read clears it.
+type ChatUpdate struct {
+	Name        string
+	Read        *bool
+	UnreadCount *int
+	MutedUntil  *int64
+	Archived    *bool
+	Pinned      *bool
+}
+
+// ListChats returns the session's chats matching filter, most recent first.
+// Chats without a name of their own are named after the contact.
+func (s *Session) ListChats(ctx context.Context, filter ChatFilter) ([]Chat, error) {
+	chats, err := s.store.ListChats(ctx, s.ID, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chats {
+		if chats[i].Name == "" {
+			chats[i].Name = s.contactName(ctx, chats[i].JID)
+		}
+	}
+	return chats, nil
+}
+
+// updateChat applies a change reported by WhatsApp to a chat.
+func (s *Session) updateChat(jid types.JID, update ChatUpdate) {
+	if err := s.store.UpdateChat(context.Background(), s.ID, jid.ToNonAD().String(), update); err != nil {
+		s.Log.Error("failed to update chat", "chat", jid.String(), "error", err)
+	}
+}
+
+// muteEnd converts a mute action's end, in milliseconds or -1, to
+// MutedUntil.
+func muteEnd(millis int64) int64 {
+	if millis <= 0 {
+		return MutedForever
+	}
+	return millis / 1000
+}
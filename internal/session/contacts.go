@@ -0,0 +1,271 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Contacts are the names whatsmeow keeps in the device store: the address
+// book synced from the phone, push names seen on messages and verified
+// business names. Profiles add the picture, about text and business details,
+// which must be asked of WhatsApp; they are cached for contactProfileTTL or
+// until WhatsApp reports a change.
+
+// contactProfileTTL bounds how long a profile is served from the cache.
+// Picture URLs are signed and stop working after a while.
+const contactProfileTTL = 30 * time.Minute
+
+type Contact struct {
+	JID string `json:"jid"`
+	// Name is the one the account knows the user by: the address book
+	// name if saved, otherwise the business or push name.
+	Name         string `json:"name"`
+	FirstName    string `json:"first_name,omitempty"`
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+}
+
+type ContactProfile struct {
+	Contact
+	// PictureID changes with the picture; both picture fields are empty when
+	// there is none or it is hidden from this account.
+	PictureID  string           `json:"picture_id,omitempty"`
+	PictureURL string           `json:"picture_url,omitempty"`
+	About      string           `json:"about,omitempty"`
+	Business   *BusinessProfile `json:"business,omitempty"`
+	FetchedAt  time.Time        `json:"fetched_at"`
+}
+
+// BusinessProfile is the public profile of a business account.
+type BusinessProfile struct {
+	VerifiedName string            `json:"verified_name,omitempty"`
+	Address      string            `json:"address,omitempty"`
+	Email        string            `json:"email,omitempty"`
+	Categories   []string          `json:"categories,omitempty"`
+	TimeZone     string            `json:"time_zone,omitempty"`
+	Hours        []BusinessHours   `json:"hours,omitempty"`
+	Options      map[string]string `json:"options,omitempty"`
+}
+
+type BusinessHours struct {
+	Day string `json:"day"`
+	// Mode is "specific_hours", "open_24h" or "appointment_only"; Open and
+	// Close are set for specific hours, as WhatsApp reports them.
+	Mode  string `json:"mode"`
+	Open  string `json:"open,omitempty"`
+	Close string `json:"close,omitempty"`
+}
+
+// ContactEvent is the data of a "contact" webhook event. Change is
+// "push_name", "business_name", "picture" or "about". For pictures, which
+// groups have too, New is the new picture ID and is empty when the picture
+// was removed; Old is only known when the profile was cached.
+type ContactEvent struct {
+	JID    string `json:"jid"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// ListContacts returns the contacts with a name, sorted by name.
+func (s *Session) ListContacts(ctx context.Context) ([]Contact, error) {
+	if s.Client == nil || s.Client.Store.Contacts == nil {
+		return []Contact{}, nil
+	}
+	all, err := s.Client.Store.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]Contact, 0, len(all))
+	for jid, info := range all {
+		contact := newContact(jid, info)
+		if contact.Name != "" {
+			contacts = append(contacts, contact)
+		}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		a, b := strings.ToLower(contacts[i].Name), strings.ToLower(contacts[j].Name)
+		if a != b {
+			return a < b
+		}
+		return contacts[i].JID < contacts[j].JID
+	})
+	return contacts, nil
+}
+
+// GetContact returns a user's profile, from the cache unless refresh is set
+// or it is out of date. jid is a user JID as returned by ParseJID.
+func (s *Session) GetContact(ctx context.Context, jid string, refresh bool) (*ContactProfile, error) {
+	user, err := userJID(jid)
+	if err != nil {
+		return nil, err
+	}
+	contact, err := s.storedContact(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if !refresh {
+		if profile := s.cachedProfile(jid); profile != nil {
+			profile.Contact = contact
+			return profile, nil
+		}
+	}
+	if err := s.checkOnline(); err != nil {
+		return nil, err
+	}
+	profile, err := s.fetchProfile(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	s.cacheProfile(jid, *profile)
+	profile.Contact = contact
+	return profile, nil
+}
+
+func (s *Session) storedContact(ctx context.Context, user types.JID) (Contact, error) {
+	if s.Client == nil || s.Client.Store.Contacts == nil {
+		return Contact{JID: user.String()}, nil
+	}
+	info, err := s.Client.Store.Contacts.GetContact(ctx, user)
+	if err != nil {
+		return Contact{}, err
+	}
+	return newContact(user, info), nil
+}
+
+func (s *Session) fetchProfile(ctx context.Context, user types.JID) (*ContactProfile, error) {
+	infos, err := s.Client.GetUserInfo(ctx, []types.JID{user})
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	info, ok := infos[user]
+	if !ok {
+		return nil, ErrContactNotFound.withf(map[string]any{"jid": user.String()}, "not on WhatsApp")
+	}
+	profile := &ContactProfile{About: info.Status, FetchedAt: time.Now()}
+
+	picture, err := s.Client.GetProfilePictureInfo(ctx, user, &whatsmeow.GetProfilePictureParams{})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet), errors.Is(err, whatsmeow.ErrProfilePictureUnauthorized):
+	case err != nil:
+		return nil, upstreamError(err)
+	case picture != nil:
+		profile.PictureID = picture.ID
+		profile.PictureURL = picture.URL
+	}
+
+	if info.VerifiedName != nil {
+		business, err := s.Client.GetBusinessProfile(ctx, user)
+		if err != nil {
+			return nil, upstreamError(err)
+		}
+		profile.Business = newBusinessProfile(info.VerifiedName, business)
+	}
+	return profile, nil
+}
+
+func (s *Session) cachedProfile(jid string) *ContactProfile {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	profile, ok := s.profiles[jid]
+	if !ok || time.Since(profile.FetchedAt) > contactProfileTTL {
+		return nil
+	}
+	return &profile
+}
+
+func (s *Session) cacheProfile(jid string, profile ContactProfile) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if s.profiles == nil {
+		s.profiles = make(map[string]ContactProfile)
+	}
+	s.profiles[jid] = profile
+}
+
+// contactChanged drops the user's cached profile and tells the webhook. An
+// empty from is filled in from the cache for pictures and about texts.
+func (s *Session) contactChanged(jid types.JID, change string, from string, to string) {
+	user := jid.ToNonAD().String()
+	s.Mutex.Lock()
+	if cached, ok := s.profiles[user]; ok && from == "" {
+		switch change {
+		case "picture":
+			from = cached.PictureID
+		case "about":
+			from = cached.About
+		}
+	}
+	delete(s.profiles, user)
+	s.Mutex.Unlock()
+	s.emit("contact", ContactEvent{JID: user, Change: change, Old: from, New: to})
+}
+
+// contactName returns the name the account knows a user by.
+func (s *Session) contactName(ctx context.Context, jid string) string {
+	user, err := userJID(jid)
+	if err != nil {
+		return ""
+	}
+	contact, err := s.storedContact(ctx, user)
+	if err != nil {
+		return ""
+	}
+	return contact.Name
+}
+
+// userJID parses the JID of a user, as opposed to a group or broadcast list.
+func userJID(jid string) (types.JID, error) {
+	user, err := types.ParseJID(jid)
+	if err != nil || user.User == "" || (user.Server != types.DefaultUserServer && user.Server != types.HiddenUserServer) {
+		return types.JID{}, ErrInvalidJID.withf(map[string]any{"jid": jid}, "expected a user JID")
+	}
+	return user.ToNonAD(), nil
+}
+
+func newContact(jid types.JID, info types.ContactInfo) Contact {
+	contact := Contact{
+		JID:          jid.String(),
+		FirstName:    info.FirstName,
+		FullName:     info.FullName,
+		PushName:     info.PushName,
+		BusinessName: info.BusinessName,
+	}
+	for _, name := range []string{info.FullName, info.FirstName, info.BusinessName, info.PushName} {
+		if name != "" {
+			contact.Name = name
+			break
+		}
+	}
+	return contact
+}
+
+func newBusinessProfile(name *types.VerifiedName, business *types.BusinessProfile) *BusinessProfile {
+	profile := &BusinessProfile{
+		VerifiedName: name.Details.GetVerifiedName(),
+		Address:      business.Address,
+		Email:        business.Email,
+		TimeZone:     business.BusinessHoursTimeZone,
+		Options:      business.ProfileOptions,
+	}
+	for _, category := range business.Categories {
+		profile.Categories = append(profile.Categories, category.Name)
+	}
+	for _, hours := range business.BusinessHours {
+		profile.Hours = append(profile.Hours, BusinessHours{
+			Day:   hours.DayOfWeek,
+			Mode:  hours.Mode,
+			Open:  hours.OpenTime,
+			Close: hours.CloseTime,
+		})
+	}
+	return profile
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Error codes identify a kind of failure independently of its message. The
+// API maps them to HTTP statuses and returns them to clients.
+const (
+	CodeSessionNotFound  = "session_not_found"
+	CodeSessionExists    = "session_exists"
+	CodeNotConnected     = "not_connected"
+	CodeNotLoggedIn      = "not_logged_in"
+	CodeAlreadyPaired    = "already_paired"
+	CodeSuspended        = "suspended"
+	CodeInvalidRecipient = "invalid_recipient"
+	CodeInvalidPhone     = "invalid_phone"
+	CodeInvalidJID       = "invalid_jid"
+	CodeContactNotFound  = "contact_not_found"
+	CodeInvalidProfile   = "invalid_profile"
+	CodeInvalidImage     = "invalid_image"
+	CodeInvalidPrivacy   = "invalid_privacy"
+	CodeInvalidOptions   = "invalid_options"
+	CodeInvalidArchive   = "invalid_archive"
+	CodeRateLimited      = "rate_limited"
+	CodeTooManyWaiters   = "too_many_waiters"
+	CodeQRNotAvailable   = "qr_not_available"
+	CodeLinkNotFound     = "link_not_found"
+	CodeLinkExpired      = "link_expired"
+	CodeLinkUsed         = "link_used"
+	CodeShuttingDown     = "shutting_down"
+	CodeUpstream         = "upstream_error"
+)
+
+var (
+	ErrNotConnected     = newError(CodeNotConnected, "session not connected")
+	ErrSuspended        = newError(CodeSuspended, "session suspended")
+	ErrInvalidRecipient = newError(CodeInvalidRecipient, "invalid recipient")
+	ErrInvalidPhone     = newError(CodeInvalidPhone, "invalid phone number")
+	ErrInvalidJID       = newError(CodeInvalidJID, "invalid JID")
+	ErrContactNotFound  = newError(CodeContactNotFound, "contact not found")
+	ErrInvalidProfile   = newError(CodeInvalidProfile, "invalid profile")
+	ErrInvalidImage     = newError(CodeInvalidImage, "invalid image")
+	ErrInvalidPrivacy   = newError(CodeInvalidPrivacy, "invalid privacy settings")
+	ErrQRNotAvailable   = newError(CodeQRNotAvailable, "qr not available")
+	ErrUpstream         = newError(CodeUpstream, "whatsapp request failed")
+)
+
+// Error is a session error with a machine-readable code. The package's Err
+// values are Errors; compare against them with errors.Is and use errors.As to
+// get at the code and details.
+type Error struct {
+	Code    string
+	Message string
+	// Details carry structured context, e.g. the configured rate limit.
+	Details map[string]any
+
+	kind *Error
+	err  error
+}
+
+func newError(code string, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.Message + ": " + e.err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether target is the Err value e was derived from.
+func (e *Error) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+// with returns a copy of e carrying details and wrapping cause, either of
+// which may be nil. The copy still matches e with errors.Is.
+func (e *Error) with(details map[string]any, cause error) *Error {
+	kind := e
+	if e.kind != nil {
+		kind = e.kind
+	}
+	return &Error{Code: e.Code, Message: e.Message, Details: details, kind: kind, err: cause}
+}
+
+// withf is like with but adds a formatted explanation to the message.
+func (e *Error) withf(details map[string]any, format string, args ...any) *Error {
+	err := e.with(details, nil)
+	err.Message += ": " + fmt.Sprintf(format, args...)
+	return err
+}
+
+// upstreamError classifies an error returned by whatsmeow. Connection state
+// errors keep their own codes; anything else is an upstream error.
+func upstreamError(err error) error {
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotConnected):
+		return ErrNotConnected.with(nil, err)
+	case errors.Is(err, whatsmeow.ErrNotLoggedIn):
+		return ErrNotLoggedIn.with(nil, err)
+	}
+	return ErrUpstream.with(nil, err)
+}
+
+// validPhone reports whether phone is 7 to 15 digits, an international number
+// without the leading +.
+func validPhone(phone string) bool {
+	if len(phone) < 7 || len(phone) > 15 {
+		return false
+	}
+	for _, c := range phone {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
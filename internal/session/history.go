@@ -0,0 +1,186 @@
+package session
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// After pairing, the phone sends past conversations in chunks. Their
+// messages are stored like live ones, within the session's history settings,
+// but are not queued for receiving, and their chats take the names, unread
+// counts and flags the phone reports.
+
+// HistoryProgress reports on the history synced since the session started.
+type HistoryProgress struct {
+	// Percent is the phone's estimate of how much of the history it has
+	// sent.
+	Percent       int `json:"percent"`
+	Chunks        int `json:"chunks"`
+	Conversations int `json:"conversations"`
+	// Stored counts new messages kept; Skipped counts those of types that
+	// are not stored or beyond the history settings.
+	Stored       int       `json:"stored"`
+	Skipped      int       `json:"skipped"`
+	LastSyncType string    `json:"last_sync_type"`
+	StartedAt    time.Time `json:"started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// HistoryEvent is the data of a "history" webhook event: the messages kept
+// from one chunk.
+type HistoryEvent struct {
+	SyncType string    `json:"sync_type"`
+	Percent  int       `json:"percent"`
+	Messages []Message `json:"messages"`
+}
+
+type historyState struct {
+	progress HistoryProgress
+	// perChat counts the messages kept per chat against HistoryMessages.
+	perChat map[string]int
+}
+
+// HistoryProgress returns nil until the phone has sent history.
+func (s *Session) HistoryProgress() *HistoryProgress {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	if s.history == nil {
+		return nil
+	}
+	progress := s.history.progress
+	return &progress
+}
+
+func (m *Manager) syncHistory(sess *Session, evt *events.HistorySync) {
+	data := evt.Data
+	settings := sess.GetSettings()
+	var cutoff int64
+	if settings.HistoryDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -settings.HistoryDays).Unix()
+	}
+	// Later chunks hold older history, with chat details that may be out of
+	// date.
+	current := data.GetSyncType() == waHistorySync.HistorySync_INITIAL_BOOTSTRAP ||
+		data.GetSyncType() == waHistorySync.HistorySync_RECENT
+
+	var kept []Message
+	skipped := 0
+	for _, conv := range data.GetConversations() {
+		chat, err := types.ParseJID(conv.GetID())
+		if err != nil || chat.Server == types.BroadcastServer {
+			continue
+		}
+		msgs := make([]Message, 0, len(conv.GetMessages()))
+		for _, item := range conv.GetMessages() {
+			parsed, err := sess.Client.ParseWebMessage(chat, item.GetMessage())
+			if err != nil {
+				skipped++
+				continue
+			}
+			msg := extractMessage(parsed)
+			if msg == nil || msg.Timestamp < cutoff {
+				skipped++
+				continue
+			}
+			msg.SessionID = sess.ID
+			msgs = append(msgs, *msg)
+		}
+		msgs, dropped := sess.limitHistory(chat.String(), msgs, settings.HistoryMessages)
+		skipped += dropped
+		kept = append(kept, msgs...)
+		sess.updateChat(chat, historyChatUpdate(chat, conv, current))
+	}
+
+	stored, err := sess.store.AddHistoryMessages(context.Background(), kept)
+	if err != nil {
+		sess.Log.Error("failed to store history", "messages", len(kept), "error", err)
+	}
+	progress := sess.recordHistory(data, stored, skipped)
+	sess.Log.Info("history synced", "sync_type", progress.LastSyncType, "percent", progress.Percent,
+		"conversations", len(data.GetConversations()), "stored", stored, "skipped", skipped)
+
+	if settings.HistoryWebhooks && len(kept) > 0 {
+		sess.emit("history", HistoryEvent{SyncType: progress.LastSyncType, Percent: progress.Percent, Messages: kept})
+	}
+}
+
+// limitHistory keeps the latest of a chat's synced messages that still fit
+// in limit after those kept from earlier chunks, and returns how many it
+// dropped.
+func (s *Session) limitHistory(chat string, msgs []Message, limit int) ([]Message, int) {
+	if limit <= 0 {
+		return msgs, 0
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Timestamp > msgs[j].Timestamp })
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h := s.historyLocked()
+	n := min(max(limit-h.perChat[chat], 0), len(msgs))
+	h.perChat[chat] += n
+	return msgs[:n], len(msgs) - n
+}
+
+func (s *Session) recordHistory(data *waHistorySync.HistorySync, stored int, skipped int) HistoryProgress {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h := s.historyLocked()
+	p := &h.progress
+	if data.Progress != nil {
+		p.Percent = int(data.GetProgress())
+	}
+	p.Chunks++
+	p.Conversations += len(data.GetConversations())
+	p.Stored += stored
+	p.Skipped += skipped
+	p.LastSyncType = data.GetSyncType().String()
+	p.UpdatedAt = time.Now()
+	return *p
+}
+
+func (s *Session) historyLocked() *historyState {
+	if s.history == nil {
+		s.history = &historyState{
+			progress: HistoryProgress{StartedAt: time.Now()},
+			perChat:  make(map[string]int),
+		}
+	}
+	return s.history
+}
+
+// historyChatUpdate takes a group's name from the synced conversation and,
+// for current history, its unread count and flags. Other chats are named
+// after the contact.
+func historyChatUpdate(chat types.JID, conv *waHistorySync.Conversation, current bool) ChatUpdate {
+	var update ChatUpdate
+	if chat.Server == types.GroupServer {
+		update.Name = conv.GetName()
+	}
+	if !current {
+		return update
+	}
+
+	unread := int(conv.GetUnreadCount())
+	var muted int64
+	switch end := conv.GetMuteEndTime(); {
+	case end > math.MaxInt64:
+		muted = MutedForever
+	case end > 0:
+		muted = int64(end)
+	}
+	update.UnreadCount = &unread
+	update.MutedUntil = &muted
+	update.Archived = proto.Bool(conv.GetArchived())
+	update.Pinned = proto.Bool(conv.GetPinned() > 0)
+	if conv.GetMarkedAsUnread() {
+		update.Read = proto.Bool(false)
+	}
+	return update
+}
@@ -0,0 +1,263 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+var ErrNotLoggedIn = newError(CodeNotLoggedIn, "session not logged in")
+
+// DeleteSession removes a session for good: a paired device is unlinked from
+// the phone when the session is connected, then the device data, stored
+// messages, session record and session directory are deleted.
+func (m *Manager) DeleteSession(ctx context.Context, id string) error {
+	st := m.gatewayStore()
+	sess, active := m.GetSession(id)
+	if !active {
+		if _, err := st.GetSession(ctx, id); errors.Is(err, ErrStoreNotFound) {
+			return ErrSessionNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+
+	log := m.sessionLogger(id)
+	if active {
+		m.mu.Lock()
+		delete(m.sessions, id)
+		for hash, sid := range m.tokens {
+			if sid == id {
+				delete(m.tokens, hash)
+			}
+		}
+		m.mu.Unlock()
+		m.closeSession(ctx, sess)
+	}
+
+	if shared := m.sharedStore(); shared != nil {
+		if err := shared.DeleteDevice(ctx, id); err != nil {
+			return err
+		}
+	}
+	if err := st.DeleteSession(ctx, id); err != nil {
+		return err
+	}
+	logging.ClearSessionLevel(id)
+	if err := os.RemoveAll(SessionDir(id)); err != nil {
+		return err
+	}
+	log.Info("session deleted")
+	return nil
+}
+
+// closeSession unlinks and disconnects a session that has already been
+// removed from the manager and releases its resources.
+func (m *Manager) closeSession(ctx context.Context, sess *Session) {
+	m.stopReconnect(sess)
+	sess.signal.close()
+	sess.Mutex.Lock()
+	if sess.resumeTimer != nil {
+		sess.resumeTimer.Stop()
+		sess.resumeTimer = nil
+	}
+	sess.Mutex.Unlock()
+
+	if sess.Client.IsConnected() && sess.Client.IsLoggedIn() {
+		if err := sess.Client.Logout(ctx); err != nil {
+			sess.Log.Warn("failed to unlink device", "error", err)
+		}
+	}
+	sess.Client.Disconnect()
+	sess.SetState(StateLoggedOut, "deleted")
+
+	if sess.webhook != nil {
+		if left := sess.webhook.close(ctx); len(left) > 0 {
+			sess.Log.Warn("webhook events undelivered", "count", len(left))
+		}
+	}
+	if sess.Container != nil {
+		if err := sess.Container.Close(); err != nil {
+			sess.Log.Warn("failed to close device store", "error", err)
+		}
+	}
+}
+
+// Logout unlinks the session's device from the phone. The session is kept in
+// the logged_out state; delete it or create a new one to pair again.
+func (m *Manager) Logout(ctx context.Context, id string) error {
+	sess, ok := m.GetSession(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if !sess.Client.IsConnected() {
+		return ErrNotConnected
+	}
+	if !sess.Client.IsLoggedIn() {
+		return ErrNotLoggedIn
+	}
+	m.stopReconnect(sess)
+	if err := sess.Client.Logout(ctx); err != nil {
+		return err
+	}
+	m.markLoggedOut(sess, "logged out by operator")
+	return nil
+}
+
+// markLoggedOut records that the session's device is no longer linked.
+func (m *Manager) markLoggedOut(sess *Session, reason string) {
+	m.stopReconnect(sess)
+	sess.SetState(StateLoggedOut, reason)
+	if shared := m.sharedStore(); shared != nil {
+		if err := shared.UnbindDevice(context.Background(), sess.ID); err != nil {
+			sess.Log.Error("failed to unbind device from session", "error", err)
+		}
+	}
+	sess.SetLoggedIn(false)
+	sess.SetJID("")
+	sess.SetQR("")
+}
+
+// RotateToken replaces a session's bearer token. The old token stops working
+// immediately.
+func (m *Manager) RotateToken(ctx context.Context, id string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	tokenHash := HashToken(token)
+	err = m.gatewayStore().SetTokenHash(ctx, id, tokenHash)
+	if errors.Is(err, ErrStoreNotFound) {
+		return "", ErrSessionNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	for hash, sid := range m.tokens {
+		if sid == id {
+			delete(m.tokens, hash)
+		}
+	}
+	if _, ok := m.sessions[id]; ok {
+		m.tokens[tokenHash] = id
+	}
+	m.mu.Unlock()
+
+	m.sessionLogger(id).Info("token rotated")
+	return token, nil
+}
+
+// OpenSession loads a stored session without connecting it, for commands that
+// work on the local stores while the server is stopped. Exported sessions
+// are opened suspended. A session that is already active is returned as is.
+func (m *Manager) OpenSession(ctx context.Context, id string) (*Session, error) {
+	if sess, ok := m.GetSession(id); ok {
+		return sess, nil
+	}
+	rec, err := m.gatewayStore().GetSession(ctx, id)
+	if errors.Is(err, ErrStoreNotFound) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	sess, err := m.newSession(rec)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.sessions[id] = sess
+	m.tokens[rec.TokenHash] = id
+	m.mu.Unlock()
+	if !rec.ExportedAt.IsZero() {
+		m.suspend(sess, exportedReason, time.Time{})
+	}
+	return sess, nil
+}
+
+// WaitLoggedIn blocks until the session is connected and logged in, or fails
+// once it is suspended, logged out or ctx ends.
+func (m *Manager) WaitLoggedIn(ctx context.Context, sess *Session) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if sess.Client.IsConnected() && sess.Client.IsLoggedIn() {
+			return nil
+		}
+		switch sess.GetState() {
+		case StateSuspended, StateLoggedOut:
+			sess.Mutex.RLock()
+			reason := sess.SuspendedReason
+			sess.Mutex.RUnlock()
+			if reason == "" {
+				return ErrNotLoggedIn
+			}
+			return fmt.Errorf("%w: %s", ErrSuspended, reason)
+		}
+		if sess.Client.Store.ID == nil {
+			return ErrNotLoggedIn
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PairedJID returns the device a stored session is paired with, or an empty
+// JID if it has not been paired.
+func (m *Manager) PairedJID(ctx context.Context, id string) (types.JID, error) {
+	if shared := m.sharedStore(); shared != nil {
+		return shared.DeviceJID(ctx, id)
+	}
+	db, err := whatsapp.OpenDeviceDB(SessionDir(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return types.EmptyJID, nil
+	} else if err != nil {
+		return types.EmptyJID, err
+	}
+	defer db.Close()
+	return whatsapp.FirstDeviceJID(ctx, db)
+}
+
+// PairPhone requests an 8-character pairing code for phone, the alternative
+// to scanning a QR code. The session must be connected and not yet paired.
+// The request names the device as configured for the session.
+func (m *Manager) PairPhone(ctx context.Context, id string, phone string) (string, error) {
+	sess, ok := m.GetSession(id)
+	if !ok {
+		return "", ErrSessionNotFound
+	}
+	phone = strings.TrimPrefix(strings.TrimSpace(phone), "+")
+	if !validPhone(phone) {
+		return "", ErrInvalidPhone.withf(map[string]any{"phone": phone}, "must be 7 to 15 digits in international format")
+	}
+	if sess.Client.Store.ID != nil {
+		return "", ErrPaired
+	}
+	if !sess.Client.IsConnected() {
+		return "", ErrNotConnected
+	}
+	client, name := whatsapp.PairClient(sess.GetSettings().clientOptions().Device)
+	code, err := sess.Client.PairPhone(ctx, phone, true, client, name)
+	if errors.Is(err, whatsmeow.ErrIQBadRequest) && name != whatsapp.DefaultPairClientName {
+		// WhatsApp only accepts display names it knows. Once paired, the
+		// phone lists the device under its name all the same.
+		sess.Log.Warn("pairing display name rejected, using the default", "name", name)
+		code, err = sess.Client.PairPhone(ctx, phone, true, whatsmeow.PairClientChrome, whatsapp.DefaultPairClientName)
+	}
+	if err != nil {
+		return "", upstreamError(err)
+	}
+	sess.Log.Info("pairing code requested")
+	return code, nil
+}
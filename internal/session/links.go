@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Onboarding links let someone without the session token pair the session's
+// device from a browser. A link works until it expires or until the session
+// pairs, whichever comes first, and every time it is opened is recorded.
+
+const (
+	DefaultLinkTTL = 24 * time.Hour
+	MaxLinkTTL     = 7 * 24 * time.Hour
+)
+
+var (
+	ErrLinkNotFound = newError(CodeLinkNotFound, "link not found")
+	ErrLinkExpired  = newError(CodeLinkExpired, "link expired")
+	ErrLinkUsed     = newError(CodeLinkUsed, "link already used")
+	ErrPaired       = newError(CodeAlreadyPaired, "session already paired")
+)
+
+type Link struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ConsumedAt is when the session paired through this or another link;
+	// zero while the link is unused.
+	ConsumedAt time.Time  `json:"consumed_at"`
+	Opens      []LinkOpen `json:"opens"`
+}
+
+// LinkOpen records one visit to a link's page.
+type LinkOpen struct {
+	At           time.Time `json:"at"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+}
+
+// Status is "active", "used" or "expired".
+func (l Link) Status(now time.Time) string {
+	switch {
+	case !l.ConsumedAt.IsZero():
+		return "used"
+	case !now.Before(l.ExpiresAt):
+		return "expired"
+	}
+	return "active"
+}
+
+// CreateLink issues an onboarding link for a session that has not paired yet.
+// A zero ttl means DefaultLinkTTL.
+func (m *Manager) CreateLink(ctx context.Context, sessionID string, ttl time.Duration) (Link, error) {
+	sess, ok := m.GetSession(sessionID)
+	if !ok {
+		return Link{}, ErrSessionNotFound
+	}
+	if sess.Client.Store.ID != nil {
+		return Link{}, ErrPaired
+	}
+	if ttl == 0 {
+		ttl = DefaultLinkTTL
+	}
+	if ttl < 0 || ttl > MaxLinkTTL {
+		return Link{}, fmt.Errorf("%w: link ttl must be at most %s", ErrInvalidOptions, MaxLinkTTL)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Link{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	link := Link{
+		ID:        hex.EncodeToString(buf),
+		SessionID: sessionID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Opens:     []LinkOpen{},
+	}
+	if err := m.gatewayStore().CreateLink(ctx, link); err != nil {
+		return Link{}, err
+	}
+	sess.Log.Info("onboarding link created", "link", link.ID, "expires_at", link.ExpiresAt)
+	return link, nil
+}
+
+// ListLinks returns a session's onboarding links, oldest first.
+func (m *Manager) ListLinks(ctx context.Context, sessionID string) ([]Link, error) {
+	links, err := m.gatewayStore().ListLinks(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if links == nil {
+		links = []Link{}
+	}
+	return links, nil
+}
+
+// ResolveLink looks up a link and its session. For a used or expired link
+// the link is still returned together with ErrLinkUsed or ErrLinkExpired.
+func (m *Manager) ResolveLink(ctx context.Context, id string) (Link, *Session, error) {
+	link, err := m.gatewayStore().GetLink(ctx, id)
+	if errors.Is(err, ErrStoreNotFound) {
+		return Link{}, nil, ErrLinkNotFound
+	} else if err != nil {
+		return Link{}, nil, err
+	}
+	sess, ok := m.GetSession(link.SessionID)
+	if !ok {
+		return link, nil, ErrSessionNotFound
+	}
+	switch link.Status(time.Now()) {
+	case "used":
+		return link, sess, ErrLinkUsed
+	case "expired":
+		return link, sess, ErrLinkExpired
+	}
+	return link, sess, nil
+}
+
+// RecordLinkOpen adds an entry to a link's audit trail.
+func (m *Manager) RecordLinkOpen(ctx context.Context, link Link, open LinkOpen) error {
+	if open.At.IsZero() {
+		open.At = time.Now().UTC()
+	}
+	if err := m.gatewayStore().AddLinkOpen(ctx, link.ID, open); err != nil {
+		return err
+	}
+	m.sessionLogger(link.SessionID).Info("onboarding link opened",
+		"link", link.ID, "remote_addr", open.RemoteAddr, "forwarded_for", open.ForwardedFor, "user_agent", open.UserAgent)
+	return nil
+}
+
+// consumeLinks invalidates a session's outstanding links once it has paired.
+func (m *Manager) consumeLinks(sess *Session) {
+	n, err := m.gatewayStore().ConsumeLinks(context.Background(), sess.ID, time.Now().UTC())
+	if err != nil {
+		sess.Log.Error("failed to invalidate onboarding links", "error", err)
+		return
+	}
+	if n > 0 {
+		sess.Log.Info("onboarding links invalidated", "count", n)
+	}
+}
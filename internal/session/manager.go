@@ -5,20 +5,33 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"go.mau.fi/whatsmeow/types/events"
-	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
 	"google.golang.org/protobuf/proto"
+	"wa-mvp-api/internal/logging"
 	"wa-mvp-api/internal/whatsapp"
 )
 
 type Manager struct {
 	sessions map[string]*Session
 	tokens   map[string]string
+	log      *slog.Logger
+	store    Store
+	shared   *whatsapp.SharedStore
+	ready    atomic.Bool
+	closing  bool
+	inflight sync.WaitGroup
 	mu       sync.RWMutex
 }
 
@@ -32,42 +45,157 @@ func GetManager() *Manager {
 		managerSingleton = &Manager{
 			sessions: make(map[string]*Session),
 			tokens:   make(map[string]string),
+			log:      slog.Default(),
 		}
 	})
 	return managerSingleton
 }
 
-func (m *Manager) CreateSession() (string, error) {
+func (m *Manager) SetLogger(log *slog.Logger) {
+	m.mu.Lock()
+	m.log = log
+	m.mu.Unlock()
+}
+
+// UseStore sets where gateway state is persisted. It must be called before
+// RestoreSessionsOnStartup.
+func (m *Manager) UseStore(st Store) {
+	m.mu.Lock()
+	m.store = st
+	m.mu.Unlock()
+}
+
+func (m *Manager) gatewayStore() Store {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.store
+}
+
+// PingStore checks that the gateway store's database can be reached.
+func (m *Manager) PingStore(ctx context.Context) error {
+	st := m.gatewayStore()
+	if st == nil {
+		return errors.New("gateway store not configured")
+	}
+	return st.Ping(ctx)
+}
+
+// UseSharedStore makes new and restored sessions keep their devices in a
+// single shared store instead of one SQLite file per session directory. It
+// must be called before RestoreSessionsOnStartup.
+func (m *Manager) UseSharedStore(shared *whatsapp.SharedStore) {
+	m.mu.Lock()
+	m.shared = shared
+	m.mu.Unlock()
+}
+
+func (m *Manager) sharedStore() *whatsapp.SharedStore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.shared
+}
+
+func (m *Manager) sessionLogger(id string) *slog.Logger {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.log.With(logging.SessionKey, id)
+}
+
+// CreateSession registers a new session and, once the manager is serving,
+// starts connecting it. It returns the session ID and its bearer token; only
+// the token's hash is stored.
+func (m *Manager) CreateSession(ctx context.Context, opts SessionOptions) (string, string, error) {
+	if m.isClosing() {
+		return "", "", ErrShuttingDown
+	}
+	if err := opts.normalize(); err != nil {
+		return "", "", err
+	}
+	if err := checkProxy(ctx, m.log, opts.Settings.ProxyURL); err != nil {
+		return "", "", err
+	}
+
 	id, err := newSessionID()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	token, err := newToken()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-
-	sessionDir := SessionDir(id)
-	client, err := whatsapp.NewClient(context.Background(), sessionDir, m.makeEventHandler(id))
-	if err != nil {
-		return "", err
+	tokenHash := HashToken(token)
+
+	rec := SessionRecord{
+		ID:        id,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+		Name:      opts.Name,
+		Labels:    opts.Labels,
+		Settings:  opts.Settings,
+	}
+	if err := m.gatewayStore().PutSession(ctx, rec); err != nil {
+		return "", "", err
 	}
 
-	sess := &Session{ID: id, Token: token, Client: client}
-	sess.UpdateStatusFromClient()
-
-	if err := WriteToken(id, token); err != nil {
-		return "", err
+	if !m.Ready() {
+		// Offline (CLI) use: the server connects it on its next start.
+		return id, token, nil
+	}
+	sess, err := m.newSession(rec)
+	if err != nil {
+		_ = m.gatewayStore().DeleteSession(context.WithoutCancel(ctx), id)
+		return "", "", err
 	}
 
 	m.mu.Lock()
 	m.sessions[id] = sess
-	m.tokens[token] = id
+	m.tokens[tokenHash] = id
 	m.mu.Unlock()
 
+	sess.Log.Info("session created", "name", rec.Name)
 	go m.Connect(sess)
-	return token, nil
+	return id, token, nil
+}
+
+func (m *Manager) newSession(rec SessionRecord) (*Session, error) {
+	id := rec.ID
+	log := m.sessionLogger(id)
+	waLogger := logging.WALogger(log, "whatsmeow")
+
+	var client *whatsmeow.Client
+	var container *sqlstore.Container
+	var err error
+	if shared := m.sharedStore(); shared != nil {
+		if err = EnsureSessionDir(id); err != nil {
+			return nil, err
+		}
+		client, err = shared.NewClient(context.Background(), id, waLogger, m.makeEventHandler(id), rec.Settings.clientOptions())
+	} else {
+		client, container, err = whatsapp.NewClient(context.Background(), SessionDir(id), waLogger, m.makeEventHandler(id), rec.Settings.clientOptions())
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Reconnection is handled by the manager's supervisor (see reconnect.go).
+	client.EnableAutoReconnect = false
+
+	sess := &Session{
+		ID:        id,
+		Name:      rec.Name,
+		Labels:    rec.Labels,
+		Settings:  rec.Settings,
+		CreatedAt: rec.CreatedAt,
+		Client:    client,
+		Container: container,
+		Log:       log,
+		State:     StateCreated,
+		store:     m.gatewayStore(),
+		webhook:   newWebhook(rec.Settings.WebhookURL, log),
+		limiter:   newRateLimiter(rec.Settings.RateLimitPerMinute),
+	}
+	sess.UpdateStatusFromClient()
+	return sess, nil
 }
 
 func (m *Manager) GetSession(id string) (*Session, bool) {
@@ -79,7 +207,7 @@ func (m *Manager) GetSession(id string) (*Session, bool) {
 
 func (m *Manager) GetSessionByToken(token string) (*Session, bool) {
 	m.mu.RLock()
-	id, ok := m.tokens[token]
+	id, ok := m.tokens[HashToken(token)]
 	m.mu.RUnlock()
 	if !ok {
 		return nil, false
@@ -87,84 +215,150 @@ func (m *Manager) GetSessionByToken(token string) (*Session, bool) {
 	return m.GetSession(id)
 }
 
-func (m *Manager) ListSessions() []SessionInfo {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// SessionFilter selects a page of sessions. Sessions must carry every label
+// in Labels; a zero Limit returns all remaining sessions.
+type SessionFilter struct {
+	Labels []string
+	Offset int
+	Limit  int
+}
 
+// ListSessions returns the sessions matching filter, oldest first, together
+// with the number of matching sessions before pagination.
+func (m *Manager) ListSessions(filter SessionFilter) ([]SessionInfo, int) {
+	m.mu.RLock()
 	list := make([]SessionInfo, 0, len(m.sessions))
 	for _, sess := range m.sessions {
-		list = append(list, sess.Snapshot())
+		info := sess.Snapshot()
+		if hasLabels(info.Labels, filter.Labels) {
+			list = append(list, info)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	total := len(list)
+	if filter.Offset >= total {
+		return []SessionInfo{}, total
+	}
+	list = list[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(list) {
+		list = list[:filter.Limit]
 	}
-	return list
+	return list, total
+}
+
+// Ready reports whether startup restore has finished.
+func (m *Manager) Ready() bool {
+	return m.ready.Load()
 }
 
 func (m *Manager) RestoreSessionsOnStartup() error {
-	ids, err := ListSessionIDs()
+	defer m.ready.Store(true)
+
+	ctx := context.Background()
+	st := m.gatewayStore()
+	if err := importLegacySessions(ctx, st, m.sessionLogger); err != nil {
+		return err
+	}
+
+	records, err := st.ListSessions(ctx)
 	if err != nil {
 		return err
 	}
 
-	for _, id := range ids {
-		sessionDir := SessionDir(id)
-		client, err := whatsapp.NewClient(context.Background(), sessionDir, m.makeEventHandler(id))
+	for _, rec := range records {
+		log := m.sessionLogger(rec.ID)
+		sess, err := m.newSession(rec)
 		if err != nil {
-			log.Printf("failed to restore session %s: %v", id, err)
+			log.Error("failed to restore session", "error", err)
 			continue
 		}
 
-		token, err := ReadToken(id)
-		if err != nil || token == "" {
-			token, err = newToken()
-			if err != nil {
-				log.Printf("failed to create token for %s: %v", id, err)
-				continue
-			}
-			if err := WriteToken(id, token); err != nil {
-				log.Printf("failed to write token for %s: %v", id, err)
-				continue
-			}
-		}
-
-		sess := &Session{ID: id, Token: token, Client: client}
-		sess.UpdateStatusFromClient()
-
 		m.mu.Lock()
-		m.sessions[id] = sess
-		m.tokens[token] = id
+		m.sessions[rec.ID] = sess
+		m.tokens[rec.TokenHash] = rec.ID
 		m.mu.Unlock()
 
+		m.redeliverWebhookEvents(ctx, sess)
+		if !rec.ExportedAt.IsZero() {
+			m.suspend(sess, exportedReason, time.Time{})
+			continue
+		}
+		log.Info("session restored")
 		go m.Connect(sess)
 	}
 
 	return nil
 }
 
+// redeliverWebhookEvents queues the events left undelivered at the last
+// shutdown ahead of any new ones. A session without a webhook keeps them
+// until it has one again.
+func (m *Manager) redeliverWebhookEvents(ctx context.Context, sess *Session) {
+	if sess.webhook == nil {
+		return
+	}
+	events, err := m.gatewayStore().TakeWebhookEvents(ctx, sess.ID)
+	if err != nil {
+		sess.Log.Error("failed to load undelivered webhook events", "error", err)
+		return
+	}
+	for _, evt := range events {
+		sess.webhook.enqueue(evt)
+	}
+	if len(events) > 0 {
+		sess.Log.Info("redelivering webhook events", "count", len(events))
+	}
+}
+
+// Connect connects a session, handing it to the reconnect supervisor if the
+// attempt fails.
 func (m *Manager) Connect(session *Session) {
 	if session == nil || session.Client == nil {
 		return
 	}
 
+	if err := m.connect(session); err != nil {
+		m.scheduleReconnect(session, "connect failed")
+	}
+}
+
+func (m *Manager) connect(session *Session) error {
 	session.Mutex.Lock()
 	if session.Client.IsConnected() {
-		session.Connected = true
+		session.setStateLocked(StateConnected, "")
 		session.Mutex.Unlock()
-		return
+		return nil
 	}
+	session.setStateLocked(StateConnecting, "")
 	session.Mutex.Unlock()
 
 	ctx := context.Background()
 	if session.Client.Store.ID == nil {
+		session.SetQR("")
 		qrChan, err := session.Client.GetQRChannel(ctx)
 		if err != nil {
-			log.Printf("failed to get QR channel for %s: %v", session.ID, err)
+			session.Log.Error("failed to get QR channel", "error", err)
 		} else {
 			go func() {
 				for evt := range qrChan {
 					switch evt.Event {
 					case "code":
-						session.SetQR(evt.Code)
-					case "success", "timeout":
+						session.setQRCode(evt.Code, evt.Timeout)
+						session.Log.Debug("QR code rotated")
+					case "success":
 						session.SetQR("")
+						session.Log.Info("QR channel closed", "event", evt.Event)
+					case "timeout":
+						session.setQRTimedOut()
+						session.Log.Info("QR channel closed", "event", evt.Event)
 					}
 				}
 			}()
@@ -174,21 +368,25 @@ func (m *Manager) Connect(session *Session) {
 	}
 
 	if err := session.Client.Connect(); err != nil {
-		log.Printf("failed to connect session %s: %v", session.ID, err)
+		session.Log.Error("failed to connect session", "error", err)
+		session.RecordConnectError(err)
+		session.SetState(StateDisconnected, "connect failed: "+err.Error())
+		return err
 	}
 
 	session.UpdateStatusFromClient()
+	return nil
 }
 
 func (m *Manager) GetQR(sessionID string) (string, error) {
 	sess, ok := m.GetSession(sessionID)
 	if !ok {
-		return "", errors.New("session not found")
+		return "", ErrSessionNotFound
 	}
 
 	qr := sess.GetQR()
 	if qr == "" {
-		return "", errors.New("qr not available")
+		return "", ErrQRNotAvailable
 	}
 
 	return whatsapp.QRToBase64PNG(qr)
@@ -197,38 +395,67 @@ func (m *Manager) GetQR(sessionID string) (string, error) {
 func (m *Manager) GetQRByToken(token string) (string, error) {
 	sess, ok := m.GetSessionByToken(token)
 	if !ok {
-		return "", errors.New("session not found")
+		return "", ErrSessionNotFound
 	}
+	return m.GetQR(sess.ID)
+}
 
-	qr := sess.GetQR()
-	if qr == "" {
-		return "", errors.New("qr not available")
+func (m *Manager) SendTextByToken(ctx context.Context, token string, phone string, message string) (Message, error) {
+	sess, ok := m.GetSessionByToken(token)
+	if !ok {
+		return Message{}, ErrSessionNotFound
 	}
-
-	return whatsapp.QRToBase64PNG(qr)
+	return m.SendText(ctx, sess.ID, phone, message)
 }
 
-func (m *Manager) SendTextByToken(ctx context.Context, token string, phone string, message string) error {
-	sess, ok := m.GetSessionByToken(token)
+// SendText sends a text message and returns it as stored.
+func (m *Manager) SendText(ctx context.Context, sessionID string, phone string, message string) (Message, error) {
+	if !m.beginSend() {
+		return Message{}, ErrShuttingDown
+	}
+	defer m.inflight.Done()
+
+	sess, ok := m.GetSession(sessionID)
 	if !ok {
-		return errors.New("session not found")
+		return Message{}, ErrSessionNotFound
 	}
 
-	if sess.Client == nil {
-		return errors.New("session client not initialized")
+	if !validPhone(phone) {
+		return Message{}, ErrInvalidRecipient.withf(map[string]any{"phone": phone}, "phone must be 7 to 15 digits in international format")
 	}
-	if !sess.Client.IsConnected() {
-		return errors.New("session not connected")
+	if sess.Client == nil || !sess.Client.IsConnected() {
+		return Message{}, ErrNotConnected.with(map[string]any{"state": sess.GetState()}, nil)
 	}
 	if sess.Client.Store.ID == nil {
-		return errors.New("session not logged in")
+		return Message{}, ErrNotLoggedIn
+	}
+	if !sess.limiter.allow() {
+		return Message{}, ErrRateLimited.with(map[string]any{
+			"limit_per_minute":    sess.GetSettings().RateLimitPerMinute,
+			"retry_after_seconds": int(math.Ceil(sess.limiter.retryAfter().Seconds())),
+		}, nil)
 	}
 
 	jid := types.NewJID(phone, "s.whatsapp.net")
-	_, err := sess.Client.SendMessage(ctx, jid, &waProto.Message{
+	resp, err := sess.Client.SendMessage(ctx, jid, &waProto.Message{
 		Conversation: proto.String(message),
 	})
-	return err
+	if err != nil {
+		return Message{}, upstreamError(err)
+	}
+
+	msg := Message{
+		SessionID: sess.ID,
+		ID:        resp.ID,
+		Chat:      jid.String(),
+		Sender:    sess.Client.Store.ID.ToNonAD().String(),
+		FromMe:    true,
+		Type:      MessageText,
+		Text:      message,
+		Timestamp: resp.Timestamp.Unix(),
+	}
+	sess.RecordOutgoing(msg)
+	return msg, nil
 }
 
 func (m *Manager) makeEventHandler(id string) func(interface{}) {
@@ -240,61 +467,173 @@ func (m *Manager) makeEventHandler(id string) func(interface{}) {
 
 		switch e := evt.(type) {
 		case *events.Message:
-			msg := extractTextMessage(e)
+			msg := extractMessage(e)
 			if msg != nil {
+				msg.SessionID = id
 				sess.AddMessage(*msg)
+				sess.Log.Debug("message received", "message_id", e.Info.ID, "chat", msg.Chat)
+			}
+			if sess.GetSettings().AutoRead && !e.Info.IsFromMe {
+				go m.markRead(sess, e)
+			}
+		case *events.HistorySync:
+			m.syncHistory(sess, e)
+		case *events.Receipt:
+			// Reading a chat on another device of the account clears it here.
+			if e.IsFromMe && (e.Type == types.ReceiptTypeRead || e.Type == types.ReceiptTypeReadSelf) {
+				sess.updateChat(e.Chat, ChatUpdate{Read: proto.Bool(true)})
+			}
+		case *events.MarkChatAsRead:
+			sess.updateChat(e.JID, ChatUpdate{Read: proto.Bool(e.Action.GetRead())})
+		case *events.Mute:
+			var until int64
+			if e.Action.GetMuted() {
+				until = muteEnd(e.Action.GetMuteEndTimestamp())
 			}
+			sess.updateChat(e.JID, ChatUpdate{MutedUntil: &until})
+		case *events.Archive:
+			sess.updateChat(e.JID, ChatUpdate{Archived: proto.Bool(e.Action.GetArchived())})
+		case *events.Pin:
+			sess.updateChat(e.JID, ChatUpdate{Pinned: proto.Bool(e.Action.GetPinned())})
+		case *events.GroupInfo:
+			if e.Name != nil {
+				sess.updateChat(e.JID, ChatUpdate{Name: e.Name.Name})
+			}
+		case *events.JoinedGroup:
+			sess.updateChat(e.JID, ChatUpdate{Name: e.Name})
+		case *events.PushName:
+			sess.contactChanged(e.JID, "push_name", e.OldPushName, e.NewPushName)
+		case *events.BusinessName:
+			sess.contactChanged(e.JID, "business_name", e.OldBusinessName, e.NewBusinessName)
+		case *events.Picture:
+			sess.contactChanged(e.JID, "picture", "", e.PictureID)
+		case *events.UserAbout:
+			sess.contactChanged(e.JID, "about", "", e.Status)
+		case *events.PrivacySettings:
+			sess.emit("privacy", newPrivacySettings(e.NewSettings))
+		case *events.Blocklist:
+			sess.emit("blocklist", newBlocklistEvent(e))
+		case *events.CallOffer:
+			go m.handleCall(sess, e.BasicCallMeta, callOfferEvent(e))
+		case *events.CallOfferNotice:
+			go m.handleCall(sess, e.BasicCallMeta, callOfferNoticeEvent(e))
+		case *events.CallTerminate:
+			evt := newCallEvent(e.BasicCallMeta, "ended")
+			evt.Reason = e.Reason
+			sess.emit("call", evt)
 		case *events.Connected:
-			sess.SetConnected(true)
+			sess.SetState(StateConnected, "")
 			sess.SetLoggedIn(sess.Client.Store.ID != nil)
 			if sess.Client.Store.ID != nil {
 				sess.SetJID(sess.Client.Store.ID.String())
 			}
 		case *events.Disconnected:
-			sess.SetConnected(false)
-			go m.reconnectWithDelay(sess, 5*time.Second)
+			sess.SetState(StateDisconnected, "connection closed")
+			m.scheduleReconnect(sess, "connection closed")
+		case *events.StreamReplaced:
+			m.suspend(sess, "stream replaced by another client using the same session", time.Time{})
+		case *events.TemporaryBan:
+			var until time.Time
+			if e.Expire > 0 {
+				until = time.Now().Add(e.Expire)
+			}
+			m.suspend(sess, e.String(), until)
+		case *events.ClientOutdated:
+			m.suspend(sess, "client outdated, the gateway needs to be updated", time.Time{})
+		case *events.ConnectFailure:
+			err := fmt.Errorf("connect failure %s: %s", e.Reason, e.Message)
+			sess.Log.Warn("connect failure", "reason", e.Reason.String(), "message", e.Message)
+			sess.RecordConnectError(err)
+			m.scheduleReconnect(sess, err.Error())
+		case *events.CATRefreshError:
+			sess.RecordConnectError(e.Error)
+			m.scheduleReconnect(sess, "CAT refresh failed")
+		case *events.KeepAliveTimeout:
+			sess.Log.Warn("keepalive timeout", "error_count", e.ErrorCount, "last_success", e.LastSuccess)
+			sess.RecordKeepAliveTimeout()
+		case *events.KeepAliveRestored:
+			sess.Log.Info("keepalive restored")
+		case *events.PairSuccess:
+			sess.Log.Info("paired", "jid", e.ID.String(), "platform", e.Platform)
+			if shared := m.sharedStore(); shared != nil {
+				if err := shared.BindDevice(context.Background(), id, e.ID); err != nil {
+					sess.Log.Error("failed to bind device to session", "error", err)
+				}
+			}
+			m.consumeLinks(sess)
 		case *events.LoggedOut:
-			sess.SetConnected(false)
-			sess.SetLoggedIn(false)
-			sess.SetJID("")
-			sess.SetQR("")
+			m.markLoggedOut(sess, e.Reason.String())
 		}
 	}
 }
 
-func extractTextMessage(evt *events.Message) *IncomingMessage {
-	if evt == nil || evt.Message == nil {
-		return nil
+func (m *Manager) markRead(sess *Session, evt *events.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := sess.Client.MarkRead(ctx, []types.MessageID{evt.Info.ID}, time.Now(), evt.Info.Chat, evt.Info.Sender)
+	if err != nil {
+		sess.Log.Warn("failed to mark message as read", "message_id", evt.Info.ID, "error", err)
+		return
 	}
+	sess.updateChat(evt.Info.Chat, ChatUpdate{Read: proto.Bool(true)})
+}
 
-	text := ""
-	if conv := evt.Message.GetConversation(); conv != "" {
-		text = conv
-	} else if ext := evt.Message.GetExtendedTextMessage(); ext != nil {
-		text = ext.GetText()
+// extractMessage returns a received message in the form it is stored in, or
+// nil for kinds of message that are not stored.
+func extractMessage(evt *events.Message) *Message {
+	if evt == nil || evt.Message == nil {
+		return nil
 	}
-
-	if text == "" {
+	typ, text := messageContent(evt.Message)
+	if typ == "" {
 		return nil
 	}
 
-	return &IncomingMessage{
-		From:      evt.Info.Sender.User,
-		Name:      evt.Info.PushName,
-		Message:   text,
-		Timestamp: evt.Info.Timestamp.Unix(),
+	return &Message{
+		ID:         evt.Info.ID,
+		Chat:       evt.Info.Chat.String(),
+		Sender:     evt.Info.Sender.ToNonAD().String(),
+		SenderName: evt.Info.PushName,
+		FromMe:     evt.Info.IsFromMe,
+		Type:       typ,
+		Text:       text,
+		Timestamp:  evt.Info.Timestamp.Unix(),
 	}
 }
 
-func (m *Manager) reconnectWithDelay(sess *Session, delay time.Duration) {
-	if sess == nil || sess.Client == nil {
-		return
-	}
-	if sess.Client.IsConnected() {
-		return
+// messageContent returns the type of msg, one of MessageTypes, and its text.
+// The type is empty for messages that are not stored, such as protocol
+// messages, empty texts and removed reactions.
+func messageContent(msg *waProto.Message) (string, string) {
+	switch {
+	case msg.GetConversation() != "":
+		return MessageText, msg.GetConversation()
+	case msg.GetExtendedTextMessage().GetText() != "":
+		return MessageText, msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return MessageImage, msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return MessageVideo, msg.GetVideoMessage().GetCaption()
+	case msg.GetPtvMessage() != nil:
+		return MessageVideo, msg.GetPtvMessage().GetCaption()
+	case msg.GetAudioMessage() != nil:
+		return MessageAudio, ""
+	case msg.GetDocumentMessage() != nil:
+		return MessageDocument, msg.GetDocumentMessage().GetCaption()
+	case msg.GetStickerMessage() != nil:
+		return MessageSticker, ""
+	case msg.GetLocationMessage() != nil:
+		loc := msg.GetLocationMessage()
+		if loc.GetName() != "" {
+			return MessageLocation, loc.GetName()
+		}
+		return MessageLocation, loc.GetAddress()
+	case msg.GetLiveLocationMessage() != nil:
+		return MessageLocation, msg.GetLiveLocationMessage().GetCaption()
+	case msg.GetReactionMessage().GetText() != "":
+		return MessageReaction, msg.GetReactionMessage().GetText()
 	}
-	time.Sleep(delay)
-	m.Connect(sess)
+	return "", ""
 }
 
 func newSessionID() (string, error) {
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestManager returns a manager on a new SQLite store. The test runs in a
+// directory of its own, where session directories are created.
+func newTestManager(t *testing.T) (*Manager, *SQLStore) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	st := openTestStore(t, DialectSQLite, "file:"+filepath.Join(t.TempDir(), "gateway.db")+"?_foreign_keys=on")
+	m := &Manager{
+		sessions: make(map[string]*Session),
+		tokens:   make(map[string]string),
+		log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		store:    st,
+	}
+	return m, st
+}
+
+// addTestSession stores a session and registers it with m, suspended so
+// that nothing tries to connect it.
+func addTestSession(t *testing.T, m *Manager, st *SQLStore, id string) *Session {
+	t.Helper()
+	rec := putTestSession(t, st, id)
+	sess := &Session{
+		ID:       id,
+		Settings: rec.Settings,
+		Client:   whatsmeow.NewClient(&store.Device{}, nil),
+		Log:      m.sessionLogger(id),
+		State:    StateSuspended,
+		store:    st,
+	}
+	m.mu.Lock()
+	m.sessions[id] = sess
+	m.tokens[rec.TokenHash] = id
+	m.mu.Unlock()
+	return sess
+}
+
+func TestExtractMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *waProto.Message
+		typ     string
+		text    string
+		dropped bool
+	}{
+		{name: "conversation", msg: &waProto.Message{Conversation: proto.String("hello")}, typ: MessageText, text: "hello"},
+		{name: "extended text", msg: &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("https://example.com")}}, typ: MessageText, text: "https://example.com"},
+		{name: "image", msg: &waProto.Message{ImageMessage: &waProto.ImageMessage{Caption: proto.String("a photo")}}, typ: MessageImage, text: "a photo"},
+		{name: "video", msg: &waProto.Message{VideoMessage: &waProto.VideoMessage{}}, typ: MessageVideo},
+		{name: "video note", msg: &waProto.Message{PtvMessage: &waProto.VideoMessage{}}, typ: MessageVideo},
+		{name: "audio", msg: &waProto.Message{AudioMessage: &waProto.AudioMessage{PTT: proto.Bool(true)}}, typ: MessageAudio},
+		{name: "document", msg: &waProto.Message{DocumentMessage: &waProto.DocumentMessage{Caption: proto.String("the report")}}, typ: MessageDocument, text: "the report"},
+		{name: "sticker", msg: &waProto.Message{StickerMessage: &waProto.StickerMessage{}}, typ: MessageSticker},
+		{name: "location", msg: &waProto.Message{LocationMessage: &waProto.LocationMessage{Name: proto.String("Office"), Address: proto.String("1 Main St")}}, typ: MessageLocation, text: "Office"},
+		{name: "location address", msg: &waProto.Message{LocationMessage: &waProto.LocationMessage{Address: proto.String("1 Main St")}}, typ: MessageLocation, text: "1 Main St"},
+		{name: "live location", msg: &waProto.Message{LiveLocationMessage: &waProto.LiveLocationMessage{Caption: proto.String("on my way")}}, typ: MessageLocation, text: "on my way"},
+		{name: "reaction", msg: &waProto.Message{ReactionMessage: &waProto.ReactionMessage{Text: proto.String("👍")}}, typ: MessageReaction, text: "👍"},
+		{name: "removed reaction", msg: &waProto.Message{ReactionMessage: &waProto.ReactionMessage{Text: proto.String("")}}, dropped: true},
+		{name: "empty text", msg: &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{}}, dropped: true},
+		{name: "protocol", msg: &waProto.Message{ProtocolMessage: &waProto.ProtocolMessage{}}, dropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt := &events.Message{Message: tt.msg}
+			evt.Info.ID = "M1"
+			evt.Info.Chat = types.NewJID("111", types.DefaultUserServer)
+			evt.Info.Sender = types.JID{User: "111", Device: 2, Server: types.DefaultUserServer}
+			evt.Info.Timestamp = time.Unix(1700000000, 0)
+
+			msg := extractMessage(evt)
+			if tt.dropped {
+				if msg != nil {
+					t.Fatalf("message stored as %q %q, want dropped", msg.Type, msg.Text)
+				}
+				return
+			}
+			if msg == nil {
+				t.Fatal("message dropped")
+			}
+			if msg.Type != tt.typ || msg.Text != tt.text {
+				t.Errorf("message = %q %q, want %q %q", msg.Type, msg.Text, tt.typ, tt.text)
+			}
+			if msg.Sender != "111@s.whatsapp.net" || msg.Timestamp != 1700000000 {
+				t.Errorf("sender, timestamp = %s, %d", msg.Sender, msg.Timestamp)
+			}
+		})
+	}
+}
+
+func TestPopMessagesTextOnly(t *testing.T) {
+	m, st := newTestManager(t)
+	sess := addTestSession(t, m, st, "s1")
+	const chat = "111@s.whatsapp.net"
+	image := testMessage("s1", "m2", chat, false, "a photo", 2)
+	image.Type = MessageImage
+	addMessages(t, st, testMessage("s1", "m1", chat, false, "hello", 1), image, testMessage("s1", "m3", chat, false, "bye", 3))
+
+	msgs, err := sess.PopMessages(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("pop messages: %v", err)
+	}
+	var texts []string
+	for _, msg := range msgs {
+		texts = append(texts, msg.Message)
+	}
+	if !reflect.DeepEqual(texts, []string{"hello", "bye"}) {
+		t.Errorf("popped %q, want the text messages", texts)
+	}
+	if msgs, err = sess.PopMessages(context.Background(), 10); err != nil || len(msgs) != 0 {
+		t.Errorf("second pop = %v, %v; want the image dequeued as well", msgs, err)
+	}
+}
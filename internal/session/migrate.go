@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"wa-mvp-api/internal/logging"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+type MigrationResult struct {
+	SessionID string
+	DeviceJID string
+	Rows      int
+	Err       error
+}
+
+// MigrateToSharedStore copies the device of every per-session directory into
+// the shared store. Sessions that were never paired are skipped, and already
+// migrated devices are left as they are, so the migration can be re-run.
+func MigrateToSharedStore(ctx context.Context, shared *whatsapp.SharedStore, log *slog.Logger) ([]MigrationResult, error) {
+	ids, err := ListSessionIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MigrationResult, 0, len(ids))
+	for _, id := range ids {
+		sessLog := log.With(logging.SessionKey, id)
+		jid, rows, err := shared.ImportSessionDir(ctx, SessionDir(id), id, logging.WALogger(sessLog, "migrate"))
+		res := MigrationResult{SessionID: id, Rows: rows, Err: err}
+		if !jid.IsEmpty() {
+			res.DeviceJID = jid.String()
+		}
+		if err != nil {
+			sessLog.Error("device migration failed", "error", err)
+		} else if res.DeviceJID == "" {
+			sessLog.Info("no paired device to migrate")
+		} else {
+			sessLog.Info("device migrated", "jid", res.DeviceJID, "rows", rows)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (r MigrationResult) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: failed: %v", r.SessionID, r.Err)
+	case r.DeviceJID == "":
+		return fmt.Sprintf("%s: skipped (not paired)", r.SessionID)
+	default:
+		return fmt.Sprintf("%s: migrated %s (%d rows)", r.SessionID, r.DeviceJID, r.Rows)
+	}
+}
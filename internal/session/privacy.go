@@ -0,0 +1,200 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// PrivacySettings decide who sees what of the account. Values are "all",
+// "contacts", "contact_blacklist" (contacts except those excluded on the
+// phone) or "none"; ReadReceipts is "all" or "none", Online "all" or
+// "match_last_seen" and CallAdd "all" or "known".
+type PrivacySettings struct {
+	LastSeen     string `json:"last_seen"`
+	ProfilePhoto string `json:"profile_photo"`
+	About        string `json:"about"`
+	GroupsAdd    string `json:"groups_add"`
+	ReadReceipts string `json:"read_receipts"`
+	Online       string `json:"online"`
+	CallAdd      string `json:"call_add"`
+}
+
+// PrivacyUpdate changes the settings that are set.
+type PrivacyUpdate struct {
+	LastSeen     *string `json:"last_seen,omitempty"`
+	ProfilePhoto *string `json:"profile_photo,omitempty"`
+	About        *string `json:"about,omitempty"`
+	GroupsAdd    *string `json:"groups_add,omitempty"`
+	ReadReceipts *string `json:"read_receipts,omitempty"`
+	Online       *string `json:"online,omitempty"`
+	CallAdd      *string `json:"call_add,omitempty"`
+}
+
+// privacySetting is one setting of a PrivacyUpdate with the values it takes.
+type privacySetting struct {
+	name   string
+	kind   types.PrivacySettingType
+	value  *string
+	values []types.PrivacySetting
+}
+
+var audienceValues = []types.PrivacySetting{
+	types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone,
+}
+
+func (u PrivacyUpdate) settings() []privacySetting {
+	return []privacySetting{
+		{"last_seen", types.PrivacySettingTypeLastSeen, u.LastSeen, audienceValues},
+		{"profile_photo", types.PrivacySettingTypeProfile, u.ProfilePhoto, audienceValues},
+		{"about", types.PrivacySettingTypeStatus, u.About, audienceValues},
+		{"groups_add", types.PrivacySettingTypeGroupAdd, u.GroupsAdd, audienceValues},
+		{"read_receipts", types.PrivacySettingTypeReadReceipts, u.ReadReceipts,
+			[]types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingNone}},
+		{"online", types.PrivacySettingTypeOnline, u.Online,
+			[]types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingMatchLastSeen}},
+		{"call_add", types.PrivacySettingTypeCallAdd, u.CallAdd,
+			[]types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingKnown}},
+	}
+}
+
+// changes validates the update and returns the settings it changes.
+func (u PrivacyUpdate) changes() ([]privacySetting, error) {
+	var out []privacySetting
+	for _, setting := range u.settings() {
+		if setting.value == nil {
+			continue
+		}
+		value := types.PrivacySetting(strings.ToLower(strings.TrimSpace(*setting.value)))
+		valid := make([]string, len(setting.values))
+		ok := false
+		for i, v := range setting.values {
+			valid[i] = string(v)
+			ok = ok || v == value
+		}
+		if !ok {
+			return nil, ErrInvalidPrivacy.withf(map[string]any{"setting": setting.name, "allowed": valid},
+				"%s must be one of %s", setting.name, strings.Join(valid, ", "))
+		}
+		s := string(value)
+		setting.value = &s
+		out = append(out, setting)
+	}
+	if len(out) == 0 {
+		return nil, ErrInvalidPrivacy.withf(nil, "no settings to change")
+	}
+	return out, nil
+}
+
+func newPrivacySettings(s types.PrivacySettings) PrivacySettings {
+	return PrivacySettings{
+		LastSeen:     string(s.LastSeen),
+		ProfilePhoto: string(s.Profile),
+		About:        string(s.Status),
+		GroupsAdd:    string(s.GroupAdd),
+		ReadReceipts: string(s.ReadReceipts),
+		Online:       string(s.Online),
+		CallAdd:      string(s.CallAdd),
+	}
+}
+
+// GetPrivacySettings reads the account's privacy settings. whatsmeow keeps
+// them up to date once fetched, so only the first call asks WhatsApp.
+func (s *Session) GetPrivacySettings(ctx context.Context) (PrivacySettings, error) {
+	if err := s.checkOnline(); err != nil {
+		return PrivacySettings{}, err
+	}
+	settings, err := s.Client.TryFetchPrivacySettings(ctx, false)
+	if err != nil {
+		return PrivacySettings{}, upstreamError(err)
+	}
+	return newPrivacySettings(*settings), nil
+}
+
+// UpdatePrivacySettings changes the settings in update one at a time and
+// returns the resulting settings. A failure leaves the earlier changes made.
+func (s *Session) UpdatePrivacySettings(ctx context.Context, update PrivacyUpdate) (PrivacySettings, error) {
+	changes, err := update.changes()
+	if err != nil {
+		return PrivacySettings{}, err
+	}
+	if err := s.checkOnline(); err != nil {
+		return PrivacySettings{}, err
+	}
+	var settings types.PrivacySettings
+	for _, change := range changes {
+		settings, err = s.Client.SetPrivacySetting(ctx, change.kind, types.PrivacySetting(*change.value))
+		if err != nil {
+			return PrivacySettings{}, upstreamError(err)
+		}
+		s.Log.Info("privacy setting changed", "setting", change.name, "value", *change.value)
+	}
+	return newPrivacySettings(settings), nil
+}
+
+// BlocklistEvent is the data of a "blocklist" webhook event. Action
+// "modify" means the list changed in ways not described by Changes and
+// should be read again.
+type BlocklistEvent struct {
+	Action  string            `json:"action,omitempty"`
+	Changes []BlocklistChange `json:"changes,omitempty"`
+}
+
+type BlocklistChange struct {
+	JID string `json:"jid"`
+	// Action is "block" or "unblock".
+	Action string `json:"action"`
+}
+
+// GetBlocklist returns the JIDs of the users the account has blocked.
+func (s *Session) GetBlocklist(ctx context.Context) ([]string, error) {
+	if err := s.checkOnline(); err != nil {
+		return nil, err
+	}
+	list, err := s.Client.GetBlocklist(ctx)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return blocklistJIDs(list), nil
+}
+
+// SetBlocked blocks or unblocks a user and returns the resulting blocklist.
+func (s *Session) SetBlocked(ctx context.Context, jid string, blocked bool) ([]string, error) {
+	user, err := userJID(jid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkOnline(); err != nil {
+		return nil, err
+	}
+	action := events.BlocklistChangeActionUnblock
+	if blocked {
+		action = events.BlocklistChangeActionBlock
+	}
+	list, err := s.Client.UpdateBlocklist(ctx, user, action)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	s.Log.Info("blocklist updated", "jid", user.String(), "action", string(action))
+	return blocklistJIDs(list), nil
+}
+
+func blocklistJIDs(list *types.Blocklist) []string {
+	jids := make([]string, 0, len(list.JIDs))
+	for _, jid := range list.JIDs {
+		jids = append(jids, jid.ToNonAD().String())
+	}
+	sort.Strings(jids)
+	return jids
+}
+
+func newBlocklistEvent(evt *events.Blocklist) BlocklistEvent {
+	out := BlocklistEvent{Action: string(evt.Action)}
+	for _, change := range evt.Changes {
+		out.Changes = append(out.Changes, BlocklistChange{JID: change.JID.ToNonAD().String(), Action: string(change.Action)})
+	}
+	return out
+}
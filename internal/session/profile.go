@@ -0,0 +1,132 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+	"wa-mvp-api/internal/whatsapp"
+)
+
+// The limits WhatsApp's apps apply to the push name and about text.
+const (
+	maxPushNameLength = 25
+	maxAboutLength    = 139
+)
+
+// Profile is how the linked account presents itself to other users.
+type Profile struct {
+	JID        string `json:"jid"`
+	PushName   string `json:"push_name"`
+	About      string `json:"about"`
+	PictureID  string `json:"picture_id,omitempty"`
+	PictureURL string `json:"picture_url,omitempty"`
+}
+
+// ProfileUpdate changes the fields that are set.
+type ProfileUpdate struct {
+	PushName *string `json:"push_name,omitempty"`
+	About    *string `json:"about,omitempty"`
+}
+
+func (u *ProfileUpdate) normalize() error {
+	if u.PushName == nil && u.About == nil {
+		return ErrInvalidProfile.withf(nil, "set push_name or about")
+	}
+	if u.PushName != nil {
+		name := strings.TrimSpace(*u.PushName)
+		if name == "" || utf8.RuneCountInString(name) > maxPushNameLength {
+			return ErrInvalidProfile.withf(map[string]any{"max_length": maxPushNameLength}, "push_name must be 1 to %d characters", maxPushNameLength)
+		}
+		u.PushName = &name
+	}
+	if u.About != nil {
+		about := strings.TrimSpace(*u.About)
+		if utf8.RuneCountInString(about) > maxAboutLength {
+			return ErrInvalidProfile.withf(map[string]any{"max_length": maxAboutLength}, "about must be at most %d characters", maxAboutLength)
+		}
+		u.About = &about
+	}
+	return nil
+}
+
+// GetProfile reads the account's push name from the device store and its
+// about text and picture from WhatsApp.
+func (s *Session) GetProfile(ctx context.Context) (*Profile, error) {
+	if err := s.checkOnline(); err != nil {
+		return nil, err
+	}
+	own := s.Client.Store.ID.ToNonAD()
+	profile := &Profile{JID: own.String(), PushName: s.Client.Store.PushName}
+
+	infos, err := s.Client.GetUserInfo(ctx, []types.JID{own})
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	profile.About = infos[own].Status
+
+	picture, err := s.Client.GetProfilePictureInfo(ctx, own, &whatsmeow.GetProfilePictureParams{})
+	switch {
+	case errors.Is(err, whatsmeow.ErrProfilePictureNotSet):
+	case err != nil:
+		return nil, upstreamError(err)
+	case picture != nil:
+		profile.PictureID = picture.ID
+		profile.PictureURL = picture.URL
+	}
+	return profile, nil
+}
+
+// UpdateProfile changes the push name, which the account's other devices
+// learn through app state, and the about text.
+func (s *Session) UpdateProfile(ctx context.Context, update ProfileUpdate) error {
+	if err := update.normalize(); err != nil {
+		return err
+	}
+	if err := s.checkOnline(); err != nil {
+		return err
+	}
+	if update.PushName != nil {
+		if err := s.Client.SendAppState(ctx, appstate.BuildSettingPushName(*update.PushName)); err != nil {
+			return upstreamError(err)
+		}
+	}
+	if update.About != nil {
+		if err := s.Client.SetStatusMessage(ctx, *update.About); err != nil {
+			return upstreamError(err)
+		}
+	}
+	s.Log.Info("profile updated", "push_name", update.PushName != nil, "about", update.About != nil)
+	return nil
+}
+
+// SetProfilePicture crops and scales image to a profile picture and sets it,
+// returning the new picture ID. A nil image removes the picture.
+func (s *Session) SetProfilePicture(ctx context.Context, image []byte) (string, error) {
+	var picture []byte
+	if image != nil {
+		var err error
+		if picture, err = whatsapp.ProfilePicture(image); err != nil {
+			return "", ErrInvalidImage.with(nil, err)
+		}
+	}
+	if err := s.checkOnline(); err != nil {
+		return "", err
+	}
+	// Without a target, the picture query applies to the account itself.
+	id, err := s.Client.SetGroupPhoto(ctx, types.EmptyJID, picture)
+	if errors.Is(err, whatsmeow.ErrInvalidImageFormat) {
+		return "", ErrInvalidImage.with(nil, err)
+	} else if err != nil {
+		return "", upstreamError(err)
+	}
+	if image == nil {
+		id = ""
+	}
+	s.Log.Info("profile picture updated", "removed", image == nil)
+	return id, nil
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"wa-mvp-api/internal/whatsapp"
+)
+
+// SetProxy checks a new proxy URL for the session, applies it to the client,
+// persists it and reconnects so the websocket goes through it. If it cannot
+// be persisted the client goes back to the previous proxy. An empty URL
+// removes the proxy.
+func (m *Manager) SetProxy(ctx context.Context, sess *Session, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if err := checkProxy(ctx, sess.Log, raw); err != nil {
+		return err
+	}
+
+	sess.settingsMu.Lock()
+	defer sess.settingsMu.Unlock()
+
+	settings := sess.GetSettings()
+	previous := settings.ProxyURL
+	settings.ProxyURL = raw
+	if err := whatsapp.SetProxy(sess.Client, raw); err != nil {
+		return err
+	}
+	if err := m.gatewayStore().SetSettings(ctx, sess.ID, settings); err != nil {
+		if rerr := whatsapp.SetProxy(sess.Client, previous); rerr != nil {
+			sess.Log.Error("failed to restore previous proxy", "proxy", whatsapp.RedactProxyURL(previous), "error", rerr)
+		}
+		return err
+	}
+
+	sess.Mutex.Lock()
+	sess.Settings = settings
+	sess.Mutex.Unlock()
+
+	sess.Log.Info("proxy changed", "proxy", whatsapp.RedactProxyURL(raw))
+	go m.restart(sess, "proxy changed")
+	return nil
+}
+
+// checkProxy runs whatsapp.CheckProxy on raw. Why a proxy is unreachable is
+// only logged: passed on, the dial error would tell callers which of any
+// host's ports are open.
+func checkProxy(ctx context.Context, log *slog.Logger, raw string) error {
+	err := whatsapp.CheckProxy(ctx, raw)
+	if errors.Is(err, whatsapp.ErrProxyUnreachable) {
+		log.Warn("proxy check failed", "proxy", whatsapp.RedactProxyURL(raw), "error", err)
+		return fmt.Errorf("%w: proxy unreachable", ErrInvalidOptions)
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testProxy is an in-process HTTP proxy that answers every request itself
+// and counts them.
+type testProxy struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+	p := &testProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.requests.Add(1)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// failingSettingsStore refuses to persist settings.
+type failingSettingsStore struct {
+	Store
+}
+
+var errSettingsRefused = errors.New("settings refused")
+
+func (failingSettingsStore) SetSettings(context.Context, string, Settings) error {
+	return errSettingsRefused
+}
+
+// newSettingsTestSession returns a manager on a new store with one session,
+// suspended so that changing its proxy does not reconnect it.
+func newSettingsTestSession(t *testing.T) (*Manager, *Session, *SQLStore) {
+	t.Helper()
+	m, st := newTestManager(t)
+	return m, addTestSession(t, m, st, "s1"), st
+}
+
+// mediaRequest makes a media download request with the session's client and
+// reports whether p received it.
+func mediaRequest(t *testing.T, sess *Session, p *testProxy) bool {
+	t.Helper()
+	before := p.requests.Load()
+	resp, err := sess.Client.DangerousInternals().DoMediaDownloadRequest(context.Background(), "http://media.invalid/file")
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return p.requests.Load() > before
+}
+
+func TestSetProxy(t *testing.T) {
+	ctx := context.Background()
+	m, sess, st := newSettingsTestSession(t)
+	p := newTestProxy(t)
+
+	if err := m.SetProxy(ctx, sess, " "+p.URL+" "); err != nil {
+		t.Fatalf("set proxy: %v", err)
+	}
+	if !mediaRequest(t, sess, p) {
+		t.Error("media request did not go through the proxy")
+	}
+	rec, err := st.GetSession(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Settings.ProxyURL != p.URL || sess.GetSettings().ProxyURL != p.URL {
+		t.Errorf("proxy URL stored %q, in session %q; want %q", rec.Settings.ProxyURL, sess.GetSettings().ProxyURL, p.URL)
+	}
+
+	if err := m.SetProxy(ctx, sess, ""); err != nil {
+		t.Fatalf("remove proxy: %v", err)
+	}
+	if mediaRequest(t, sess, p) {
+		t.Error("media request went through the removed proxy")
+	}
+	if rec, _ := st.GetSession(ctx, "s1"); rec.Settings.ProxyURL != "" {
+		t.Errorf("proxy URL stored %q after removing it", rec.Settings.ProxyURL)
+	}
+}
+
+func TestSetProxyUnreachable(t *testing.T) {
+	ctx := context.Background()
+	m, sess, st := newSettingsTestSession(t)
+	p := newTestProxy(t)
+	p.Close()
+
+	err := m.SetProxy(ctx, sess, p.URL)
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("set proxy error = %v, want %v", err, ErrInvalidOptions)
+	}
+	if u, _ := url.Parse(p.URL); strings.Contains(err.Error(), u.Host) {
+		t.Errorf("set proxy error %q names the proxy address", err)
+	}
+	if rec, _ := st.GetSession(ctx, "s1"); rec.Settings.ProxyURL != "" {
+		t.Errorf("proxy URL stored %q for an unreachable proxy", rec.Settings.ProxyURL)
+	}
+}
+
+// A proxy that cannot be persisted is not left applied either.
+func TestSetProxyRollsBack(t *testing.T) {
+	ctx := context.Background()
+	m, sess, st := newSettingsTestSession(t)
+	first, second := newTestProxy(t), newTestProxy(t)
+
+	if err := m.SetProxy(ctx, sess, first.URL); err != nil {
+		t.Fatalf("set proxy: %v", err)
+	}
+	m.UseStore(failingSettingsStore{st})
+	if err := m.SetProxy(ctx, sess, second.URL); !errors.Is(err, errSettingsRefused) {
+		t.Fatalf("set proxy error = %v, want %v", err, errSettingsRefused)
+	}
+
+	if mediaRequest(t, sess, second) {
+		t.Error("media request went through the proxy that was not persisted")
+	}
+	if !mediaRequest(t, sess, first) {
+		t.Error("media request did not go through the previous proxy")
+	}
+	if got := sess.GetSettings().ProxyURL; got != first.URL {
+		t.Errorf("session proxy URL = %q, want %q", got, first.URL)
+	}
+}
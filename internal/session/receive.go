@@ -0,0 +1,134 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Receive requests can wait for messages instead of returning empty. The
+// wait is capped, and so is the number of requests waiting on one session,
+// since each holds a connection open.
+const (
+	MaxReceiveWait    = time.Minute
+	MaxReceiveWaiters = 4
+)
+
+var ErrTooManyWaiters = newError(CodeTooManyWaiters, "too many receive requests waiting")
+
+// messageSignal wakes receive requests waiting on a session. The zero value
+// is ready to use.
+type messageSignal struct {
+	mu      sync.Mutex
+	ch      chan struct{}
+	waiters int
+	closed  bool
+}
+
+// wait returns a channel that is closed on the next notify. Once the signal
+// is closed the channel is closed already.
+func (s *messageSignal) wait() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+		if s.closed {
+			close(s.ch)
+		}
+	}
+	return s.ch
+}
+
+func (s *messageSignal) notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch != nil && !s.closed {
+		close(s.ch)
+		s.ch = nil
+	}
+}
+
+// close wakes every waiter for good, e.g. when the session is deleted or the
+// server shuts down.
+func (s *messageSignal) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.ch != nil {
+		close(s.ch)
+	}
+}
+
+func (s *messageSignal) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *messageSignal) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.waiters >= MaxReceiveWaiters {
+		return false
+	}
+	s.waiters++
+	return true
+}
+
+func (s *messageSignal) release() {
+	s.mu.Lock()
+	s.waiters--
+	s.mu.Unlock()
+}
+
+// WaitMessages calls fetch until it reports finding messages, waiting for
+// AddMessage in between, for at most wait. It returns early with ctx's error
+// when ctx is done, and without one when the session is closed. When fetch
+// finds nothing at first and MaxReceiveWaiters requests are already waiting,
+// it fails with ErrTooManyWaiters.
+func (s *Session) WaitMessages(ctx context.Context, wait time.Duration, fetch func() (bool, error)) error {
+	// Take the channel before fetching so a message stored in between is
+	// not missed.
+	signal := s.signal.wait()
+	found, err := fetch()
+	if err != nil || found || wait <= 0 {
+		return err
+	}
+
+	if !s.signal.acquire() {
+		return ErrTooManyWaiters.with(map[string]any{"max_waiters": MaxReceiveWaiters}, nil)
+	}
+	defer s.signal.release()
+
+	timer := time.NewTimer(min(wait, MaxReceiveWait))
+	defer timer.Stop()
+	for {
+		select {
+		case <-signal:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if s.signal.isClosed() {
+			return nil
+		}
+		signal = s.signal.wait()
+		if found, err := fetch(); err != nil || found {
+			return err
+		}
+	}
+}
+
+// ReleaseWaiters ends every pending wait for messages, so that the HTTP
+// server can shut down without waiting for long polls to time out.
+func (m *Manager) ReleaseWaiters() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, sess := range m.sessions {
+		sess.signal.close()
+	}
+}
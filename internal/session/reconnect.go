@@ -0,0 +1,163 @@
+package session
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	reconnectBaseDelay   = 2 * time.Second
+	reconnectMaxDelay    = 5 * time.Minute
+	reconnectMaxAttempts = 20
+	reconnectJitter      = 0.2
+)
+
+// reconnectDelay returns the backoff delay before the given (zero-based)
+// attempt: exponential growth capped at reconnectMaxDelay, with ±20% jitter so
+// that many sessions dropped at once don't reconnect in lockstep.
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+	}
+	jitter := 1 + reconnectJitter*(2*rand.Float64()-1)
+	return time.Duration(float64(delay) * jitter)
+}
+
+// scheduleReconnect starts the reconnect supervisor for a session unless one is
+// already running or the session is suspended. Only one supervisor goroutine
+// exists per session at any time.
+func (m *Manager) scheduleReconnect(sess *Session, reason string) {
+	if sess == nil || sess.Client == nil {
+		return
+	}
+
+	sess.Mutex.Lock()
+	if sess.reconnectCancel != nil || sess.State == StateSuspended || sess.State == StateLoggedOut {
+		sess.Mutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sess.reconnectCancel = cancel
+	sess.Mutex.Unlock()
+
+	sess.Log.Info("reconnect scheduled", "reason", reason)
+	go m.superviseReconnect(ctx, sess)
+}
+
+func (m *Manager) superviseReconnect(ctx context.Context, sess *Session) {
+	defer m.stopReconnect(sess)
+
+	for attempt := 0; ; attempt++ {
+		if attempt >= reconnectMaxAttempts {
+			m.suspend(sess, "gave up after too many failed reconnect attempts", time.Time{})
+			return
+		}
+
+		delay := reconnectDelay(attempt)
+		sess.Log.Info("reconnecting", "delay", delay, "attempt", attempt+1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		switch sess.GetState() {
+		case StateSuspended, StateLoggedOut:
+			return
+		}
+		if sess.Client.IsConnected() {
+			sess.UpdateStatusFromClient()
+			return
+		}
+
+		sess.RecordReconnectAttempt()
+		if err := m.connect(sess); err == nil {
+			return
+		}
+	}
+}
+
+// stopReconnect cancels any running reconnect supervisor for the session.
+func (m *Manager) stopReconnect(sess *Session) {
+	sess.Mutex.Lock()
+	cancel := sess.reconnectCancel
+	sess.reconnectCancel = nil
+	sess.Mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// suspend stops automatic reconnection. If until is set, the session is resumed
+// automatically at that time (used for temporary bans).
+func (m *Manager) suspend(sess *Session, reason string, until time.Time) {
+	m.stopReconnect(sess)
+	sess.Client.Disconnect()
+
+	sess.Mutex.Lock()
+	sess.SuspendedReason = reason
+	sess.SuspendedUntil = until
+	sess.setStateLocked(StateSuspended, reason)
+	if sess.resumeTimer != nil {
+		sess.resumeTimer.Stop()
+		sess.resumeTimer = nil
+	}
+	if !until.IsZero() {
+		sess.resumeTimer = time.AfterFunc(time.Until(until), func() {
+			m.Resume(sess)
+		})
+	}
+	sess.Mutex.Unlock()
+
+	sess.Log.Warn("session suspended", "reason", reason, "until", until)
+}
+
+// Resume clears a suspension and connects the session immediately. For an
+// exported session this makes this instance the active one again.
+func (m *Manager) Resume(sess *Session) {
+	m.unsuspend(sess)
+	m.Connect(sess)
+}
+
+// unsuspend clears a suspension without connecting the session.
+func (m *Manager) unsuspend(sess *Session) {
+	sess.Mutex.Lock()
+	if sess.resumeTimer != nil {
+		sess.resumeTimer.Stop()
+		sess.resumeTimer = nil
+	}
+	if sess.SuspendedReason == exportedReason {
+		if err := sess.store.SetExported(context.Background(), sess.ID, time.Time{}); err != nil {
+			sess.Log.Error("failed to clear export mark", "error", err)
+		}
+	}
+	sess.SuspendedReason = ""
+	sess.SuspendedUntil = time.Time{}
+	if sess.State == StateSuspended {
+		sess.setStateLocked(StateDisconnected, "resumed")
+	}
+	sess.ReconnectAttempts = 0
+	sess.Mutex.Unlock()
+
+	m.stopReconnect(sess)
+}
+
+// restart drops the current connection and connects again straight away, e.g.
+// to apply a new proxy. Suspended and logged out sessions are left alone.
+func (m *Manager) restart(sess *Session, reason string) {
+	switch sess.GetState() {
+	case StateSuspended, StateLoggedOut:
+		return
+	}
+
+	m.stopReconnect(sess)
+	sess.Client.Disconnect()
+	sess.SetState(StateDisconnected, reason)
+	m.Connect(sess)
+}
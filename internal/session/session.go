@@ -1,27 +1,74 @@
 package session
 
 import (
+	"context"
+	"log/slog"
+	"strings"
 	"sync"
+	"time"
 
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
 )
 
 type Session struct {
 	ID        string
-	Token     string
+	Name      string
+	Labels    []string
+	Settings  Settings
+	CreatedAt time.Time
 	Client    *whatsmeow.Client
+	Container *sqlstore.Container
 	QR        string
 	LoggedIn  bool
 	Connected bool
 	JID       string
-	Messages  []IncomingMessage
+	Log       *slog.Logger
 	Mutex     sync.RWMutex
+
+	State                ConnState
+	History              []StateTransition
+	LastConnectedAt      time.Time
+	LastDisconnectedAt   time.Time
+	LastDisconnectReason string
+	ReconnectAttempts    int
+	LastConnectError     string
+	LastConnectErrorAt   time.Time
+	KeepAliveTimeouts    int
+	LastKeepAliveTimeout time.Time
+	SuspendedReason      string
+	SuspendedUntil       time.Time
+
+	// QRExpiresAt is when QR stops being accepted. QRTimedOut is set once
+	// WhatsApp stopped issuing codes; reconnecting starts a new round.
+	QRExpiresAt time.Time
+	QRTimedOut  bool
+
+	// settingsMu is held across changes to Settings, which read the current
+	// settings, persist a modified copy and then publish it, so that
+	// concurrent changes do not undo each other.
+	settingsMu sync.Mutex
+
+	store           Store
+	webhook         *webhook
+	limiter         *rateLimiter
+	signal          messageSignal
+	history         *historyState
+	profiles        map[string]ContactProfile
+	callReplies     map[string]time.Time
+	reconnectCancel context.CancelFunc
+	resumeTimer     *time.Timer
 }
 
 type SessionInfo struct {
 	ID        string
+	Name      string
+	Labels    []string
+	State     ConnState
 	Connected bool
 	JID       string
+	CreatedAt time.Time
 }
 
 type IncomingMessage struct {
@@ -37,17 +84,71 @@ func (s *Session) Snapshot() SessionInfo {
 
 	return SessionInfo{
 		ID:        s.ID,
+		Name:      s.Name,
+		Labels:    append([]string(nil), s.Labels...),
+		State:     s.State,
 		Connected: s.Connected,
 		JID:       s.JID,
+		CreatedAt: s.CreatedAt,
 	}
 }
 
+func (s *Session) GetSettings() Settings {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.Settings
+}
+
+// SetQR replaces the pairing code without an expiry; an empty code clears it.
 func (s *Session) SetQR(qr string) {
 	s.Mutex.Lock()
 	s.QR = qr
+	s.QRExpiresAt = time.Time{}
+	s.QRTimedOut = false
+	s.Mutex.Unlock()
+}
+
+func (s *Session) setQRCode(code string, timeout time.Duration) {
+	s.Mutex.Lock()
+	s.QR = code
+	s.QRExpiresAt = time.Now().Add(timeout)
+	s.QRTimedOut = false
+	s.Mutex.Unlock()
+}
+
+func (s *Session) setQRTimedOut() {
+	s.Mutex.Lock()
+	s.QR = ""
+	s.QRExpiresAt = time.Time{}
+	s.QRTimedOut = true
+	s.setStateLocked(StateDisconnected, "QR code not scanned in time")
 	s.Mutex.Unlock()
 }
 
+// QRStatus describes where a session is in pairing.
+type QRStatus struct {
+	Code      string
+	ExpiresAt time.Time
+	TimedOut  bool
+	// LoggedIn is set as soon as pairing succeeds, before the session has
+	// reconnected with its new identity.
+	LoggedIn bool
+	JID      string
+}
+
+func (s *Session) QRStatus() QRStatus {
+	s.Mutex.RLock()
+	status := QRStatus{Code: s.QR, ExpiresAt: s.QRExpiresAt, TimedOut: s.QRTimedOut, LoggedIn: s.LoggedIn, JID: s.JID}
+	s.Mutex.RUnlock()
+
+	if s.Client != nil && s.Client.Store.ID != nil {
+		status.LoggedIn = true
+		status.JID = s.Client.Store.ID.String()
+		status.Code = ""
+	}
+	return status
+}
+
 func (s *Session) GetQR() string {
 	s.Mutex.RLock()
 	defer s.Mutex.RUnlock()
@@ -59,13 +160,17 @@ func (s *Session) UpdateStatusFromClient() {
 	defer s.Mutex.Unlock()
 
 	if s.Client == nil {
-		s.Connected = false
+		s.setStateLocked(StateDisconnected, "no client")
 		s.LoggedIn = false
 		s.JID = ""
 		return
 	}
 
-	s.Connected = s.Client.IsConnected()
+	if s.Client.IsConnected() {
+		s.setStateLocked(StateConnected, "")
+	} else if s.State == StateConnected {
+		s.setStateLocked(StateDisconnected, "client not connected")
+	}
 	s.LoggedIn = s.Client.Store.ID != nil
 	if s.Client.Store.ID != nil {
 		s.JID = s.Client.Store.ID.String()
@@ -74,12 +179,6 @@ func (s *Session) UpdateStatusFromClient() {
 	}
 }
 
-func (s *Session) SetConnected(connected bool) {
-	s.Mutex.Lock()
-	s.Connected = connected
-	s.Mutex.Unlock()
-}
-
 func (s *Session) SetLoggedIn(loggedIn bool) {
 	s.Mutex.Lock()
 	s.LoggedIn = loggedIn
@@ -92,37 +191,91 @@ func (s *Session) SetJID(jid string) {
 	s.Mutex.Unlock()
 }
 
-func (s *Session) AddMessage(msg IncomingMessage) {
-	s.Mutex.Lock()
-	s.Messages = append(s.Messages, msg)
-	s.Mutex.Unlock()
+// AddMessage persists an inbound message, queues it for PopMessages, wakes
+// requests waiting for messages and forwards it to the session's webhook.
+func (s *Session) AddMessage(msg Message) {
+	msg.SessionID = s.ID
+	if err := s.store.AddMessage(context.Background(), msg); err != nil {
+		s.Log.Error("failed to store message", "message_id", msg.ID, "error", err)
+	} else {
+		s.signal.notify()
+	}
+	s.emit("message", msg)
 }
 
-func (s *Session) PopMessages(limit int) []IncomingMessage {
-	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
+// RecordOutgoing persists a message sent by this session.
+func (s *Session) RecordOutgoing(msg Message) {
+	msg.SessionID = s.ID
+	msg.FromMe = true
+	if err := s.store.AddMessage(context.Background(), msg); err != nil {
+		s.Log.Error("failed to store outgoing message", "message_id", msg.ID, "error", err)
+	}
+}
 
-	if limit <= 0 || limit > len(s.Messages) {
-		limit = len(s.Messages)
+// PopMessages dequeues up to limit messages and returns the text messages
+// among them; the v1 API they are for has no notion of other types.
+func (s *Session) PopMessages(ctx context.Context, limit int) ([]IncomingMessage, error) {
+	msgs, err := s.store.PopPendingMessages(ctx, s.ID, limit)
+	if err != nil {
+		return nil, err
 	}
-	if limit == 0 {
-		return nil
+
+	out := make([]IncomingMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Type == MessageText {
+			out = append(out, msg.Incoming())
+		}
 	}
+	return out, nil
+}
 
-	out := make([]IncomingMessage, limit)
-	copy(out, s.Messages[:limit])
-	s.Messages = s.Messages[limit:]
-	return out
+// ReceiveMessages returns the queued messages following the one with
+// sequence number after, acknowledging everything up to it. With after zero
+// it returns all queued messages. Unlike PopMessages nothing is dequeued before the
+// caller has seen it.
+func (s *Session) ReceiveMessages(ctx context.Context, after int64, limit int) ([]Message, error) {
+	return s.store.ReceiveMessages(ctx, s.ID, after, limit)
 }
 
-func (s *Session) SetToken(token string) {
-	s.Mutex.Lock()
-	s.Token = token
-	s.Mutex.Unlock()
+// ListMessages returns the session's stored messages matching filter, newest
+// first, both inbound and outbound.
+func (s *Session) ListMessages(ctx context.Context, filter MessageFilter) ([]Message, error) {
+	return s.store.ListMessages(ctx, s.ID, filter)
 }
 
-func (s *Session) GetToken() string {
-	s.Mutex.RLock()
-	defer s.Mutex.RUnlock()
-	return s.Token
+// ParseJID accepts a JID or a phone number in international format and
+// returns the JID in the form messages are stored with.
+func ParseJID(raw string) (string, error) {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "+")
+	if !strings.Contains(raw, "@") {
+		if !validPhone(raw) {
+			return "", ErrInvalidJID.withf(map[string]any{"jid": raw}, "expected a JID or a phone number in international format")
+		}
+		return types.NewJID(raw, types.DefaultUserServer).String(), nil
+	}
+	jid, err := types.ParseJID(raw)
+	if err != nil || jid.User == "" {
+		return "", ErrInvalidJID.withf(map[string]any{"jid": raw}, "expected a JID or a phone number in international format")
+	}
+	return jid.ToNonAD().String(), nil
+}
+
+func (s *Session) PendingMessageCount() int {
+	n, err := s.store.CountPendingMessages(context.Background(), s.ID)
+	if err != nil {
+		s.Log.Warn("failed to count pending messages", "error", err)
+	}
+	return n
+}
+
+// checkOnline returns the error for requests to WhatsApp made while the
+// session is not connected or not paired.
+func (s *Session) checkOnline() error {
+	if s.Client == nil || !s.Client.IsConnected() {
+		return ErrNotConnected.with(map[string]any{"state": s.GetState()}, nil)
+	}
+	if s.Client.Store.ID == nil {
+		return ErrNotLoggedIn
+	}
+	return nil
 }
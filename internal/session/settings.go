@@ -0,0 +1,218 @@
+package session
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"wa-mvp-api/internal/whatsapp"
+)
+
+var (
+	ErrInvalidOptions = newError(CodeInvalidOptions, "invalid session options")
+	ErrRateLimited    = newError(CodeRateLimited, "rate limit exceeded")
+)
+
+const (
+	maxNameLength      = 100
+	maxLabels          = 20
+	maxLabelLength     = 64
+	maxCallReplyLength = 1000
+)
+
+// Settings are per-session options chosen when the session is created.
+type Settings struct {
+	// WebhookURL receives session events as JSON POST requests.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// DeviceName, DevicePlatform and DeviceVersion override the defaults
+	// shown in the phone's Linked Devices list (see whatsapp.DeviceInfo).
+	// They only take effect when the session is paired.
+	DeviceName     string `json:"device_name,omitempty"`
+	DevicePlatform string `json:"device_platform,omitempty"`
+	DeviceVersion  string `json:"device_version,omitempty"`
+	// AutoRead marks inbound messages as read as soon as they arrive.
+	AutoRead bool `json:"auto_read,omitempty"`
+	// RateLimitPerMinute caps outgoing messages; zero means unlimited.
+	RateLimitPerMinute int `json:"rate_limit_per_minute,omitempty"`
+	// ProxyURL routes the websocket and media transfers through an http,
+	// https or socks5 proxy. See Manager.SetProxy for changing it later.
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// HistoryDays and HistoryMessages bound the history stored after
+	// pairing: messages older than that many days, and beyond that many of
+	// the latest per chat, are dropped. Zero keeps everything the phone
+	// sends. HistoryDays is also asked of the phone when pairing.
+	HistoryDays     int `json:"history_days,omitempty"`
+	HistoryMessages int `json:"history_messages,omitempty"`
+	// HistoryWebhooks forwards synced history to the webhook as "history"
+	// events; without it only live messages are sent.
+	HistoryWebhooks bool `json:"history_webhooks,omitempty"`
+	// RejectCalls declines incoming voice and video calls; CallReply, if
+	// set, is sent to the caller as a text message. See Manager.SetCallSettings
+	// for changing them later.
+	RejectCalls bool   `json:"reject_calls,omitempty"`
+	CallReply   string `json:"call_reply,omitempty"`
+}
+
+// SessionOptions describe a session to be created.
+type SessionOptions struct {
+	Name     string
+	Labels   []string
+	Settings Settings
+}
+
+func (o *SessionOptions) normalize() error {
+	o.Name = strings.TrimSpace(o.Name)
+	if len(o.Name) > maxNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidOptions, maxNameLength)
+	}
+
+	labels, err := normalizeLabels(o.Labels)
+	if err != nil {
+		return err
+	}
+	o.Labels = labels
+
+	return o.Settings.normalize()
+}
+
+func normalizeLabels(in []string) ([]string, error) {
+	out := make([]string, 0, len(in))
+	seen := make(map[string]bool, len(in))
+	for _, label := range in {
+		label = strings.TrimSpace(label)
+		if label == "" || seen[label] {
+			continue
+		}
+		if len(label) > maxLabelLength {
+			return nil, fmt.Errorf("%w: label %q is longer than %d characters", ErrInvalidOptions, label, maxLabelLength)
+		}
+		seen[label] = true
+		out = append(out, label)
+	}
+	if len(out) > maxLabels {
+		return nil, fmt.Errorf("%w: at most %d labels are allowed", ErrInvalidOptions, maxLabels)
+	}
+	return out, nil
+}
+
+func (s *Settings) normalize() error {
+	s.WebhookURL = strings.TrimSpace(s.WebhookURL)
+	if s.WebhookURL != "" {
+		u, err := url.Parse(s.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: webhook_url must be an absolute http or https URL", ErrInvalidOptions)
+		}
+	}
+
+	s.DeviceName = strings.TrimSpace(s.DeviceName)
+	if len(s.DeviceName) > maxNameLength {
+		return fmt.Errorf("%w: device_name is longer than %d characters", ErrInvalidOptions, maxNameLength)
+	}
+	s.DevicePlatform = strings.ToLower(strings.TrimSpace(s.DevicePlatform))
+	s.DeviceVersion = strings.TrimSpace(s.DeviceVersion)
+	if err := s.clientOptions().Device.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+
+	if s.RateLimitPerMinute < 0 {
+		return fmt.Errorf("%w: rate_limit_per_minute must not be negative", ErrInvalidOptions)
+	}
+	if s.HistoryDays < 0 || s.HistoryMessages < 0 {
+		return fmt.Errorf("%w: history_days and history_messages must not be negative", ErrInvalidOptions)
+	}
+
+	s.CallReply = strings.TrimSpace(s.CallReply)
+	if utf8.RuneCountInString(s.CallReply) > maxCallReplyLength {
+		return fmt.Errorf("%w: call_reply is longer than %d characters", ErrInvalidOptions, maxCallReplyLength)
+	}
+
+	s.ProxyURL = strings.TrimSpace(s.ProxyURL)
+	if err := whatsapp.ValidateProxyURL(s.ProxyURL); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
+func (s Settings) clientOptions() whatsapp.ClientOptions {
+	return whatsapp.ClientOptions{
+		Device: whatsapp.DeviceInfo{
+			Name:        s.DeviceName,
+			Platform:    s.DevicePlatform,
+			Version:     s.DeviceVersion,
+			HistoryDays: uint32(s.HistoryDays),
+		},
+		ProxyURL: s.ProxyURL,
+	}
+}
+
+// hasLabels reports whether every wanted label is present.
+func hasLabels(labels []string, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, l := range labels {
+			if l == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimiter is a token bucket refilled continuously at perMinute tokens per
+// minute, allowing bursts up to perMinute.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{perMinute: perMinute, tokens: float64(perMinute), last: time.Now()}
+}
+
+// allow takes a token if one is available. A nil limiter allows everything.
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Minutes() * float64(l.perMinute)
+	if capacity := float64(l.perMinute); l.tokens > capacity {
+		l.tokens = capacity
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// retryAfter is how long until allow will next succeed.
+func (l *rateLimiter) retryAfter() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missing := 1 - l.tokens - time.Since(l.last).Minutes()*float64(l.perMinute)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(l.perMinute) * float64(time.Minute))
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"fmt"
+)
+
+var ErrShuttingDown = newError(CodeShuttingDown, "gateway is shutting down")
+
+type ShutdownReport struct {
+	Sessions          int      `json:"sessions"`
+	Disconnected      int      `json:"disconnected"`
+	ClosedStores      int      `json:"closed_stores"`
+	PendingMessages   int      `json:"pending_messages"`
+	AbandonedSends    bool     `json:"abandoned_sends"`
+	UndeliveredEvents int      `json:"undelivered_events"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+func (m *Manager) isClosing() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.closing
+}
+
+// beginSend registers an in-flight send so that Shutdown can wait for it.
+// It returns false once shutdown has started; callers must call
+// m.inflight.Done() when it returns true.
+func (m *Manager) beginSend() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closing {
+		return false
+	}
+	m.inflight.Add(1)
+	return true
+}
+
+// Shutdown stops accepting new sends, waits for in-flight sends, then
+// disconnects every client, drains webhook queues and closes the device and
+// gateway stores. Queued inbound messages are already persisted and are only
+// counted; webhook events not delivered before ctx expires are saved and
+// delivered after the next start. Whatever could not be finished is listed in
+// the report.
+func (m *Manager) Shutdown(ctx context.Context) ShutdownReport {
+	m.mu.Lock()
+	m.closing = true
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		sessions = append(sessions, sess)
+	}
+	m.mu.Unlock()
+
+	report := ShutdownReport{Sessions: len(sessions)}
+
+	drained := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		report.AbandonedSends = true
+		report.Errors = append(report.Errors, "timed out waiting for in-flight sends")
+	}
+
+	for _, sess := range sessions {
+		m.stopReconnect(sess)
+		sess.signal.close()
+		if sess.Client != nil {
+			sess.Client.Disconnect()
+			sess.SetState(StateDisconnected, "shutdown")
+			report.Disconnected++
+		}
+
+		sess.Mutex.Lock()
+		if sess.resumeTimer != nil {
+			sess.resumeTimer.Stop()
+			sess.resumeTimer = nil
+		}
+		sess.Mutex.Unlock()
+
+		report.PendingMessages += sess.PendingMessageCount()
+
+		if sess.webhook != nil {
+			if left := sess.webhook.close(ctx); len(left) > 0 {
+				report.UndeliveredEvents += len(left)
+				// ctx may have expired by now; saving must still happen.
+				if err := m.gatewayStore().SaveWebhookEvents(context.WithoutCancel(ctx), sess.ID, left); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s: save %d undelivered webhook events: %v", sess.ID, len(left), err))
+				}
+			}
+		}
+
+		if sess.Container != nil {
+			if err := sess.Container.Close(); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: close store: %v", sess.ID, err))
+			} else {
+				report.ClosedStores++
+			}
+		}
+	}
+
+	if shared := m.sharedStore(); shared != nil {
+		if err := shared.Close(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("close shared store: %v", err))
+		} else {
+			report.ClosedStores++
+		}
+	}
+
+	if st := m.gatewayStore(); st != nil {
+		if err := st.Close(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("close gateway store: %v", err))
+		} else {
+			report.ClosedStores++
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,143 @@
+package session
+
+import "time"
+
+type ConnState string
+
+const (
+	StateCreated      ConnState = "created"
+	StateConnecting   ConnState = "connecting"
+	StateConnected    ConnState = "connected"
+	StateDisconnected ConnState = "disconnected"
+	StateLoggedOut    ConnState = "logged_out"
+	// StateSuspended means automatic reconnection has stopped, e.g. after the
+	// stream was replaced or a temporary ban. See Manager.Resume.
+	StateSuspended ConnState = "suspended"
+)
+
+const maxStateHistory = 50
+
+type StateTransition struct {
+	From   ConnState `json:"from"`
+	To     ConnState `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type Diagnostics struct {
+	ID                   string            `json:"id"`
+	State                ConnState         `json:"state"`
+	LoggedIn             bool              `json:"logged_in"`
+	JID                  string            `json:"jid"`
+	History              []StateTransition `json:"history"`
+	SuspendedReason      string            `json:"suspended_reason,omitempty"`
+	SuspendedUntil       *time.Time        `json:"suspended_until,omitempty"`
+	LastConnectedAt      *time.Time        `json:"last_connected_at,omitempty"`
+	LastDisconnectedAt   *time.Time        `json:"last_disconnected_at,omitempty"`
+	LastDisconnectReason string            `json:"last_disconnect_reason,omitempty"`
+	ReconnectAttempts    int               `json:"reconnect_attempts"`
+	LastConnectError     string            `json:"last_connect_error,omitempty"`
+	LastConnectErrorAt   *time.Time        `json:"last_connect_error_at,omitempty"`
+	KeepAliveTimeouts    int               `json:"keepalive_timeouts"`
+	LastKeepAliveTimeout *time.Time        `json:"last_keepalive_timeout,omitempty"`
+	StoreDBSize          int64             `json:"store_db_size"`
+	PendingMessages      int               `json:"pending_messages"`
+}
+
+// setStateLocked records a state transition. The caller must hold s.Mutex.
+func (s *Session) setStateLocked(to ConnState, reason string) {
+	now := time.Now()
+	from := s.State
+	if from == to && reason == "" {
+		return
+	}
+
+	s.State = to
+	s.Connected = to == StateConnected
+	switch to {
+	case StateConnected:
+		s.LastConnectedAt = now
+		s.ReconnectAttempts = 0
+	case StateDisconnected, StateLoggedOut, StateSuspended:
+		if from == StateConnected {
+			s.LastDisconnectedAt = now
+			s.LastDisconnectReason = reason
+		}
+	}
+
+	s.History = append(s.History, StateTransition{From: from, To: to, Reason: reason, At: now})
+	if len(s.History) > maxStateHistory {
+		s.History = s.History[len(s.History)-maxStateHistory:]
+	}
+
+	if s.Log != nil {
+		s.Log.Info("state changed", "from", from, "to", to, "reason", reason)
+	}
+	s.emit("state", StateTransition{From: from, To: to, Reason: reason, At: now})
+}
+
+func (s *Session) SetState(to ConnState, reason string) {
+	s.Mutex.Lock()
+	s.setStateLocked(to, reason)
+	s.Mutex.Unlock()
+}
+
+func (s *Session) GetState() ConnState {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return s.State
+}
+
+func (s *Session) RecordConnectError(err error) {
+	s.Mutex.Lock()
+	s.LastConnectError = err.Error()
+	s.LastConnectErrorAt = time.Now()
+	s.Mutex.Unlock()
+}
+
+func (s *Session) RecordReconnectAttempt() int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.ReconnectAttempts++
+	return s.ReconnectAttempts
+}
+
+func (s *Session) RecordKeepAliveTimeout() {
+	s.Mutex.Lock()
+	s.KeepAliveTimeouts++
+	s.LastKeepAliveTimeout = time.Now()
+	s.Mutex.Unlock()
+}
+
+func (s *Session) Diagnostics() Diagnostics {
+	s.Mutex.RLock()
+	d := Diagnostics{
+		ID:                   s.ID,
+		State:                s.State,
+		LoggedIn:             s.LoggedIn,
+		JID:                  s.JID,
+		History:              append([]StateTransition(nil), s.History...),
+		SuspendedReason:      s.SuspendedReason,
+		SuspendedUntil:       timePtr(s.SuspendedUntil),
+		LastDisconnectReason: s.LastDisconnectReason,
+		ReconnectAttempts:    s.ReconnectAttempts,
+		LastConnectError:     s.LastConnectError,
+		KeepAliveTimeouts:    s.KeepAliveTimeouts,
+		LastConnectedAt:      timePtr(s.LastConnectedAt),
+		LastDisconnectedAt:   timePtr(s.LastDisconnectedAt),
+		LastConnectErrorAt:   timePtr(s.LastConnectErrorAt),
+		LastKeepAliveTimeout: timePtr(s.LastKeepAliveTimeout),
+	}
+	s.Mutex.RUnlock()
+
+	d.StoreDBSize = StoreDBSize(s.ID)
+	d.PendingMessages = s.PendingMessageCount()
+	return d
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -1,17 +1,66 @@
 package session
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"wa-mvp-api/internal/whatsapp"
 )
 
 const storeRoot = "store"
 const sessionPrefix = "session_"
 const tokenFileName = "token.txt"
+const pendingMessagesFileName = "pending_messages.json"
+
+// Everything under storeRoot holds keys or personal data and is only
+// accessible to the gateway's user.
+const (
+	storeDirMode  = 0o700
+	storeFileMode = 0o600
+)
 
 func EnsureStoreRoot() error {
-	return os.MkdirAll(storeRoot, 0o755)
+	return os.MkdirAll(storeRoot, storeDirMode)
+}
+
+func EnsureSessionDir(id string) error {
+	return os.MkdirAll(SessionDir(id), storeDirMode)
+}
+
+// TightenStorePermissions restricts the modes of everything under storeRoot,
+// including files created by older versions or by SQLite with the default
+// umask.
+func TightenStorePermissions() error {
+	return filepath.WalkDir(storeRoot, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			return nil
+		}
+		mode := os.FileMode(storeFileMode)
+		if d.IsDir() {
+			mode = storeDirMode
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().Perm() != mode {
+			return os.Chmod(path, mode)
+		}
+		return nil
+	})
 }
 
 func SessionDir(id string) string {
@@ -22,6 +71,35 @@ func TokenPath(id string) string {
 	return filepath.Join(SessionDir(id), tokenFileName)
 }
 
+// StoreDBSize returns the on-disk size of a session's device store, including
+// SQLite journal files. Missing files count as zero.
+func StoreDBSize(id string) int64 {
+	base := filepath.Join(SessionDir(id), whatsapp.DBFileName)
+	var total int64
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if info, err := os.Stat(base + suffix); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// CheckStoreWritable verifies that new files can be created under the store root.
+func CheckStoreWritable() error {
+	if err := EnsureStoreRoot(); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(storeRoot, ".writecheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, werr := f.Write([]byte("ok"))
+	cerr := f.Close()
+	rerr := os.Remove(name)
+	return errors.Join(werr, cerr, rerr)
+}
+
 func ListSessionIDs() ([]string, error) {
 	if err := EnsureStoreRoot(); err != nil {
 		return nil, err
@@ -53,6 +131,83 @@ func ReadToken(id string) (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-func WriteToken(id string, token string) error {
-	return os.WriteFile(TokenPath(id), []byte(token+"\n"), 0o600)
+// ReadPendingMessages loads messages queued on disk by older versions of the
+// gateway.
+func ReadPendingMessages(id string) ([]IncomingMessage, error) {
+	path := filepath.Join(SessionDir(id), pendingMessagesFileName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var msgs []IncomingMessage
+	if err := json.Unmarshal(data, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// importLegacySessions moves sessions from the file layout (token.txt and
+// pending_messages.json per session directory) into the gateway store. Files
+// are removed once their content has been imported.
+func importLegacySessions(ctx context.Context, st Store, logger func(id string) *slog.Logger) error {
+	ids, err := ListSessionIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		log := logger(id)
+		if _, err := st.GetSession(ctx, id); err == nil {
+			continue
+		} else if !errors.Is(err, ErrStoreNotFound) {
+			return err
+		}
+
+		token, err := ReadToken(id)
+		if err != nil || token == "" {
+			// Without a token nobody can use the session, but keep it so the
+			// device is not lost; a new token can be issued later.
+			token, err = newToken()
+			if err != nil {
+				return err
+			}
+			log.Warn("legacy session has no token, generated an unknown one")
+		}
+
+		rec := SessionRecord{ID: id, TokenHash: HashToken(token), CreatedAt: time.Now()}
+		if info, err := os.Stat(SessionDir(id)); err == nil {
+			rec.CreatedAt = info.ModTime()
+		}
+		if err := st.PutSession(ctx, rec); err != nil {
+			return err
+		}
+
+		pending, err := ReadPendingMessages(id)
+		if err != nil {
+			log.Warn("failed to read legacy pending messages", "error", err)
+		}
+		for i, msg := range pending {
+			err := st.AddMessage(ctx, Message{
+				SessionID:  id,
+				ID:         fmt.Sprintf("legacy-%d-%d", msg.Timestamp, i),
+				Chat:       msg.From + "@s.whatsapp.net",
+				Sender:     msg.From + "@s.whatsapp.net",
+				SenderName: msg.Name,
+				Type:       MessageText,
+				Text:       msg.Message,
+				Timestamp:  msg.Timestamp,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		_ = os.Remove(TokenPath(id))
+		_ = os.Remove(filepath.Join(SessionDir(id), pendingMessagesFileName))
+		log.Info("imported legacy session into gateway store", "pending_messages", len(pending))
+	}
+	return nil
 }
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+var ErrStoreNotFound = errors.New("not found")
+
+// Store persists gateway-owned state: the session registry, token hashes and
+// messages. Device data (keys, sessions with contacts) stays in the whatsmeow
+// device store.
+type Store interface {
+	PutSession(ctx context.Context, rec SessionRecord) error
+	GetSession(ctx context.Context, id string) (SessionRecord, error)
+	ListSessions(ctx context.Context) ([]SessionRecord, error)
+	DeleteSession(ctx context.Context, id string) error
+	SetTokenHash(ctx context.Context, id string, tokenHash string) error
+	SetSettings(ctx context.Context, id string, settings Settings) error
+	// SetExported records when the session was exported to another instance;
+	// a zero time clears the mark.
+	SetExported(ctx context.Context, id string, at time.Time) error
+
+	// AddMessage stores a message and updates its chat. Inbound messages
+	// are queued for PopPendingMessages and counted as unread; storing a
+	// message twice is a no-op.
+	AddMessage(ctx context.Context, msg Message) error
+	PopPendingMessages(ctx context.Context, sessionID string, limit int) ([]Message, error)
+	CountPendingMessages(ctx context.Context, sessionID string) (int, error)
+	// ReceiveMessages acknowledges inbound messages up to and including seq
+	// after and returns the queued messages following it. With after zero it
+	// returns all queued messages, acknowledging nothing.
+	ReceiveMessages(ctx context.Context, sessionID string, after int64, limit int) ([]Message, error)
+	// AllMessages returns every stored message of a session with its Pending
+	// flag.
+	AllMessages(ctx context.Context, sessionID string) ([]Message, error)
+	// AddHistoryMessages stores messages synced from the phone's history
+	// without queueing them and updates their chats' last message, returning
+	// how many were new.
+	AddHistoryMessages(ctx context.Context, msgs []Message) (int, error)
+	// ListMessages returns the messages matching filter, newest first.
+	ListMessages(ctx context.Context, sessionID string, filter MessageFilter) ([]Message, error)
+
+	// ListChats returns the chats matching filter, most recent first.
+	ListChats(ctx context.Context, sessionID string, filter ChatFilter) ([]Chat, error)
+	// UpdateChat applies update to a chat, creating it if needed.
+	UpdateChat(ctx context.Context, sessionID string, jid string, update ChatUpdate) error
+	// AllChats returns a session's chats as they are.
+	AllChats(ctx context.Context, sessionID string) ([]Chat, error)
+	// ImportSession stores a new session together with its messages, as
+	// AllMessages returns them, and its chats, in a single transaction.
+	ImportSession(ctx context.Context, rec SessionRecord, msgs []Message, chats []Chat) error
+
+	// SaveWebhookEvents keeps events that were not delivered before shutdown.
+	// TakeWebhookEvents returns a session's saved events, oldest first, and
+	// removes them.
+	SaveWebhookEvents(ctx context.Context, sessionID string, events []WebhookEvent) error
+	TakeWebhookEvents(ctx context.Context, sessionID string) ([]WebhookEvent, error)
+
+	CreateLink(ctx context.Context, link Link) error
+	// GetLink and ListLinks return links together with their opens.
+	GetLink(ctx context.Context, id string) (Link, error)
+	ListLinks(ctx context.Context, sessionID string) ([]Link, error)
+	AddLinkOpen(ctx context.Context, linkID string, open LinkOpen) error
+	// ConsumeLinks marks the session's unused links as used.
+	ConsumeLinks(ctx context.Context, sessionID string, at time.Time) (int, error)
+
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type SessionRecord struct {
+	ID        string
+	TokenHash string
+	CreatedAt time.Time
+	Name      string
+	Labels    []string
+	Settings  Settings
+	// ExportedAt is set once the session has been exported; such sessions
+	// are restored suspended so they do not fight the importing instance.
+	ExportedAt time.Time
+}
+
+// Message types. Media is stored without its content: Text holds the
+// caption, a location's name or a reaction's emoji.
+const (
+	MessageText     = "text"
+	MessageImage    = "image"
+	MessageVideo    = "video"
+	MessageAudio    = "audio"
+	MessageDocument = "document"
+	MessageSticker  = "sticker"
+	MessageLocation = "location"
+	MessageReaction = "reaction"
+)
+
+// MessageTypes lists the values of Message.Type.
+var MessageTypes = []string{MessageText, MessageImage, MessageVideo, MessageAudio, MessageDocument, MessageSticker, MessageLocation, MessageReaction}
+
+// Message is a persisted inbound or outbound message.
+type Message struct {
+	Seq        int64  `json:"seq"`
+	SessionID  string `json:"-"`
+	ID         string `json:"id"`
+	Chat       string `json:"chat"`
+	Sender     string `json:"sender"`
+	SenderName string `json:"sender_name"`
+	FromMe     bool   `json:"from_me"`
+	Type       string `json:"type"`
+	Text       string `json:"text"`
+	Timestamp  int64  `json:"timestamp"`
+	Pending    bool   `json:"-"`
+
+	// encrypted is set while Text and SenderName hold ciphertext.
+	encrypted bool
+}
+
+// MessageFilter selects messages for ListMessages; zero fields match
+// everything.
+type MessageFilter struct {
+	Chat   string
+	Sender string
+	Types  []string
+	FromMe *bool
+	// Since and Until bound the message timestamp, inclusively.
+	Since time.Time
+	Until time.Time
+	// Text matches a case-insensitive substring of the body. Search is a
+	// full-text query: every word must appear, and a word ending in * matches
+	// as a prefix.
+	Text   string
+	Search string
+	// After continues a listing after the message at that position.
+	After *MessagePosition
+	Limit int
+}
+
+// MessagePosition is a message's place in a listing, which is ordered by
+// timestamp and then by sequence number.
+type MessagePosition struct {
+	Timestamp int64
+	Seq       int64
+}
+
+func (msg Message) Position() MessagePosition {
+	return MessagePosition{Timestamp: msg.Timestamp, Seq: msg.Seq}
+}
+
+func (msg Message) Incoming() IncomingMessage {
+	from := msg.Sender
+	if jid, err := types.ParseJID(msg.Sender); err == nil {
+		from = jid.User
+	}
+	return IncomingMessage{
+		From:      from,
+		Name:      msg.SenderName,
+		Message:   msg.Text,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// HashToken returns the form in which bearer tokens are stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
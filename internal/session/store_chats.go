@@ -0,0 +1,225 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"wa-mvp-api/internal/crypt"
+)
+
+const chatColumns = `jid, name, last_message_id, last_message_at, last_from_me, last_text, unread_count, marked_unread, muted_until, archived, pinned, name_encrypted, last_text_encrypted`
+
+// addChatMessage makes msg, already encrypted, its chat's last message unless
+// the chat has a later one. For live messages, inbound ones count as unread
+// and one sent from this account means the chat was read; history leaves the
+// unread count alone.
+func addChatMessage(ctx context.Context, tx *sql.Tx, msg Message, live bool) error {
+	unread := 0
+	if live && !msg.FromMe {
+		unread = 1
+	}
+	const newer = `excluded.last_message_at >= gateway_chats.last_message_at`
+	_, err := tx.ExecContext(ctx, `INSERT INTO gateway_chats
+		(session_id, jid, last_message_id, last_message_at, last_from_me, last_text, last_text_encrypted, unread_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (session_id, jid) DO UPDATE SET
+			last_message_id = CASE WHEN `+newer+` THEN excluded.last_message_id ELSE gateway_chats.last_message_id END,
+			last_from_me = CASE WHEN `+newer+` THEN excluded.last_from_me ELSE gateway_chats.last_from_me END,
+			last_text = CASE WHEN `+newer+` THEN excluded.last_text ELSE gateway_chats.last_text END,
+			last_text_encrypted = CASE WHEN `+newer+` THEN excluded.last_text_encrypted ELSE gateway_chats.last_text_encrypted END,
+			unread_count = CASE WHEN $9 AND excluded.last_from_me AND `+newer+` THEN 0
+				ELSE gateway_chats.unread_count + excluded.unread_count END,
+			marked_unread = CASE WHEN $9 AND excluded.last_from_me AND `+newer+` THEN false ELSE gateway_chats.marked_unread END,
+			last_message_at = CASE WHEN `+newer+` THEN excluded.last_message_at ELSE gateway_chats.last_message_at END`,
+		msg.SessionID, msg.Chat, msg.ID, msg.Timestamp, msg.FromMe, msg.Text, msg.encrypted, unread, live)
+	return err
+}
+
+func (st *SQLStore) AddHistoryMessages(ctx context.Context, msgs []Message) (int, error) {
+	encrypted := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		if err := st.encryptMessage(ctx, &msg); err != nil {
+			return 0, err
+		}
+		encrypted[i] = msg
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stored := 0
+	for _, msg := range encrypted {
+		res, err := tx.ExecContext(ctx, `INSERT INTO gateway_messages
+			(session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending, encrypted)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, false, $10)
+			ON CONFLICT (session_id, chat_jid, message_id) DO NOTHING`,
+			msg.SessionID, msg.ID, msg.Chat, msg.Sender, msg.SenderName, msg.FromMe, msg.Type, msg.Text, msg.Timestamp, msg.encrypted)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := addChatMessage(ctx, tx, msg, false); err != nil {
+			return 0, err
+		}
+		stored++
+	}
+	return stored, tx.Commit()
+}
+
+func (st *SQLStore) ListChats(ctx context.Context, sessionID string, filter ChatFilter) ([]Chat, error) {
+	q := &whereQuery{}
+	q.add(`session_id=?`, sessionID)
+	if filter.Archived != nil {
+		q.add(`archived=?`, *filter.Archived)
+	}
+	if filter.Unread != nil {
+		if *filter.Unread {
+			q.add(`(unread_count>0 OR marked_unread)`)
+		} else {
+			q.add(`unread_count=0 AND NOT marked_unread`)
+		}
+	}
+	if after := filter.After; after != nil {
+		q.add(`(last_message_at<? OR (last_message_at=? AND jid<?))`, after.LastMessageAt, after.LastMessageAt, after.JID)
+	}
+	query, args := q.sql(chatColumns, "gateway_chats", `last_message_at DESC, jid DESC`, filter.Limit)
+	return st.queryChats(ctx, sessionID, query, args)
+}
+
+func (st *SQLStore) UpdateChat(ctx context.Context, sessionID string, jid string, update ChatUpdate) error {
+	cols := []string{"session_id", "jid"}
+	args := []any{sessionID, jid}
+	var sets []string
+	set := func(col string, value any) {
+		cols = append(cols, col)
+		args = append(args, value)
+		sets = append(sets, col+"=excluded."+col)
+	}
+
+	if update.Name != "" {
+		key, _, err := st.sessionKey(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		name, err := encryptValue(key, update.Name)
+		if err != nil {
+			return err
+		}
+		set("name", name)
+		set("name_encrypted", key != nil)
+	}
+	if update.Read != nil {
+		if *update.Read {
+			set("unread_count", 0)
+			set("marked_unread", false)
+		} else {
+			set("marked_unread", true)
+		}
+	}
+	if update.UnreadCount != nil && (update.Read == nil || !*update.Read) {
+		set("unread_count", *update.UnreadCount)
+	}
+	if update.MutedUntil != nil {
+		set("muted_until", *update.MutedUntil)
+	}
+	if update.Archived != nil {
+		set("archived", *update.Archived)
+	}
+	if update.Pinned != nil {
+		set("pinned", *update.Pinned)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	marks := make([]string, len(args))
+	for i := range marks {
+		marks[i] = fmt.Sprintf("$%d", i+1)
+	}
+	_, err := st.db.ExecContext(ctx, `INSERT INTO gateway_chats (`+strings.Join(cols, ", ")+`)
+		VALUES (`+strings.Join(marks, ", ")+`)
+		ON CONFLICT (session_id, jid) DO UPDATE SET `+strings.Join(sets, ", "), args...)
+	return err
+}
+
+func (st *SQLStore) AllChats(ctx context.Context, sessionID string) ([]Chat, error) {
+	return st.queryChats(ctx, sessionID, `SELECT `+chatColumns+` FROM gateway_chats WHERE session_id=$1 ORDER BY jid`, []any{sessionID})
+}
+
+// putChats stores chats as they are, encrypting them with key if set.
+func putChats(ctx context.Context, tx *sql.Tx, sessionID string, key []byte, chats []Chat) error {
+	for _, chat := range chats {
+		var err error
+		if chat.Name, err = encryptValue(key, chat.Name); err != nil {
+			return err
+		}
+		if chat.LastText, err = encryptValue(key, chat.LastText); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO gateway_chats (session_id, `+chatColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			ON CONFLICT (session_id, jid) DO NOTHING`,
+			sessionID, chat.JID, chat.Name, chat.LastMessageID, chat.LastMessageAt, chat.LastFromMe, chat.LastText,
+			chat.UnreadCount, chat.MarkedUnread, chat.MutedUntil, chat.Archived, chat.Pinned, key != nil, key != nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *SQLStore) queryChats(ctx context.Context, sessionID string, query string, args []any) ([]Chat, error) {
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Chat
+	for rows.Next() {
+		var chat Chat
+		err := rows.Scan(&chat.JID, &chat.Name, &chat.LastMessageID, &chat.LastMessageAt, &chat.LastFromMe, &chat.LastText,
+			&chat.UnreadCount, &chat.MarkedUnread, &chat.MutedUntil, &chat.Archived, &chat.Pinned, &chat.nameEncrypted, &chat.textEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return out, st.decryptChats(ctx, sessionID, out)
+}
+
+func (st *SQLStore) decryptChats(ctx context.Context, sessionID string, chats []Chat) error {
+	for i := range chats {
+		chat := &chats[i]
+		if !chat.nameEncrypted && !chat.textEncrypted {
+			continue
+		}
+		key, _, err := st.sessionKey(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if chat.nameEncrypted {
+			if chat.Name, err = crypt.DecryptString(key, chat.Name); err != nil {
+				return fmt.Errorf("chat %s: %w", chat.JID, err)
+			}
+		}
+		if chat.textEncrypted {
+			if chat.LastText, err = crypt.DecryptString(key, chat.LastText); err != nil {
+				return fmt.Errorf("chat %s: %w", chat.JID, err)
+			}
+		}
+		chat.nameEncrypted, chat.textEncrypted = false, false
+	}
+	return nil
+}
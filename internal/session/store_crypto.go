@@ -0,0 +1,363 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"wa-mvp-api/internal/crypt"
+)
+
+// Encryption at rest is optional. With a master key, every session gets a
+// random data key that is stored wrapped by the master key in
+// gateway_sessions.data_key. Message bodies, sender names and session
+// settings are encrypted with the session's data key; values written before
+// encryption was enabled stay readable and are encrypted by
+// RotateMasterKey. Which message and chat values are encrypted is recorded
+// in columns of their own, as plaintext can look like ciphertext. Tokens are
+// only ever stored as hashes.
+
+// UseMasterKey enables encryption of newly written data. It must be called
+// before the store is used.
+func (st *SQLStore) UseMasterKey(key *crypt.MasterKey) {
+	st.keyMu.Lock()
+	st.master = key
+	st.keys = make(map[string][]byte)
+	st.keyMu.Unlock()
+}
+
+// unwrapKey returns the data key for a wrapped value read from the database.
+// It returns nil for sessions without a data key and when no master key is
+// configured; encrypted values then fail to decrypt with crypt.ErrNoKey.
+func (st *SQLStore) unwrapKey(sessionID string, wrapped string) ([]byte, error) {
+	st.keyMu.Lock()
+	defer st.keyMu.Unlock()
+
+	if wrapped == "" || st.master == nil {
+		return nil, nil
+	}
+	if key, ok := st.keys[sessionID]; ok {
+		return key, nil
+	}
+	key, err := st.master.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	st.keys[sessionID] = key
+	return key, nil
+}
+
+// sessionKey returns the data key of a session, creating one when encryption
+// is enabled and the session has none yet. For a session that does not exist
+// yet the new key is returned together with its wrapped form, which the
+// caller must store. Without a master key it returns nil.
+func (st *SQLStore) sessionKey(ctx context.Context, sessionID string) ([]byte, string, error) {
+	var wrapped string
+	err := st.db.QueryRowContext(ctx, `SELECT data_key FROM gateway_sessions WHERE id=$1`, sessionID).Scan(&wrapped)
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", err
+	}
+	if wrapped != "" {
+		key, err := st.unwrapKey(sessionID, wrapped)
+		return key, wrapped, err
+	}
+
+	st.keyMu.Lock()
+	master := st.master
+	st.keyMu.Unlock()
+	if master == nil {
+		return nil, "", nil
+	}
+
+	key, err := crypt.NewDataKey()
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err = master.Wrap(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if exists {
+		res, err := st.db.ExecContext(ctx, `UPDATE gateway_sessions SET data_key=$1 WHERE id=$2 AND data_key=''`, wrapped, sessionID)
+		if err != nil {
+			return nil, "", err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// Another writer created a key first; use that one.
+			return st.sessionKey(ctx, sessionID)
+		}
+	}
+
+	st.keyMu.Lock()
+	st.keys[sessionID] = key
+	st.keyMu.Unlock()
+	return key, wrapped, nil
+}
+
+// encryptValue encrypts s with a session's data key, or returns it unchanged
+// when encryption is not enabled.
+func encryptValue(key []byte, s string) (string, error) {
+	if key == nil {
+		return s, nil
+	}
+	return crypt.EncryptString(key, s)
+}
+
+func (st *SQLStore) encryptMessage(ctx context.Context, msg *Message) error {
+	key, _, err := st.sessionKey(ctx, msg.SessionID)
+	if err != nil {
+		return err
+	}
+	return encryptMessageWith(key, msg)
+}
+
+// encryptMessageWith encrypts msg with key, or leaves it as it is when key is
+// nil.
+func encryptMessageWith(key []byte, msg *Message) error {
+	if key == nil {
+		return nil
+	}
+	var err error
+	if msg.Text, err = crypt.EncryptString(key, msg.Text); err != nil {
+		return err
+	}
+	if msg.SenderName, err = crypt.EncryptString(key, msg.SenderName); err != nil {
+		return err
+	}
+	msg.encrypted = true
+	return nil
+}
+
+// decryptMessages decrypts messages of one session read from the database.
+func (st *SQLStore) decryptMessages(ctx context.Context, msgs []Message) error {
+	for _, msg := range msgs {
+		if !msg.encrypted {
+			continue
+		}
+		key, _, err := st.sessionKey(ctx, msg.SessionID)
+		if err != nil {
+			return err
+		}
+		return decryptMessagesWith(key, msgs)
+	}
+	return nil
+}
+
+// decryptMessagesWith decrypts the encrypted ones among msgs with key.
+func decryptMessagesWith(key []byte, msgs []Message) error {
+	for i := range msgs {
+		msg := &msgs[i]
+		if !msg.encrypted {
+			continue
+		}
+		var err error
+		if msg.Text, err = crypt.DecryptString(key, msg.Text); err != nil {
+			return fmt.Errorf("message %s: %w", msg.ID, err)
+		}
+		if msg.SenderName, err = crypt.DecryptString(key, msg.SenderName); err != nil {
+			return fmt.Errorf("message %s: %w", msg.ID, err)
+		}
+		msg.encrypted = false
+	}
+	return nil
+}
+
+// RotationReport summarises RotateMasterKey.
+type RotationReport struct {
+	Sessions          int
+	CreatedKeys       int
+	RewrappedKeys     int
+	EncryptedMessages int
+	EncryptedSettings int
+	EncryptedChats    int
+}
+
+// RotateMasterKey re-wraps every session's data key with next, creating data
+// keys for sessions that have none, and encrypts values that were stored in
+// plaintext. The store's current master key must be the one the data keys
+// were wrapped with. Afterwards the store uses next.
+func (st *SQLStore) RotateMasterKey(ctx context.Context, next *crypt.MasterKey) (RotationReport, error) {
+	var report RotationReport
+	if next == nil {
+		return report, errors.New("new master key is required")
+	}
+
+	rows, err := st.db.QueryContext(ctx, `SELECT id, data_key FROM gateway_sessions ORDER BY id`)
+	if err != nil {
+		return report, err
+	}
+	type sessionKeyRow struct{ id, wrapped string }
+	var sessions []sessionKeyRow
+	for rows.Next() {
+		var row sessionKeyRow
+		if err := rows.Scan(&row.id, &row.wrapped); err != nil {
+			rows.Close()
+			return report, err
+		}
+		sessions = append(sessions, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	st.keyMu.Lock()
+	current := st.master
+	st.keyMu.Unlock()
+
+	for _, row := range sessions {
+		var key []byte
+		if row.wrapped == "" {
+			if key, err = crypt.NewDataKey(); err != nil {
+				return report, err
+			}
+			report.CreatedKeys++
+		} else {
+			if current == nil {
+				return report, fmt.Errorf("session %s has a data key but no current master key is configured", row.id)
+			}
+			if key, err = current.Unwrap(row.wrapped); err != nil {
+				return report, fmt.Errorf("session %s: %w", row.id, err)
+			}
+			report.RewrappedKeys++
+		}
+
+		wrapped, err := next.Wrap(key)
+		if err != nil {
+			return report, err
+		}
+		if err := st.rotateSession(ctx, row.id, key, wrapped, &report); err != nil {
+			return report, fmt.Errorf("session %s: %w", row.id, err)
+		}
+		report.Sessions++
+	}
+
+	st.UseMasterKey(next)
+	return report, nil
+}
+
+// rotateSession stores the re-wrapped data key and encrypts any plaintext
+// values of one session in a single transaction, counting them in report.
+func (st *SQLStore) rotateSession(ctx context.Context, id string, key []byte, wrapped string, report *RotationReport) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE gateway_sessions SET data_key=$1 WHERE id=$2`, wrapped, id); err != nil {
+		return err
+	}
+
+	encryptedSettings := 0
+	var settings string
+	if err := tx.QueryRowContext(ctx, `SELECT settings FROM gateway_sessions WHERE id=$1`, id).Scan(&settings); err != nil {
+		return err
+	}
+	// Settings are JSON, which cannot start with the ciphertext prefix.
+	if !crypt.IsEncrypted(settings) {
+		enc, err := crypt.EncryptString(key, settings)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE gateway_sessions SET settings=$1 WHERE id=$2`, enc, id); err != nil {
+			return err
+		}
+		encryptedSettings = 1
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, body, sender_name FROM gateway_messages
+		WHERE session_id=$1 AND NOT encrypted AND (body<>'' OR sender_name<>'')`, id)
+	if err != nil {
+		return err
+	}
+	type plainRow struct {
+		seq        int64
+		body, name string
+	}
+	var plain []plainRow
+	for rows.Next() {
+		var row plainRow
+		if err := rows.Scan(&row.seq, &row.body, &row.name); err != nil {
+			rows.Close()
+			return err
+		}
+		plain = append(plain, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	encryptedMessages := 0
+	for _, row := range plain {
+		body, err := crypt.EncryptString(key, row.body)
+		if err != nil {
+			return err
+		}
+		name, err := crypt.EncryptString(key, row.name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE gateway_messages SET body=$1, sender_name=$2, encrypted=true WHERE id=$3`, body, name, row.seq)
+		if err != nil {
+			return err
+		}
+		encryptedMessages++
+	}
+
+	encryptedChats, err := rotateChats(ctx, tx, id, key)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	report.EncryptedMessages += encryptedMessages
+	report.EncryptedSettings += encryptedSettings
+	report.EncryptedChats += encryptedChats
+	return nil
+}
+
+// rotateChats encrypts the plaintext names and last message texts of a
+// session's chats.
+func rotateChats(ctx context.Context, tx *sql.Tx, id string, key []byte) (int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT jid, name, name_encrypted, last_text, last_text_encrypted FROM gateway_chats
+		WHERE session_id=$1 AND (NOT name_encrypted OR NOT last_text_encrypted)`, id)
+	if err != nil {
+		return 0, err
+	}
+	var plain []Chat
+	for rows.Next() {
+		var chat Chat
+		if err := rows.Scan(&chat.JID, &chat.Name, &chat.nameEncrypted, &chat.LastText, &chat.textEncrypted); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain = append(plain, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, chat := range plain {
+		if !chat.nameEncrypted {
+			if chat.Name, err = crypt.EncryptString(key, chat.Name); err != nil {
+				return 0, err
+			}
+		}
+		if !chat.textEncrypted {
+			if chat.LastText, err = crypt.EncryptString(key, chat.LastText); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE gateway_chats SET name=$1, last_text=$2, name_encrypted=true, last_text_encrypted=true
+			WHERE session_id=$3 AND jid=$4`, chat.Name, chat.LastText, id, chat.JID); err != nil {
+			return 0, err
+		}
+	}
+	return len(plain), nil
+}
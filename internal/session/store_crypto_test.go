@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"wa-mvp-api/internal/crypt"
+)
+
+func testMasterKey(t *testing.T) *crypt.MasterKey {
+	t.Helper()
+	key, err := crypt.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypt.NewMasterKey(key)
+}
+
+// lookalike is plaintext that starts like ciphertext.
+const lookalike = "enc1:hello"
+
+func addLookalike(t *testing.T, st *SQLStore, sessionID string, id string) {
+	t.Helper()
+	msg := Message{
+		SessionID:  sessionID,
+		ID:         id,
+		Chat:       "123@s.whatsapp.net",
+		Sender:     "123@s.whatsapp.net",
+		SenderName: lookalike,
+		Type:       "text",
+		Text:       lookalike,
+		Timestamp:  1700000000,
+	}
+	if err := st.AddMessage(context.Background(), msg); err != nil {
+		t.Fatalf("add message: %v", err)
+	}
+}
+
+// checkLookalike reads the session's messages and chats back every way the
+// gateway does and checks the plaintext came through unchanged.
+func checkLookalike(t *testing.T, st *SQLStore, sessionID string) {
+	t.Helper()
+	ctx := context.Background()
+
+	popped, err := st.PopPendingMessages(ctx, sessionID, 0)
+	if err != nil {
+		t.Fatalf("pop pending: %v", err)
+	}
+	all, err := st.AllMessages(ctx, sessionID)
+	if err != nil {
+		t.Fatalf("all messages: %v", err)
+	}
+	listed, err := st.ListMessages(ctx, sessionID, MessageFilter{})
+	if err != nil {
+		t.Fatalf("list messages: %v", err)
+	}
+	found, err := st.ListMessages(ctx, sessionID, MessageFilter{Search: "hello"})
+	if err != nil {
+		t.Fatalf("search messages: %v", err)
+	}
+	for name, msgs := range map[string][]Message{"popped": popped, "all": all, "listed": listed, "found": found} {
+		if len(msgs) != 1 || msgs[0].Text != lookalike || msgs[0].SenderName != lookalike {
+			t.Errorf("%s messages = %+v, want one with text and sender name %q", name, msgs, lookalike)
+		}
+	}
+
+	chats, err := st.ListChats(ctx, sessionID, ChatFilter{})
+	if err != nil {
+		t.Fatalf("list chats: %v", err)
+	}
+	if len(chats) != 1 || chats[0].LastText != lookalike {
+		t.Errorf("chats = %+v, want one with last text %q", chats, lookalike)
+	}
+}
+
+func TestStoreLookalikePlaintext(t *testing.T) {
+	cases := map[string]func(t *testing.T, st *SQLStore){
+		"without key": func(t *testing.T, st *SQLStore) {
+			putTestSession(t, st, "s1")
+			addLookalike(t, st, "s1", "m1")
+		},
+		"with key": func(t *testing.T, st *SQLStore) {
+			st.UseMasterKey(testMasterKey(t))
+			putTestSession(t, st, "s1")
+			addLookalike(t, st, "s1", "m1")
+
+			var body string
+			var encrypted bool
+			err := st.db.QueryRow(`SELECT body, encrypted FROM gateway_messages WHERE session_id=$1`, "s1").Scan(&body, &encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !encrypted || body == lookalike {
+				t.Errorf("stored body %q (encrypted %v), want ciphertext", body, encrypted)
+			}
+		},
+		"key added later": func(t *testing.T, st *SQLStore) {
+			putTestSession(t, st, "s1")
+			addLookalike(t, st, "s1", "m1")
+			st.UseMasterKey(testMasterKey(t))
+		},
+		"rotated": func(t *testing.T, st *SQLStore) {
+			putTestSession(t, st, "s1")
+			addLookalike(t, st, "s1", "m1")
+			report, err := st.RotateMasterKey(context.Background(), testMasterKey(t))
+			if err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if report.EncryptedMessages != 1 || report.EncryptedChats != 1 {
+				t.Errorf("report = %+v, want one message and one chat encrypted", report)
+			}
+		},
+	}
+	for name, setup := range cases {
+		t.Run(name, func(t *testing.T) {
+			forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+				st := open()
+				setup(t, st)
+				checkLookalike(t, st, "s1")
+			})
+		})
+	}
+}
+
+// A message that cannot be decrypted stays pending rather than being lost.
+func TestStorePopPendingKeepsUndecryptable(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		st.UseMasterKey(testMasterKey(t))
+		putTestSession(t, st, "s1")
+		addLookalike(t, st, "s1", "m1")
+
+		st.UseMasterKey(nil)
+		if _, err := st.PopPendingMessages(ctx, "s1", 0); !errors.Is(err, crypt.ErrNoKey) {
+			t.Fatalf("pop pending error = %v, want %v", err, crypt.ErrNoKey)
+		}
+		n, err := st.CountPendingMessages(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("pending messages = %d, want 1", n)
+		}
+	})
+}
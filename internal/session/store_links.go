@@ -0,0 +1,116 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const linkColumns = `id, session_id, created_at, expires_at, consumed_at`
+
+func (st *SQLStore) CreateLink(ctx context.Context, link Link) error {
+	_, err := st.db.ExecContext(ctx, `INSERT INTO gateway_links (`+linkColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		link.ID, link.SessionID, link.CreatedAt.Unix(), link.ExpiresAt.Unix(), unixOrZero(link.ConsumedAt))
+	return err
+}
+
+func (st *SQLStore) GetLink(ctx context.Context, id string) (Link, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+linkColumns+` FROM gateway_links WHERE id=$1`, id)
+	if err != nil {
+		return Link{}, err
+	}
+	links, err := scanLinks(rows)
+	if err != nil {
+		return Link{}, err
+	}
+	if len(links) == 0 {
+		return Link{}, ErrStoreNotFound
+	}
+	if err := st.loadLinkOpens(ctx, links); err != nil {
+		return Link{}, err
+	}
+	return links[0], nil
+}
+
+func (st *SQLStore) ListLinks(ctx context.Context, sessionID string) ([]Link, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+linkColumns+` FROM gateway_links WHERE session_id=$1 ORDER BY created_at, id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	links, err := scanLinks(rows)
+	if err != nil {
+		return nil, err
+	}
+	return links, st.loadLinkOpens(ctx, links)
+}
+
+func (st *SQLStore) AddLinkOpen(ctx context.Context, linkID string, open LinkOpen) error {
+	_, err := st.db.ExecContext(ctx, `INSERT INTO gateway_link_opens (link_id, opened_at, remote_addr, forwarded_for, user_agent)
+		VALUES ($1, $2, $3, $4, $5)`, linkID, open.At.Unix(), open.RemoteAddr, open.ForwardedFor, open.UserAgent)
+	return err
+}
+
+func (st *SQLStore) ConsumeLinks(ctx context.Context, sessionID string, at time.Time) (int, error) {
+	res, err := st.db.ExecContext(ctx, `UPDATE gateway_links SET consumed_at=$1 WHERE session_id=$2 AND consumed_at=0`, at.Unix(), sessionID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (st *SQLStore) loadLinkOpens(ctx context.Context, links []Link) error {
+	for i := range links {
+		rows, err := st.db.QueryContext(ctx, `SELECT opened_at, remote_addr, forwarded_for, user_agent
+			FROM gateway_link_opens WHERE link_id=$1 ORDER BY id`, links[i].ID)
+		if err != nil {
+			return err
+		}
+		links[i].Opens = []LinkOpen{}
+		for rows.Next() {
+			var open LinkOpen
+			var at int64
+			if err := rows.Scan(&at, &open.RemoteAddr, &open.ForwardedFor, &open.UserAgent); err != nil {
+				rows.Close()
+				return err
+			}
+			open.At = time.Unix(at, 0).UTC()
+			links[i].Opens = append(links[i].Opens, open)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanLinks(rows *sql.Rows) ([]Link, error) {
+	defer rows.Close()
+	var links []Link
+	for rows.Next() {
+		var link Link
+		var created, expires, consumed int64
+		if err := rows.Scan(&link.ID, &link.SessionID, &created, &expires, &consumed); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = time.Unix(created, 0).UTC()
+		link.ExpiresAt = time.Unix(expires, 0).UTC()
+		if consumed != 0 {
+			link.ConsumedAt = time.Unix(consumed, 0).UTC()
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return links, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,213 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// storeMigration upgrades the gateway schema by one version. Common statements
+// are written for PostgreSQL and rewritten for SQLite by sqliteStatement;
+// dialect-specific statements run after them.
+type storeMigration struct {
+	common   []string
+	postgres []string
+	sqlite   []string
+}
+
+func (m storeMigration) statements(dialect string) []string {
+	out := make([]string, 0, len(m.common)+len(m.postgres)+len(m.sqlite))
+	switch dialect {
+	case DialectSQLite:
+		for _, stmt := range m.common {
+			out = append(out, sqliteStatement(stmt))
+		}
+		out = append(out, m.sqlite...)
+	default:
+		out = append(out, m.common...)
+		out = append(out, m.postgres...)
+	}
+	return out
+}
+
+func sqliteStatement(stmt string) string {
+	return strings.ReplaceAll(stmt, "BIGSERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT")
+}
+
+// storeMigrations is append-only: the index + 1 is the schema version.
+var storeMigrations = []storeMigration{
+	{
+		common: []string{
+			`CREATE TABLE gateway_sessions (
+				id         TEXT PRIMARY KEY,
+				token_hash TEXT NOT NULL UNIQUE,
+				created_at BIGINT NOT NULL
+			)`,
+			`CREATE TABLE gateway_messages (
+				id          BIGSERIAL PRIMARY KEY,
+				session_id  TEXT NOT NULL REFERENCES gateway_sessions(id) ON DELETE CASCADE,
+				message_id  TEXT NOT NULL,
+				chat_jid    TEXT NOT NULL,
+				sender_jid  TEXT NOT NULL,
+				sender_name TEXT NOT NULL,
+				from_me     BOOLEAN NOT NULL,
+				type        TEXT NOT NULL,
+				body        TEXT NOT NULL,
+				timestamp   BIGINT NOT NULL,
+				pending     BOOLEAN NOT NULL,
+				UNIQUE (session_id, chat_jid, message_id)
+			)`,
+			`CREATE INDEX gateway_messages_pending_idx ON gateway_messages (session_id, pending, id)`,
+		},
+	},
+	{
+		common: []string{
+			`ALTER TABLE gateway_sessions ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE gateway_sessions ADD COLUMN labels TEXT NOT NULL DEFAULT '[]'`,
+			`ALTER TABLE gateway_sessions ADD COLUMN settings TEXT NOT NULL DEFAULT '{}'`,
+		},
+	},
+	{
+		common: []string{
+			`ALTER TABLE gateway_sessions ADD COLUMN exported_at BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		common: []string{
+			`ALTER TABLE gateway_sessions ADD COLUMN data_key TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		common: []string{
+			`CREATE TABLE gateway_links (
+				id          TEXT PRIMARY KEY,
+				session_id  TEXT NOT NULL REFERENCES gateway_sessions(id) ON DELETE CASCADE,
+				created_at  BIGINT NOT NULL,
+				expires_at  BIGINT NOT NULL,
+				consumed_at BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX gateway_links_session_idx ON gateway_links (session_id, created_at)`,
+			`CREATE TABLE gateway_link_opens (
+				id            BIGSERIAL PRIMARY KEY,
+				link_id       TEXT NOT NULL REFERENCES gateway_links(id) ON DELETE CASCADE,
+				opened_at     BIGINT NOT NULL,
+				remote_addr   TEXT NOT NULL,
+				forwarded_for TEXT NOT NULL,
+				user_agent    TEXT NOT NULL
+			)`,
+			`CREATE INDEX gateway_link_opens_link_idx ON gateway_link_opens (link_id, id)`,
+		},
+	},
+	{
+		// SQLite's full-text index is set up by setupSearch, since it depends
+		// on how the binary was built.
+		common: []string{
+			`CREATE INDEX gateway_messages_time_idx ON gateway_messages (session_id, timestamp, id)`,
+			`CREATE INDEX gateway_messages_chat_idx ON gateway_messages (session_id, chat_jid, timestamp, id)`,
+		},
+		postgres: []string{
+			`CREATE INDEX gateway_messages_body_fts_idx ON gateway_messages USING GIN (to_tsvector('simple', body))`,
+		},
+	},
+	{
+		// Existing chats start from their latest message, read and with no
+		// name; names are then filled in from the contact list.
+		common: []string{
+			`CREATE TABLE gateway_chats (
+				session_id      TEXT NOT NULL REFERENCES gateway_sessions(id) ON DELETE CASCADE,
+				jid             TEXT NOT NULL,
+				name            TEXT NOT NULL DEFAULT '',
+				last_message_id TEXT NOT NULL DEFAULT '',
+				last_message_at BIGINT NOT NULL DEFAULT 0,
+				last_from_me    BOOLEAN NOT NULL DEFAULT false,
+				last_text       TEXT NOT NULL DEFAULT '',
+				unread_count    INTEGER NOT NULL DEFAULT 0,
+				marked_unread   BOOLEAN NOT NULL DEFAULT false,
+				muted_until     BIGINT NOT NULL DEFAULT 0,
+				archived        BOOLEAN NOT NULL DEFAULT false,
+				pinned          BOOLEAN NOT NULL DEFAULT false,
+				PRIMARY KEY (session_id, jid)
+			)`,
+			`CREATE INDEX gateway_chats_recent_idx ON gateway_chats (session_id, last_message_at, jid)`,
+			`INSERT INTO gateway_chats (session_id, jid, last_message_id, last_message_at, last_from_me, last_text)
+				SELECT m.session_id, m.chat_jid, m.message_id, m.timestamp, m.from_me, m.body FROM gateway_messages m
+				WHERE m.id = (SELECT l.id FROM gateway_messages l WHERE l.session_id=m.session_id AND l.chat_jid=m.chat_jid
+					ORDER BY l.timestamp DESC, l.id DESC LIMIT 1)`,
+		},
+	},
+	{
+		// Ciphertext used to be told apart by its prefix, which plaintext can
+		// carry as well. Only sessions with a data key can hold ciphertext;
+		// the FTS triggers are dropped so that setupSearch recreates them on
+		// the new columns.
+		common: []string{
+			`ALTER TABLE gateway_messages ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT false`,
+			`ALTER TABLE gateway_chats ADD COLUMN name_encrypted BOOLEAN NOT NULL DEFAULT false`,
+			`ALTER TABLE gateway_chats ADD COLUMN last_text_encrypted BOOLEAN NOT NULL DEFAULT false`,
+			`UPDATE gateway_messages SET encrypted = (body LIKE 'enc1:%' OR sender_name LIKE 'enc1:%')
+				WHERE session_id IN (SELECT id FROM gateway_sessions WHERE data_key <> '')`,
+			`UPDATE gateway_chats SET name_encrypted = (name LIKE 'enc1:%'), last_text_encrypted = (last_text LIKE 'enc1:%')
+				WHERE session_id IN (SELECT id FROM gateway_sessions WHERE data_key <> '')`,
+		},
+		sqlite: []string{
+			`DROP TRIGGER IF EXISTS gateway_messages_fts_insert`,
+			`DROP TRIGGER IF EXISTS gateway_messages_fts_delete`,
+			`DROP TRIGGER IF EXISTS gateway_messages_fts_update_old`,
+			`DROP TRIGGER IF EXISTS gateway_messages_fts_update_new`,
+		},
+	},
+	{
+		common: []string{
+			`CREATE TABLE gateway_webhook_events (
+				id         BIGSERIAL PRIMARY KEY,
+				session_id TEXT NOT NULL REFERENCES gateway_sessions(id) ON DELETE CASCADE,
+				payload    TEXT NOT NULL,
+				encrypted  BOOLEAN NOT NULL
+			)`,
+			`CREATE INDEX gateway_webhook_events_session_idx ON gateway_webhook_events (session_id, id)`,
+		},
+	},
+}
+
+func (st *SQLStore) migrate(ctx context.Context) error {
+	if _, err := st.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS gateway_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	var version int
+	err := st.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM gateway_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(storeMigrations) {
+		return fmt.Errorf("store schema version %d is newer than this binary supports (%d)", version, len(storeMigrations))
+	}
+
+	for i := version; i < len(storeMigrations); i++ {
+		if err := st.applyMigration(ctx, i+1, storeMigrations[i]); err != nil {
+			return fmt.Errorf("migration to version %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (st *SQLStore) applyMigration(ctx context.Context, version int, m storeMigration) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(st.dialect) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_version`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO gateway_version (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
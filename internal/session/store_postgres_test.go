@@ -0,0 +1,74 @@
+//go:build postgres
+
+package session
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+// With the postgres tag the store tests always run against PostgreSQL as
+// well: on the server testPostgresEnv names or, when it is not set, on an
+// embedded one started for the test run. The embedded server's binaries are
+// downloaded on first use and cached under the user's cache directory.
+
+func TestMain(m *testing.M) {
+	os.Exit(runWithPostgres(m))
+}
+
+func runWithPostgres(m *testing.M) int {
+	if os.Getenv(testPostgresEnv) != "" {
+		return m.Run()
+	}
+
+	dir, err := os.MkdirTemp("", "gateway-postgres-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "embedded postgres:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "embedded postgres:", err)
+		return 1
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = dir
+	}
+
+	config := embeddedpostgres.DefaultConfig().
+		Port(port).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		CachePath(filepath.Join(cache, "gateway-embedded-postgres")).
+		Logger(io.Discard)
+	db := embeddedpostgres.NewDatabase(config)
+	if err := db.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "embedded postgres:", err)
+		return 1
+	}
+	defer func() {
+		if err := db.Stop(); err != nil {
+			fmt.Fprintln(os.Stderr, "embedded postgres:", err)
+		}
+	}()
+
+	os.Setenv(testPostgresEnv, config.GetConnectionURL()+"?sslmode=disable")
+	return m.Run()
+}
+
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
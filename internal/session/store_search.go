@@ -0,0 +1,319 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Message search uses FTS5 on SQLite when the binary was built with the
+// sqlite_fts5 tag and a text search expression on PostgreSQL. Neither can see
+// into encrypted bodies, so for sessions with a data key the text filters run
+// on decrypted messages instead, as search does on SQLite without FTS5; the
+// results are the same, only slower to find.
+
+// searchScanBatch is how many candidate rows are decrypted at a time when
+// text filters run outside the database.
+const searchScanBatch = 500
+
+const messageOrder = `timestamp DESC, id DESC`
+
+// ftsTriggers keep gateway_messages_fts in step with gateway_messages.
+// Encrypted bodies are left out of the index, on both sides of each change,
+// as an external content index must be told exactly what it holds.
+var ftsTriggers = []string{
+	`CREATE TRIGGER gateway_messages_fts_insert AFTER INSERT ON gateway_messages
+		WHEN NOT new.encrypted BEGIN
+		INSERT INTO gateway_messages_fts (rowid, body) VALUES (new.id, new.body);
+	END`,
+	`CREATE TRIGGER gateway_messages_fts_delete AFTER DELETE ON gateway_messages
+		WHEN NOT old.encrypted BEGIN
+		INSERT INTO gateway_messages_fts (gateway_messages_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END`,
+	`CREATE TRIGGER gateway_messages_fts_update_old AFTER UPDATE OF body, encrypted ON gateway_messages
+		WHEN NOT old.encrypted BEGIN
+		INSERT INTO gateway_messages_fts (gateway_messages_fts, rowid, body) VALUES ('delete', old.id, old.body);
+	END`,
+	`CREATE TRIGGER gateway_messages_fts_update_new AFTER UPDATE OF body, encrypted ON gateway_messages
+		WHEN NOT new.encrypted BEGIN
+		INSERT INTO gateway_messages_fts (rowid, body) VALUES (new.id, new.body);
+	END`,
+}
+
+// setupSearch creates the SQLite full-text index when FTS5 is available and
+// fills it if its triggers were missing. Without FTS5 the triggers are
+// dropped, so that writes keep working on a database indexed by an earlier
+// build; the index is rebuilt once a build with FTS5 opens it again.
+func (st *SQLStore) setupSearch(ctx context.Context) error {
+	if st.dialect != DialectSQLite {
+		return nil
+	}
+
+	var available bool
+	if err := st.db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return err
+	}
+	var triggers int
+	err := st.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master
+		WHERE type='trigger' AND name LIKE 'gateway_messages_fts_%'`).Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if !available {
+		for _, name := range []string{"insert", "delete", "update_old", "update_new"} {
+			if _, err := st.db.ExecContext(ctx, `DROP TRIGGER IF EXISTS gateway_messages_fts_`+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if triggers == len(ftsTriggers) {
+		st.fts = true
+		return nil
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{
+		`DROP TABLE IF EXISTS gateway_messages_fts`,
+		`CREATE VIRTUAL TABLE gateway_messages_fts USING fts5 (body, content='gateway_messages', content_rowid='id')`,
+	}
+	stmts = append(stmts, ftsTriggers...)
+	stmts = append(stmts, `INSERT INTO gateway_messages_fts (rowid, body)
+		SELECT id, body FROM gateway_messages WHERE NOT encrypted`)
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	st.fts = true
+	return nil
+}
+
+// whereQuery builds a SELECT from conditions; ? in a condition is replaced
+// by the next $N placeholder.
+type whereQuery struct {
+	where []string
+	args  []any
+}
+
+func (q *whereQuery) add(cond string, args ...any) {
+	for _, arg := range args {
+		q.args = append(q.args, arg)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(q.args)), 1)
+	}
+	q.where = append(q.where, cond)
+}
+
+func (q *whereQuery) sql(columns, table, order string, limit int) (string, []any) {
+	query := `SELECT ` + columns + ` FROM ` + table + ` WHERE ` + strings.Join(q.where, " AND ") + ` ORDER BY ` + order
+	args := q.args
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, limit)
+	}
+	return query, args
+}
+
+func (st *SQLStore) ListMessages(ctx context.Context, sessionID string, filter MessageFilter) ([]Message, error) {
+	key, _, err := st.sessionKey(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := key != nil
+
+	q := &whereQuery{}
+	q.add(`session_id=?`, sessionID)
+	if filter.Chat != "" {
+		q.add(`chat_jid=?`, filter.Chat)
+	}
+	if filter.Sender != "" {
+		q.add(`sender_jid=?`, filter.Sender)
+	}
+	if len(filter.Types) > 0 {
+		marks := make([]string, len(filter.Types))
+		args := make([]any, len(filter.Types))
+		for i, t := range filter.Types {
+			marks[i], args[i] = "?", t
+		}
+		q.add(`type IN (`+strings.Join(marks, ", ")+`)`, args...)
+	}
+	if filter.FromMe != nil {
+		q.add(`from_me=?`, *filter.FromMe)
+	}
+	if !filter.Since.IsZero() {
+		q.add(`timestamp>=?`, filter.Since.Unix())
+	}
+	if !filter.Until.IsZero() {
+		q.add(`timestamp<=?`, filter.Until.Unix())
+	}
+	// Text filters run in the database when it can see the bodies and, for
+	// searches, has a full-text index.
+	inDB := !encrypted && (filter.Search == "" || st.FullTextSearch())
+	if inDB {
+		st.addTextFilters(q, filter)
+	}
+
+	if inDB || (filter.Text == "" && filter.Search == "") {
+		if after := filter.After; after != nil {
+			q.add(`(timestamp<? OR (timestamp=? AND id<?))`, after.Timestamp, after.Timestamp, after.Seq)
+		}
+		query, args := q.sql(messageColumns, "gateway_messages", messageOrder, filter.Limit)
+		return st.queryMessages(ctx, query, args)
+	}
+	return st.filterMessages(ctx, q, filter)
+}
+
+// addTextFilters adds the text and search filters as SQL conditions.
+func (st *SQLStore) addTextFilters(q *whereQuery, filter MessageFilter) {
+	if filter.Text != "" {
+		op := "LIKE"
+		if st.dialect == DialectPostgres {
+			op = "ILIKE"
+		}
+		q.add(`body `+op+` ? ESCAPE '\'`, "%"+escapeLike(filter.Text)+"%")
+	}
+	terms := searchTerms(filter.Search)
+	switch {
+	case len(terms) == 0:
+	case st.dialect == DialectPostgres:
+		q.add(`to_tsvector('simple', body) @@ to_tsquery('simple', ?)`, postgresQuery(terms))
+	default:
+		q.add(`id IN (SELECT rowid FROM gateway_messages_fts WHERE gateway_messages_fts MATCH ?)`, ftsQuery(terms))
+	}
+}
+
+// FullTextSearch reports whether message search has a full-text index to
+// use. On SQLite that takes a binary built with the sqlite_fts5 tag; without
+// it every search scans the session's messages.
+func (st *SQLStore) FullTextSearch() bool {
+	return st.dialect == DialectPostgres || st.fts
+}
+
+// filterMessages pages through the messages matching the SQL conditions,
+// decrypting them and applying the text filters, until it has enough.
+func (st *SQLStore) filterMessages(ctx context.Context, q *whereQuery, filter MessageFilter) ([]Message, error) {
+	terms := searchTerms(filter.Search)
+	text := strings.ToLower(filter.Text)
+	after := filter.After
+	baseWhere, baseArgs := len(q.where), len(q.args)
+
+	var out []Message
+	for {
+		q.where = q.where[:baseWhere]
+		q.args = q.args[:baseArgs]
+		if after != nil {
+			q.add(`(timestamp<? OR (timestamp=? AND id<?))`, after.Timestamp, after.Timestamp, after.Seq)
+		}
+		query, args := q.sql(messageColumns, "gateway_messages", messageOrder, searchScanBatch)
+		batch, err := st.queryMessages(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range batch {
+			body := strings.ToLower(msg.Text)
+			if (text == "" || strings.Contains(body, text)) && matchTerms(body, terms) {
+				out = append(out, msg)
+				if filter.Limit > 0 && len(out) == filter.Limit {
+					return out, nil
+				}
+			}
+		}
+		if len(batch) < searchScanBatch {
+			return out, nil
+		}
+		pos := batch[len(batch)-1].Position()
+		after = &pos
+	}
+}
+
+func (st *SQLStore) queryMessages(ctx context.Context, query string, args []any) ([]Message, error) {
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, st.decryptMessages(ctx, msgs)
+}
+
+// searchTerms splits a search query into lower-case words the way the FTS5
+// tokenizer splits text, keeping a trailing * that asks for a prefix match.
+func searchTerms(search string) []string {
+	var terms []string
+	fields := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !isWordRune(r) && r != '*'
+	})
+	for _, field := range fields {
+		word := strings.ReplaceAll(field, "*", "")
+		if word == "" {
+			continue
+		}
+		if strings.HasSuffix(field, "*") {
+			word += "*"
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// matchTerms reports whether every term is one of text's words, or a prefix
+// of one for terms ending in *.
+func matchTerms(text string, terms []string) bool {
+	words := strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) })
+	for _, term := range terms {
+		matched := false
+		want, prefix := strings.CutSuffix(term, "*")
+		for _, word := range words {
+			if word == want || (prefix && strings.HasPrefix(word, want)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		word, prefix := strings.CutSuffix(term, "*")
+		quoted[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		if prefix {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func postgresQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		word, prefix := strings.CutSuffix(term, "*")
+		parts[i] = "'" + strings.ReplaceAll(word, "'", "''") + "'"
+		if prefix {
+			parts[i] += ":*"
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,13 @@
+//go:build sqlite_fts5
+
+package session
+
+import "testing"
+
+func TestStoreUsesFTS5(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		if st := open(); !st.FullTextSearch() {
+			t.Error("message search has no full-text index")
+		}
+	})
+}
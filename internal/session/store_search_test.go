@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"testing"
+)
+
+// TestStoreSearch checks which messages a search matches and the order they
+// come in, newest first with ties broken by arrival. On SQLite the full-text
+// index, when built in, must agree with the scan used without it.
+func TestStoreSearch(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		putTestSession(t, st, "s1")
+		addMessages(t, st,
+			testMessage("s1", "m1", testChatA, false, "Hello world", 100),
+			testMessage("s1", "m2", testChatB, true, "say hello to the World!", 300),
+			testMessage("s1", "m3", testChatA, false, "hell of a day", 200),
+			testMessage("s1", "m4", testChatB, false, "worldwide hellos", 300),
+			testMessage("s1", "m5", testChatA, false, "nothing to see", 400),
+		)
+
+		searches := map[string][]string{
+			"hello":       {"m2", "m1"},
+			"HELLO World": {"m2", "m1"},
+			"hell":        {"m3"},
+			"hell*":       {"m4", "m2", "m3", "m1"},
+			"world*":      {"m4", "m2", "m1"},
+			"hello day":   nil,
+			"missing":     nil,
+		}
+		scanned := &SQLStore{db: st.db, dialect: st.dialect, keys: make(map[string][]byte)}
+		for search, want := range searches {
+			for name, st := range map[string]*SQLStore{"index": st, "scan": scanned} {
+				msgs, err := st.ListMessages(ctx, "s1", MessageFilter{Search: search})
+				if err != nil {
+					t.Fatalf("%s %q: %v", name, search, err)
+				}
+				checkIDs(t, name+" "+search, msgs, want...)
+			}
+		}
+
+		// Searches combine with the other filters and page like listings.
+		var paged []Message
+		filter := MessageFilter{Chat: testChatB, Search: "hell*", Limit: 1}
+		for {
+			page, err := st.ListMessages(ctx, "s1", filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			paged = append(paged, page...)
+			pos := page[len(page)-1].Position()
+			filter.After = &pos
+		}
+		checkIDs(t, "paged search", paged, "m4", "m2")
+	})
+}
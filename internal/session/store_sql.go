@@ -0,0 +1,414 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"wa-mvp-api/internal/crypt"
+)
+
+const (
+	DialectSQLite   = "sqlite3"
+	DialectPostgres = "postgres"
+)
+
+// SQLStore implements Store on SQLite or PostgreSQL. Queries use $N
+// placeholders, which both drivers accept; the dialect only matters for
+// schema migrations. SQLite treats $N as a named parameter and numbers it by
+// first appearance, so placeholders must appear in argument order.
+type SQLStore struct {
+	db      *sql.DB
+	dialect string
+
+	keyMu  sync.Mutex
+	master *crypt.MasterKey
+	keys   map[string][]byte
+
+	// fts is set when SQLite has FTS5 and gateway_messages_fts is kept up
+	// to date.
+	fts bool
+}
+
+func OpenSQLStore(ctx context.Context, dialect string, address string) (*SQLStore, error) {
+	switch dialect {
+	case DialectSQLite, DialectPostgres:
+	default:
+		return nil, fmt.Errorf("unsupported store dialect %q", dialect)
+	}
+
+	db, err := sql.Open(dialect, address)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == DialectSQLite {
+		// SQLite allows a single writer; serialising through one connection
+		// avoids "database is locked" errors under concurrent sessions.
+		db.SetMaxOpenConns(1)
+	}
+
+	st := &SQLStore{db: db, dialect: dialect, keys: make(map[string][]byte)}
+	if err := st.migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate gateway store: %w", err)
+	}
+	if err := st.setupSearch(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to set up message search: %w", err)
+	}
+	return st, nil
+}
+
+func (st *SQLStore) Ping(ctx context.Context) error {
+	return st.db.PingContext(ctx)
+}
+
+func (st *SQLStore) Close() error {
+	return st.db.Close()
+}
+
+func (st *SQLStore) PutSession(ctx context.Context, rec SessionRecord) error {
+	labels, settings, err := encodeSessionMetadata(rec)
+	if err != nil {
+		return err
+	}
+	key, wrapped, err := st.sessionKey(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	if settings, err = encryptValue(key, settings); err != nil {
+		return err
+	}
+	_, err = st.db.ExecContext(ctx, `INSERT INTO gateway_sessions (id, token_hash, created_at, name, labels, settings, data_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET token_hash=excluded.token_hash, name=excluded.name,
+			labels=excluded.labels, settings=excluded.settings`,
+		rec.ID, rec.TokenHash, rec.CreatedAt.Unix(), rec.Name, labels, settings, wrapped)
+	return err
+}
+
+func (st *SQLStore) GetSession(ctx context.Context, id string) (SessionRecord, error) {
+	row := st.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM gateway_sessions WHERE id=$1`, id)
+	return st.scanSessionRecord(row)
+}
+
+func (st *SQLStore) ListSessions(ctx context.Context) ([]SessionRecord, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM gateway_sessions ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionRecord
+	for rows.Next() {
+		rec, err := st.scanSessionRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (st *SQLStore) DeleteSession(ctx context.Context, id string) error {
+	_, err := st.db.ExecContext(ctx, `DELETE FROM gateway_sessions WHERE id=$1`, id)
+	return err
+}
+
+func (st *SQLStore) SetTokenHash(ctx context.Context, id string, tokenHash string) error {
+	res, err := st.db.ExecContext(ctx, `UPDATE gateway_sessions SET token_hash=$1 WHERE id=$2`, tokenHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStoreNotFound
+	}
+	return nil
+}
+
+func (st *SQLStore) SetSettings(ctx context.Context, id string, settings Settings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	key, _, err := st.sessionKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	value, err := encryptValue(key, string(raw))
+	if err != nil {
+		return err
+	}
+	res, err := st.db.ExecContext(ctx, `UPDATE gateway_sessions SET settings=$1 WHERE id=$2`, value, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStoreNotFound
+	}
+	return nil
+}
+
+func (st *SQLStore) SetExported(ctx context.Context, id string, at time.Time) error {
+	var unix int64
+	if !at.IsZero() {
+		unix = at.Unix()
+	}
+	res, err := st.db.ExecContext(ctx, `UPDATE gateway_sessions SET exported_at=$1 WHERE id=$2`, unix, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrStoreNotFound
+	}
+	return nil
+}
+
+func (st *SQLStore) AddMessage(ctx context.Context, msg Message) error {
+	if err := st.encryptMessage(ctx, &msg); err != nil {
+		return err
+	}
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO gateway_messages
+		(session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending, encrypted)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (session_id, chat_jid, message_id) DO NOTHING`,
+		msg.SessionID, msg.ID, msg.Chat, msg.Sender, msg.SenderName, msg.FromMe, msg.Type, msg.Text, msg.Timestamp, !msg.FromMe, msg.encrypted)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if err := addChatMessage(ctx, tx, msg, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (st *SQLStore) PopPendingMessages(ctx context.Context, sessionID string, limit int) ([]Message, error) {
+	// The key is looked up first: with SQLite the transaction holds the only
+	// connection. Messages are decrypted before the dequeue is committed, so
+	// that they stay pending if they cannot be.
+	key, _, err := st.sessionKey(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + messageColumns + ` FROM gateway_messages WHERE session_id=$1 AND pending ORDER BY id`
+	args := []any{sessionID}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	if err := decryptMessagesWith(key, msgs); err != nil {
+		return nil, err
+	}
+
+	last := msgs[len(msgs)-1].Seq
+	if _, err := tx.ExecContext(ctx, `UPDATE gateway_messages SET pending=false WHERE session_id=$1 AND pending AND id<=$2`, sessionID, last); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (st *SQLStore) ReceiveMessages(ctx context.Context, sessionID string, after int64, limit int) ([]Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM gateway_messages WHERE session_id=$1 AND pending ORDER BY id`
+	args := []any{sessionID}
+	if after > 0 {
+		if _, err := st.db.ExecContext(ctx, `UPDATE gateway_messages SET pending=false WHERE session_id=$1 AND pending AND id<=$2`, sessionID, after); err != nil {
+			return nil, err
+		}
+		// Only queued messages are returned: history was never queued, and
+		// messages taken with PopPendingMessages were delivered already.
+		query = `SELECT ` + messageColumns + ` FROM gateway_messages WHERE session_id=$1 AND pending AND id>$2 ORDER BY id`
+		args = append(args, after)
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, limit)
+	}
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, st.decryptMessages(ctx, msgs)
+}
+
+func (st *SQLStore) CountPendingMessages(ctx context.Context, sessionID string) (int, error) {
+	var n int
+	err := st.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM gateway_messages WHERE session_id=$1 AND pending`, sessionID).Scan(&n)
+	return n, err
+}
+
+func (st *SQLStore) AllMessages(ctx context.Context, sessionID string) ([]Message, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+messageColumns+` FROM gateway_messages WHERE session_id=$1 ORDER BY id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, st.decryptMessages(ctx, msgs)
+}
+
+func (st *SQLStore) ImportSession(ctx context.Context, rec SessionRecord, msgs []Message, chats []Chat) error {
+	labels, settings, err := encodeSessionMetadata(rec)
+	if err != nil {
+		return err
+	}
+	// Encrypt before the transaction: with SQLite it holds the only
+	// connection, which key lookups need.
+	key, wrapped, err := st.sessionKey(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	if settings, err = encryptValue(key, settings); err != nil {
+		return err
+	}
+	sealed := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		msg.SessionID = rec.ID
+		if err := encryptMessageWith(key, &msg); err != nil {
+			return err
+		}
+		sealed[i] = msg
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO gateway_sessions (id, token_hash, created_at, name, labels, settings, data_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		rec.ID, rec.TokenHash, rec.CreatedAt.Unix(), rec.Name, labels, settings, wrapped)
+	if err != nil {
+		return err
+	}
+	for _, msg := range sealed {
+		_, err := tx.ExecContext(ctx, `INSERT INTO gateway_messages
+			(session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending, encrypted)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (session_id, chat_jid, message_id) DO NOTHING`,
+			msg.SessionID, msg.ID, msg.Chat, msg.Sender, msg.SenderName, msg.FromMe, msg.Type, msg.Text, msg.Timestamp, msg.Pending, msg.encrypted)
+		if err != nil {
+			return err
+		}
+	}
+	if err := putChats(ctx, tx, rec.ID, key, chats); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const sessionColumns = `id, token_hash, created_at, name, labels, settings, exported_at, data_key`
+
+const messageColumns = `id, session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending, encrypted`
+
+type scannable interface {
+	Scan(dest ...any) error
+}
+
+func (st *SQLStore) scanSessionRecord(row scannable) (SessionRecord, error) {
+	var rec SessionRecord
+	var created int64
+	var labels, settings, wrapped string
+	var exported int64
+	err := row.Scan(&rec.ID, &rec.TokenHash, &created, &rec.Name, &labels, &settings, &exported, &wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rec, ErrStoreNotFound
+	} else if err != nil {
+		return rec, err
+	}
+	rec.CreatedAt = time.Unix(created, 0)
+	if exported != 0 {
+		rec.ExportedAt = time.Unix(exported, 0)
+	}
+	if err := json.Unmarshal([]byte(labels), &rec.Labels); err != nil {
+		return rec, fmt.Errorf("session %s: invalid labels: %w", rec.ID, err)
+	}
+	key, err := st.unwrapKey(rec.ID, wrapped)
+	if err != nil {
+		return rec, err
+	}
+	// Settings are JSON, which cannot start with the ciphertext prefix.
+	if crypt.IsEncrypted(settings) {
+		if settings, err = crypt.DecryptString(key, settings); err != nil {
+			return rec, fmt.Errorf("session %s: settings: %w", rec.ID, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(settings), &rec.Settings); err != nil {
+		return rec, fmt.Errorf("session %s: invalid settings: %w", rec.ID, err)
+	}
+	return rec, nil
+}
+
+func encodeSessionMetadata(rec SessionRecord) (string, string, error) {
+	labels := rec.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	rawLabels, err := json.Marshal(labels)
+	if err != nil {
+		return "", "", err
+	}
+	rawSettings, err := json.Marshal(rec.Settings)
+	if err != nil {
+		return "", "", err
+	}
+	return string(rawLabels), string(rawSettings), nil
+}
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+
+	var out []Message
+	for rows.Next() {
+		var msg Message
+		err := rows.Scan(&msg.Seq, &msg.SessionID, &msg.ID, &msg.Chat, &msg.Sender, &msg.SenderName, &msg.FromMe, &msg.Type, &msg.Text, &msg.Timestamp, &msg.Pending, &msg.encrypted)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	return out, rows.Err()
+}
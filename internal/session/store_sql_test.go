@@ -0,0 +1,721 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"wa-mvp-api/internal/crypt"
+)
+
+const (
+	testChatA = "111@s.whatsapp.net"
+	testChatB = "222@s.whatsapp.net"
+)
+
+func testMessage(sessionID, id, chat string, fromMe bool, text string, ts int64) Message {
+	sender := chat
+	if fromMe {
+		sender = "999@s.whatsapp.net"
+	}
+	return Message{
+		SessionID:  sessionID,
+		ID:         id,
+		Chat:       chat,
+		Sender:     sender,
+		SenderName: "Name " + id,
+		FromMe:     fromMe,
+		Type:       "text",
+		Text:       text,
+		Timestamp:  ts,
+	}
+}
+
+func addMessages(t *testing.T, st *SQLStore, msgs ...Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := st.AddMessage(context.Background(), msg); err != nil {
+			t.Fatalf("add message %s: %v", msg.ID, err)
+		}
+	}
+}
+
+func messageIDs(msgs []Message) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func checkIDs(t *testing.T, what string, msgs []Message, want ...string) {
+	t.Helper()
+	if got := messageIDs(msgs); !reflect.DeepEqual(got, want) && !(len(got) == 0 && len(want) == 0) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestStoreMigrations(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		var version int
+		if err := st.db.QueryRowContext(ctx, `SELECT version FROM gateway_version`).Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(storeMigrations) {
+			t.Errorf("schema version = %d, want %d", version, len(storeMigrations))
+		}
+		putTestSession(t, st, "s1")
+
+		// Reopening finds the schema current and keeps the data.
+		st = open()
+		if _, err := st.GetSession(ctx, "s1"); err != nil {
+			t.Errorf("get session after reopening: %v", err)
+		}
+
+		// A schema from a newer binary is refused.
+		if _, err := st.db.ExecContext(ctx, `UPDATE gateway_version SET version=$1`, len(storeMigrations)+1); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.migrate(ctx); err == nil {
+			t.Error("migrated a schema newer than the binary")
+		}
+	})
+}
+
+// TestStoreMigrationUpgrade fills a database at the schema before the
+// encryption flags with a mix of plaintext and ciphertext and checks that it
+// reads back the same after upgrading.
+func TestStoreMigrationUpgrade(t *testing.T) {
+	const flagsVersion = 8
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		master := testMasterKey(t)
+
+		st := open()
+		old := &SQLStore{db: st.db, dialect: st.dialect}
+		for _, stmt := range []string{`DROP TABLE gateway_webhook_events`, `DROP TABLE gateway_chats`, `DROP TABLE gateway_link_opens`, `DROP TABLE gateway_links`,
+			`DROP TABLE gateway_messages`, `DROP TABLE gateway_sessions`, `DELETE FROM gateway_version`} {
+			if _, err := st.db.ExecContext(ctx, stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+		for i := 0; i < flagsVersion-1; i++ {
+			if err := old.applyMigration(ctx, i+1, storeMigrations[i]); err != nil {
+				t.Fatalf("migration %d: %v", i+1, err)
+			}
+		}
+
+		dataKey, err := crypt.NewDataKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped, err := master.Wrap(dataKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := encryptValue(dataKey, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range []struct {
+			query string
+			args  []any
+		}{
+			{`INSERT INTO gateway_sessions (id, token_hash, created_at, data_key) VALUES ($1, $2, $3, $4)`, []any{"plain", "h1", 1, ""}},
+			{`INSERT INTO gateway_sessions (id, token_hash, created_at, data_key) VALUES ($1, $2, $3, $4)`, []any{"keyed", "h2", 1, wrapped}},
+			{`INSERT INTO gateway_messages (session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, []any{"plain", "m1", testChatA, testChatA, "", false, "text", lookalike, 1, true}},
+			{`INSERT INTO gateway_messages (session_id, message_id, chat_jid, sender_jid, sender_name, from_me, type, body, timestamp, pending)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, []any{"keyed", "m1", testChatA, testChatA, "", false, "text", body, 1, true}},
+			{`INSERT INTO gateway_chats (session_id, jid, last_text) VALUES ($1, $2, $3)`, []any{"plain", testChatA, lookalike}},
+			{`INSERT INTO gateway_chats (session_id, jid, last_text) VALUES ($1, $2, $3)`, []any{"keyed", testChatA, body}},
+		} {
+			if _, err := st.db.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				t.Fatalf("%s: %v", stmt.query, err)
+			}
+		}
+
+		st = open()
+		st.UseMasterKey(master)
+		for id, want := range map[string]string{"plain": lookalike, "keyed": "secret"} {
+			msgs, err := st.PopPendingMessages(ctx, id, 0)
+			if err != nil {
+				t.Fatalf("%s: pop pending: %v", id, err)
+			}
+			if len(msgs) != 1 || msgs[0].Text != want {
+				t.Errorf("%s: messages = %+v, want one with text %q", id, msgs, want)
+			}
+			chats, err := st.ListChats(ctx, id, ChatFilter{})
+			if err != nil {
+				t.Fatalf("%s: list chats: %v", id, err)
+			}
+			if len(chats) != 1 || chats[0].LastText != want {
+				t.Errorf("%s: chats = %+v, want one with last text %q", id, chats, want)
+			}
+		}
+		found, err := st.ListMessages(ctx, "plain", MessageFilter{Search: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "search after upgrade", found, "m1")
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		rec := putTestSession(t, st, "s1")
+		putTestSession(t, st, "s2")
+
+		got, err := st.GetSession(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != rec.ID || got.TokenHash != rec.TokenHash || got.Name != rec.Name || !got.CreatedAt.Equal(rec.CreatedAt) ||
+			!reflect.DeepEqual(got.Labels, rec.Labels) || got.Settings != rec.Settings || !got.ExportedAt.IsZero() {
+			t.Errorf("session = %+v, want %+v", got, rec)
+		}
+		if _, err := st.GetSession(ctx, "missing"); !errors.Is(err, ErrStoreNotFound) {
+			t.Errorf("missing session error = %v, want %v", err, ErrStoreNotFound)
+		}
+
+		if err := st.SetTokenHash(ctx, "s1", "rotated"); err != nil {
+			t.Fatal(err)
+		}
+		settings := Settings{AutoRead: true, RateLimitPerMinute: 5}
+		if err := st.SetSettings(ctx, "s1", settings); err != nil {
+			t.Fatal(err)
+		}
+		exported := time.Unix(1700000100, 0)
+		if err := st.SetExported(ctx, "s1", exported); err != nil {
+			t.Fatal(err)
+		}
+		got, err = st.GetSession(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.TokenHash != "rotated" || got.Settings != settings || !got.ExportedAt.Equal(exported) {
+			t.Errorf("updated session = %+v", got)
+		}
+		if err := st.SetExported(ctx, "s1", time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := st.GetSession(ctx, "s1"); !got.ExportedAt.IsZero() {
+			t.Errorf("exported at = %v after clearing", got.ExportedAt)
+		}
+
+		for name, err := range map[string]error{
+			"set token hash": st.SetTokenHash(ctx, "missing", "x"),
+			"set settings":   st.SetSettings(ctx, "missing", Settings{}),
+			"set exported":   st.SetExported(ctx, "missing", exported),
+		} {
+			if !errors.Is(err, ErrStoreNotFound) {
+				t.Errorf("%s on missing session: error = %v, want %v", name, err, ErrStoreNotFound)
+			}
+		}
+
+		list, err := st.ListSessions(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].ID != "s1" || list[1].ID != "s2" {
+			t.Errorf("sessions = %+v, want s1 and s2", list)
+		}
+
+		// Deleting a session removes its messages and chats with it.
+		addMessages(t, st, testMessage("s2", "m1", testChatA, false, "hi", 1))
+		if err := st.DeleteSession(ctx, "s2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.GetSession(ctx, "s2"); !errors.Is(err, ErrStoreNotFound) {
+			t.Errorf("deleted session error = %v, want %v", err, ErrStoreNotFound)
+		}
+		var rows int
+		if err := st.db.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM gateway_messages) + (SELECT COUNT(*) FROM gateway_chats)`).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if rows != 0 {
+			t.Errorf("%d message and chat rows left after deleting the session", rows)
+		}
+	})
+}
+
+func TestStoreMessageQueue(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		putTestSession(t, st, "s1")
+		addMessages(t, st,
+			testMessage("s1", "in1", testChatA, false, "one", 1),
+			testMessage("s1", "out1", testChatA, true, "two", 2),
+			testMessage("s1", "in2", testChatB, false, "three", 3),
+			testMessage("s1", "in3", testChatA, false, "four", 4),
+		)
+		// Storing a message again changes nothing.
+		addMessages(t, st, testMessage("s1", "in1", testChatA, false, "changed", 5))
+
+		if n, err := st.CountPendingMessages(ctx, "s1"); err != nil || n != 3 {
+			t.Errorf("pending = %d, %v; want 3", n, err)
+		}
+		queued, err := st.ReceiveMessages(ctx, "s1", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "queued", queued, "in1", "in2", "in3")
+		if queued[0].Text != "one" || queued[0].SenderName != "Name in1" {
+			t.Errorf("first queued message = %+v", queued[0])
+		}
+
+		popped, err := st.PopPendingMessages(ctx, "s1", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "popped", popped, "in1")
+
+		// Acknowledging in2 leaves in3 queued and returns what follows in2.
+		after, err := st.ReceiveMessages(ctx, "s1", queued[1].Seq, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "after in2", after, "in3")
+		if n, _ := st.CountPendingMessages(ctx, "s1"); n != 1 {
+			t.Errorf("pending after acknowledging = %d, want 1", n)
+		}
+
+		popped, err = st.PopPendingMessages(ctx, "s1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "popped rest", popped, "in3")
+		if popped, err := st.PopPendingMessages(ctx, "s1", 0); err != nil || len(popped) != 0 {
+			t.Errorf("popped from empty queue = %v, %v", popped, err)
+		}
+	})
+}
+
+// Receiving by cursor returns live messages only: neither synced history nor
+// messages already popped.
+func TestStoreReceiveSkipsHistory(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		putTestSession(t, st, "s1")
+		addMessages(t, st, testMessage("s1", "live1", testChatA, false, "one", 100))
+		first, err := st.ReceiveMessages(ctx, "s1", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "queued", first, "live1")
+
+		history := []Message{
+			testMessage("s1", "old1", testChatA, false, "months ago", 1),
+			testMessage("s1", "old2", testChatB, false, "weeks ago", 2),
+		}
+		if n, err := st.AddHistoryMessages(ctx, history); err != nil || n != 2 {
+			t.Fatalf("add history = %d, %v; want 2", n, err)
+		}
+		addMessages(t, st,
+			testMessage("s1", "live2", testChatA, false, "two", 101),
+			testMessage("s1", "live3", testChatB, false, "three", 102),
+			testMessage("s1", "live4", testChatA, false, "four", 103),
+		)
+		popped, err := st.PopPendingMessages(ctx, "s1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "popped", popped, "live1", "live2", "live3", "live4")
+		addMessages(t, st, testMessage("s1", "live5", testChatB, false, "five", 104))
+
+		after, err := st.ReceiveMessages(ctx, "s1", first[0].Seq, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "after live1", after, "live5")
+	})
+}
+
+func TestStoreImportSession(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		st.UseMasterKey(testMasterKey(t))
+		rec := putTestSession(t, st, "from")
+		addMessages(t, st,
+			testMessage("from", "m1", testChatA, false, "one", 1),
+			testMessage("from", "m2", testChatA, true, "two", 2),
+		)
+		if err := st.UpdateChat(ctx, "from", testChatA, ChatUpdate{Name: "Alice"}); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs, err := st.AllMessages(ctx, "from")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkIDs(t, "all messages", msgs, "m1", "m2")
+		if !msgs[0].Pending || msgs[1].Pending {
+			t.Errorf("pending flags = %v, %v; want true, false", msgs[0].Pending, msgs[1].Pending)
+		}
+		chats, err := st.AllChats(ctx, "from")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec.ID, rec.TokenHash = "to", "hash-to"
+		if err := st.ImportSession(ctx, rec, msgs, chats); err != nil {
+			t.Fatal(err)
+		}
+		got, err := st.GetSession(ctx, "to")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != rec.Name || got.Settings != rec.Settings {
+			t.Errorf("imported session = %+v, want %+v", got, rec)
+		}
+		copied, err := st.AllMessages(ctx, "to")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range copied {
+			copied[i].Seq, msgs[i].Seq = 0, 0
+			copied[i].SessionID, msgs[i].SessionID = "", ""
+		}
+		if !reflect.DeepEqual(copied, msgs) {
+			t.Errorf("imported messages = %+v, want %+v", copied, msgs)
+		}
+		copiedChats, err := st.AllChats(ctx, "to")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(copiedChats, chats) {
+			t.Errorf("imported chats = %+v, want %+v", copiedChats, chats)
+		}
+
+		if err := st.ImportSession(ctx, rec, nil, nil); err == nil {
+			t.Error("imported a session that exists")
+		}
+
+		// A failure part way leaves nothing behind.
+		failChatInsert(t, st, "fail@s.whatsapp.net")
+		rec.ID, rec.TokenHash = "failed", "hash-failed"
+		err = st.ImportSession(ctx, rec, msgs, append(chats, Chat{JID: "fail@s.whatsapp.net"}))
+		if err == nil {
+			t.Fatal("import succeeded despite the failing chat")
+		}
+		if _, err := st.GetSession(ctx, "failed"); !errors.Is(err, ErrStoreNotFound) {
+			t.Errorf("session after failed import: error = %v, want %v", err, ErrStoreNotFound)
+		}
+		if left, err := st.AllMessages(ctx, "failed"); err != nil || len(left) != 0 {
+			t.Errorf("messages after failed import = %v, %v", left, err)
+		}
+	})
+}
+
+// failChatInsert makes inserting a chat with the given JID fail.
+func failChatInsert(t *testing.T, st *SQLStore, jid string) {
+	t.Helper()
+	stmts := []string{`CREATE TRIGGER gateway_chats_fail BEFORE INSERT ON gateway_chats
+		WHEN new.jid = '` + jid + `' BEGIN SELECT RAISE(ABORT, 'injected failure'); END`}
+	if st.dialect == DialectPostgres {
+		stmts = []string{
+			`CREATE FUNCTION gateway_chats_fail() RETURNS trigger AS $$
+				BEGIN RAISE EXCEPTION 'injected failure'; END $$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER gateway_chats_fail BEFORE INSERT ON gateway_chats
+				FOR EACH ROW WHEN (new.jid = '` + jid + `') EXECUTE FUNCTION gateway_chats_fail()`,
+		}
+	}
+	for _, stmt := range stmts {
+		if _, err := st.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreListMessages(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		name := "plaintext"
+		if encrypted {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+				testListMessages(t, open(), encrypted)
+			})
+		})
+	}
+}
+
+func testListMessages(t *testing.T, st *SQLStore, encrypted bool) {
+	ctx := context.Background()
+	if encrypted {
+		st.UseMasterKey(testMasterKey(t))
+	}
+	putTestSession(t, st, "s1")
+	putTestSession(t, st, "other")
+	image := testMessage("s1", "m4", testChatA, false, "holiday photo", 400)
+	image.Type = "image"
+	addMessages(t, st,
+		testMessage("s1", "m1", testChatA, false, "Hello there", 100),
+		testMessage("s1", "m2", testChatB, true, "hello again, world", 200),
+		testMessage("s1", "m3", testChatA, true, "50% off_sale", 300),
+		image,
+		testMessage("other", "x1", testChatA, false, "hello", 100),
+	)
+
+	fromMe := true
+	for name, tc := range map[string]struct {
+		filter MessageFilter
+		want   []string
+	}{
+		"all":           {MessageFilter{}, []string{"m4", "m3", "m2", "m1"}},
+		"chat":          {MessageFilter{Chat: testChatA}, []string{"m4", "m3", "m1"}},
+		"sender":        {MessageFilter{Sender: testChatA}, []string{"m4", "m1"}},
+		"types":         {MessageFilter{Types: []string{"image", "video"}}, []string{"m4"}},
+		"from me":       {MessageFilter{FromMe: &fromMe}, []string{"m3", "m2"}},
+		"since until":   {MessageFilter{Since: time.Unix(200, 0), Until: time.Unix(300, 0)}, []string{"m3", "m2"}},
+		"text":          {MessageFilter{Text: "HELLO"}, []string{"m2", "m1"}},
+		"text wildcard": {MessageFilter{Text: "0% off_"}, []string{"m3"}},
+		"text escaped":  {MessageFilter{Text: "%"}, []string{"m3"}},
+		"search":        {MessageFilter{Search: "hello world"}, []string{"m2"}},
+		"search prefix": {MessageFilter{Search: "holi*"}, []string{"m4"}},
+		"limit":         {MessageFilter{Limit: 2}, []string{"m4", "m3"}},
+		"after":         {MessageFilter{After: &MessagePosition{Timestamp: 300, Seq: 1 << 40}, Limit: 2}, []string{"m3", "m2"}},
+	} {
+		msgs, err := st.ListMessages(ctx, "s1", tc.filter)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		checkIDs(t, name, msgs, tc.want...)
+	}
+
+	// Paging through the search results visits each match once.
+	var paged []Message
+	filter := MessageFilter{Search: "hello", Limit: 1}
+	for {
+		page, err := st.ListMessages(ctx, "s1", filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		pos := page[len(page)-1].Position()
+		filter.After = &pos
+	}
+	checkIDs(t, "paged search", paged, "m2", "m1")
+}
+
+func TestStoreChats(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		putTestSession(t, st, "s1")
+		addMessages(t, st,
+			testMessage("s1", "a1", testChatA, false, "first", 100),
+			testMessage("s1", "a2", testChatA, false, "second", 200),
+			testMessage("s1", "b1", testChatB, false, "hi", 300),
+			testMessage("s1", "b2", testChatB, true, "reply", 400),
+		)
+		// History adds older messages without touching the unread count or
+		// the latest message.
+		n, err := st.AddHistoryMessages(ctx, []Message{
+			testMessage("s1", "a0", testChatA, false, "older", 50),
+			testMessage("s1", "a1", testChatA, false, "first", 100),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("history messages stored = %d, want 1", n)
+		}
+
+		chats, err := st.ListChats(ctx, "s1", ChatFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chats) != 2 {
+			t.Fatalf("chats = %+v, want 2", chats)
+		}
+		b, a := chats[0], chats[1]
+		if b.JID != testChatB || b.LastMessageID != "b2" || !b.LastFromMe || b.LastText != "reply" || b.UnreadCount != 0 {
+			t.Errorf("chat b = %+v", b)
+		}
+		if a.JID != testChatA || a.LastMessageID != "a2" || a.LastMessageAt != 200 || a.LastText != "second" || a.UnreadCount != 2 {
+			t.Errorf("chat a = %+v", a)
+		}
+
+		read, archived, pinned, muted := true, true, true, int64(MutedForever)
+		if err := st.UpdateChat(ctx, "s1", testChatA, ChatUpdate{Name: "Alice", Archived: &archived, Pinned: &pinned, MutedUntil: &muted}); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.UpdateChat(ctx, "s1", testChatB, ChatUpdate{Read: new(bool)}); err != nil {
+			t.Fatal(err)
+		}
+		// Updating an unknown chat creates it.
+		if err := st.UpdateChat(ctx, "s1", "333@s.whatsapp.net", ChatUpdate{Read: &read}); err != nil {
+			t.Fatal(err)
+		}
+
+		unread := true
+		for name, tc := range map[string]struct {
+			filter ChatFilter
+			want   []string
+		}{
+			"archived":     {ChatFilter{Archived: &archived}, []string{testChatA}},
+			"not archived": {ChatFilter{Archived: new(bool)}, []string{testChatB, "333@s.whatsapp.net"}},
+			"unread":       {ChatFilter{Unread: &unread}, []string{testChatB, testChatA}},
+			"read":         {ChatFilter{Unread: new(bool)}, []string{"333@s.whatsapp.net"}},
+			"limit":        {ChatFilter{Limit: 1}, []string{testChatB}},
+			"after":        {ChatFilter{After: &ChatPosition{LastMessageAt: 400, JID: testChatB}}, []string{testChatA, "333@s.whatsapp.net"}},
+		} {
+			chats, err := st.ListChats(ctx, "s1", tc.filter)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			jids := make([]string, len(chats))
+			for i, chat := range chats {
+				jids[i] = chat.JID
+			}
+			if !reflect.DeepEqual(jids, tc.want) {
+				t.Errorf("%s = %v, want %v", name, jids, tc.want)
+			}
+		}
+
+		all, err := st.AllChats(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a = all[0]
+		if a.Name != "Alice" || !a.Archived || !a.Pinned || a.MutedUntil != MutedForever {
+			t.Errorf("updated chat = %+v", a)
+		}
+	})
+}
+
+func TestStoreLinks(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		st := open()
+		putTestSession(t, st, "s1")
+		created := time.Unix(1700000000, 0).UTC()
+		for _, id := range []string{"l1", "l2"} {
+			link := Link{ID: id, SessionID: "s1", CreatedAt: created, ExpiresAt: created.Add(time.Hour)}
+			if err := st.CreateLink(ctx, link); err != nil {
+				t.Fatal(err)
+			}
+		}
+		visit := LinkOpen{At: created.Add(time.Minute), RemoteAddr: "192.0.2.1", ForwardedFor: "198.51.100.7", UserAgent: "test"}
+		if err := st.AddLinkOpen(ctx, "l1", visit); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := st.GetLink(ctx, "l1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !link.CreatedAt.Equal(created) || !link.ConsumedAt.IsZero() || !reflect.DeepEqual(link.Opens, []LinkOpen{visit}) {
+			t.Errorf("link = %+v", link)
+		}
+		if _, err := st.GetLink(ctx, "missing"); !errors.Is(err, ErrStoreNotFound) {
+			t.Errorf("missing link error = %v, want %v", err, ErrStoreNotFound)
+		}
+
+		consumed := created.Add(2 * time.Minute)
+		if n, err := st.ConsumeLinks(ctx, "s1", consumed); err != nil || n != 2 {
+			t.Errorf("consumed = %d, %v; want 2", n, err)
+		}
+		if n, err := st.ConsumeLinks(ctx, "s1", consumed.Add(time.Minute)); err != nil || n != 0 {
+			t.Errorf("consumed again = %d, %v; want 0", n, err)
+		}
+		links, err := st.ListLinks(ctx, "s1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != 2 || !links[1].ConsumedAt.Equal(consumed) || len(links[1].Opens) != 0 {
+			t.Errorf("links = %+v", links)
+		}
+	})
+}
+
+func TestStoreEncryption(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+		ctx := context.Background()
+		first, second := testMasterKey(t), testMasterKey(t)
+		st := open()
+		st.UseMasterKey(first)
+		rec := putTestSession(t, st, "s1")
+		addMessages(t, st, testMessage("s1", "m1", testChatA, false, "secret text", 100))
+		if err := st.UpdateChat(ctx, "s1", testChatA, ChatUpdate{Name: "Secret name"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Nothing readable reaches the database.
+		var settings, body, sender, name, lastText string
+		err := st.db.QueryRowContext(ctx, `SELECT s.settings, m.body, m.sender_name, c.name, c.last_text
+			FROM gateway_sessions s JOIN gateway_messages m ON m.session_id=s.id JOIN gateway_chats c ON c.session_id=s.id
+			WHERE s.id=$1`, "s1").Scan(&settings, &body, &sender, &name, &lastText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for what, value := range map[string]string{"settings": settings, "body": body, "sender name": sender, "chat name": name, "last text": lastText} {
+			if !crypt.IsEncrypted(value) {
+				t.Errorf("stored %s %q is not encrypted", what, value)
+			}
+		}
+
+		check := func(st *SQLStore) {
+			t.Helper()
+			got, err := st.GetSession(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Settings != rec.Settings {
+				t.Errorf("settings = %+v, want %+v", got.Settings, rec.Settings)
+			}
+			msgs, err := st.AllMessages(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 || msgs[0].Text != "secret text" || msgs[0].SenderName != "Name m1" {
+				t.Errorf("messages = %+v", msgs)
+			}
+			chats, err := st.AllChats(ctx, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chats) != 1 || chats[0].Name != "Secret name" || chats[0].LastText != "secret text" {
+				t.Errorf("chats = %+v", chats)
+			}
+		}
+		check(st)
+
+		report, err := st.RotateMasterKey(ctx, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Sessions != 1 || report.RewrappedKeys != 1 || report.EncryptedMessages != 0 || report.EncryptedChats != 0 {
+			t.Errorf("report = %+v", report)
+		}
+
+		// A fresh store needs the new key.
+		st = open()
+		if _, err := st.GetSession(ctx, "s1"); err == nil {
+			t.Error("read encrypted settings without a master key")
+		}
+		st.UseMasterKey(first)
+		if _, err := st.GetSession(ctx, "s1"); err == nil {
+			t.Error("read a data key with the old master key")
+		}
+		st = open()
+		st.UseMasterKey(second)
+		check(st)
+	})
+}
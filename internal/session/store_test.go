@@ -0,0 +1,101 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPostgresEnv names the variable holding a PostgreSQL DSN to run the
+// store tests against as well. Each test gets a schema of its own, dropped
+// afterwards.
+const testPostgresEnv = "TEST_POSTGRES_DSN"
+
+// forEachDialect runs test against a fresh SQLite database and, when
+// testPostgresEnv is set or the tests are built with the postgres tag, a
+// fresh PostgreSQL schema. open returns a new store
+// on the test's database, so that tests can reopen it.
+func forEachDialect(t *testing.T, test func(t *testing.T, open func() *SQLStore)) {
+	t.Run(DialectSQLite, func(t *testing.T) {
+		address := "file:" + filepath.Join(t.TempDir(), "gateway.db") + "?_foreign_keys=on&_busy_timeout=5000"
+		test(t, func() *SQLStore { return openTestStore(t, DialectSQLite, address) })
+	})
+	t.Run(DialectPostgres, func(t *testing.T) {
+		dsn := os.Getenv(testPostgresEnv)
+		if dsn == "" {
+			t.Skip(testPostgresEnv + " is not set; build with -tags postgres to test on an embedded server")
+		}
+		address := postgresTestSchema(t, dsn)
+		test(t, func() *SQLStore { return openTestStore(t, DialectPostgres, address) })
+	})
+}
+
+func openTestStore(t *testing.T, dialect string, address string) *SQLStore {
+	t.Helper()
+	st, err := OpenSQLStore(context.Background(), dialect, address)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = st.Close() })
+	return st
+}
+
+// postgresTestSchema creates a schema for one test and returns dsn with its
+// search path set to it.
+func postgresTestSchema(t *testing.T, dsn string) string {
+	t.Helper()
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "gateway_test_" + hex.EncodeToString(suffix)
+
+	db, err := sql.Open(DialectPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		_ = db.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		_ = db.Close()
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// putTestSession stores a session record with the given ID.
+func putTestSession(t *testing.T, st *SQLStore, id string) SessionRecord {
+	t.Helper()
+	rec := SessionRecord{
+		ID:        id,
+		TokenHash: "hash-" + id,
+		CreatedAt: time.Unix(1700000000, 0),
+		Name:      "Session " + id,
+		Labels:    []string{"test"},
+		Settings:  Settings{WebhookURL: "https://example.com/hook"},
+	}
+	if err := st.PutSession(context.Background(), rec); err != nil {
+		t.Fatalf("put session: %v", err)
+	}
+	return rec
+}
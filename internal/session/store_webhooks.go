@@ -0,0 +1,111 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"wa-mvp-api/internal/crypt"
+)
+
+// Webhook events are stored as their JSON body, encrypted with the session's
+// data key like message bodies, since they carry message text.
+
+func (st *SQLStore) SaveWebhookEvents(ctx context.Context, sessionID string, events []WebhookEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	key, _, err := st.sessionKey(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	payloads := make([]string, len(events))
+	for i, evt := range events {
+		body, err := json.Marshal(evt)
+		if err != nil {
+			return err
+		}
+		if payloads[i], err = encryptValue(key, string(body)); err != nil {
+			return err
+		}
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, payload := range payloads {
+		_, err := tx.ExecContext(ctx, `INSERT INTO gateway_webhook_events (session_id, payload, encrypted) VALUES ($1, $2, $3)`,
+			sessionID, payload, key != nil)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (st *SQLStore) TakeWebhookEvents(ctx context.Context, sessionID string) ([]WebhookEvent, error) {
+	// As in PopPendingMessages, the key is looked up before the transaction
+	// and events are decoded before they are removed.
+	key, _, err := st.sessionKey(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, payload, encrypted FROM gateway_webhook_events WHERE session_id=$1 ORDER BY id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var events []WebhookEvent
+	var last int64
+	for rows.Next() {
+		var payload string
+		var encrypted bool
+		if err := rows.Scan(&last, &payload, &encrypted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if encrypted {
+			if payload, err = crypt.DecryptString(key, payload); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("webhook event %d: %w", last, err)
+			}
+		}
+		var evt struct {
+			WebhookEvent
+			Data json.RawMessage `json:"data,omitempty"`
+		}
+		if err := json.Unmarshal([]byte(payload), &evt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("webhook event %d: %w", last, err)
+		}
+		// Data is passed on as the JSON it was delivered as.
+		if evt.Data != nil {
+			evt.WebhookEvent.Data = evt.Data
+		}
+		events = append(events, evt.WebhookEvent)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_webhook_events WHERE session_id=$1 AND id<=$2`, sessionID, last); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
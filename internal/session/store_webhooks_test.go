@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStoreWebhookEvents(t *testing.T) {
+	for name, encrypt := range map[string]bool{"plaintext": false, "encrypted": true} {
+		t.Run(name, func(t *testing.T) {
+			forEachDialect(t, func(t *testing.T, open func() *SQLStore) {
+				ctx := context.Background()
+				st := open()
+				if encrypt {
+					st.UseMasterKey(testMasterKey(t))
+				}
+				putTestSession(t, st, "s1")
+				putTestSession(t, st, "s2")
+
+				events := []WebhookEvent{
+					{Event: "message", SessionID: "s1", Timestamp: 1700000000, Data: IncomingMessage{From: "123", Message: "hello", Timestamp: 1700000000}},
+					{Event: "state", SessionID: "s1", Timestamp: 1700000001},
+				}
+				if err := st.SaveWebhookEvents(ctx, "s1", events); err != nil {
+					t.Fatalf("save: %v", err)
+				}
+				if err := st.SaveWebhookEvents(ctx, "s2", events[1:]); err != nil {
+					t.Fatalf("save: %v", err)
+				}
+
+				if encrypt {
+					var payload string
+					err := st.db.QueryRowContext(ctx, `SELECT payload FROM gateway_webhook_events WHERE session_id=$1 ORDER BY id LIMIT 1`, "s1").Scan(&payload)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if json.Valid([]byte(payload)) {
+						t.Errorf("stored payload %q, want ciphertext", payload)
+					}
+				}
+
+				got, err := st.TakeWebhookEvents(ctx, "s1")
+				if err != nil {
+					t.Fatalf("take: %v", err)
+				}
+				want, _ := json.Marshal(events)
+				if body, _ := json.Marshal(got); string(body) != string(want) {
+					t.Errorf("taken events = %s, want %s", body, want)
+				}
+
+				if got, err := st.TakeWebhookEvents(ctx, "s1"); err != nil || len(got) != 0 {
+					t.Errorf("second take = %v, %v; want nothing", got, err)
+				}
+				if got, err := st.TakeWebhookEvents(ctx, "s2"); err != nil || len(got) != 1 {
+					t.Errorf("other session's events = %v, %v; want one", got, err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookQueueSize   = 256
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 3
+	webhookRetryDelay  = time.Second
+)
+
+// WebhookEvent is the body POSTed to a session's webhook URL.
+type WebhookEvent struct {
+	Event     string `json:"event"`
+	SessionID string `json:"session_id"`
+	Timestamp int64  `json:"timestamp"`
+	Data      any    `json:"data,omitempty"`
+}
+
+// webhook delivers a session's events in order from a single goroutine.
+// Events that cannot be queued or delivered are logged and dropped; those
+// still queued when the gateway shuts down are handed back by close to be
+// stored and delivered after the restart.
+type webhook struct {
+	url    string
+	client *http.Client
+	log    *slog.Logger
+
+	// ctx is cancelled when close gives up waiting; deliveries stop and the
+	// remaining events are collected in left.
+	ctx    context.Context
+	cancel context.CancelFunc
+	left   []WebhookEvent
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan WebhookEvent
+	done   chan struct{}
+}
+
+func newWebhook(url string, log *slog.Logger) *webhook {
+	if url == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+		queue:  make(chan WebhookEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *webhook) enqueue(evt WebhookEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.queue <- evt:
+	default:
+		w.log.Warn("webhook queue full, dropping event", "event", evt.Event)
+	}
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+	for evt := range w.queue {
+		if w.ctx.Err() != nil {
+			w.left = append(w.left, evt)
+			continue
+		}
+		if err := w.deliver(evt); err != nil {
+			if w.ctx.Err() != nil {
+				w.left = append(w.left, evt)
+				continue
+			}
+			w.log.Warn("webhook delivery failed", "event", evt.Event, "error", err)
+		}
+	}
+}
+
+func (w *webhook) deliver(evt WebhookEvent) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(webhookRetryDelay * time.Duration(attempt-1)):
+			case <-w.ctx.Done():
+				return w.ctx.Err()
+			}
+		}
+		lastErr = w.post(body, evt.Event)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (w *webhook) post(body []byte, event string) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// close stops accepting events and waits until the queue has drained or ctx
+// expires. It then interrupts delivery and returns the events left
+// undelivered, oldest first.
+func (w *webhook) close(ctx context.Context) []WebhookEvent {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+	}
+	w.cancel()
+	return w.left
+}
+
+// emit queues an event for the session's webhook, if it has one.
+func (s *Session) emit(event string, data any) {
+	if s.webhook == nil {
+		return
+	}
+	s.webhook.enqueue(WebhookEvent{
+		Event:     event,
+		SessionID: s.ID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	})
+}
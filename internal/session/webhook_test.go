@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// close hands back the events it could not deliver in time, including the
+// one whose delivery it interrupted, and none that were delivered.
+func TestWebhookCloseReturnsUndelivered(t *testing.T) {
+	delivered := make(chan string, 10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var evt WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		if evt.Event != "first" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		delivered <- evt.Event
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	w := newWebhook(srv.URL, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, name := range []string{"first", "second", "third"} {
+		w.enqueue(WebhookEvent{Event: name, SessionID: "s1"})
+	}
+	if got := <-delivered; got != "first" {
+		t.Fatalf("delivered %q, want first", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	left := w.close(ctx)
+	if len(left) != 2 || left[0].Event != "second" || left[1].Event != "third" {
+		t.Errorf("undelivered events = %+v, want second and third", left)
+	}
+
+	w.enqueue(WebhookEvent{Event: "late", SessionID: "s1"})
+	select {
+	case got := <-delivered:
+		t.Errorf("delivered %q after close", got)
+	default:
+	}
+}
@@ -11,33 +11,62 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func NewClient(ctx context.Context, sessionDir string, handler func(interface{})) (*whatsmeow.Client, error) {
+const DBFileName = "whatsapp.db"
+
+// ClientOptions are applied to a client before it first connects.
+type ClientOptions struct {
+	Device DeviceInfo
+	// ProxyURL is an http, https or socks5 proxy for the websocket and media
+	// transfers. Empty means the environment's proxy settings are used.
+	ProxyURL string
+}
+
+func (o ClientOptions) apply(client *whatsmeow.Client) error {
+	if err := SetDeviceInfo(client, o.Device); err != nil {
+		return err
+	}
+	if o.ProxyURL != "" {
+		return SetProxy(client, o.ProxyURL)
+	}
+	return nil
+}
+
+func NewClient(ctx context.Context, sessionDir string, log waLog.Logger, handler func(interface{}), opts ClientOptions) (*whatsmeow.Client, *sqlstore.Container, error) {
 	if err := ensureDir(sessionDir); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	dbPath := filepath.Join(sessionDir, "whatsapp.db")
-	container, err := sqlstore.New(ctx, "sqlite3", "file:"+dbPath+"?_foreign_keys=on", waLog.Stdout("wa-mvp-api/db", "INFO", true))
+	dbPath := filepath.Join(sessionDir, DBFileName)
+	container, err := sqlstore.New(ctx, "sqlite3", "file:"+dbPath+"?_foreign_keys=on", log.Sub("db"))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if err := os.Chmod(dbPath, 0o600); err != nil {
+		_ = container.Close()
+		return nil, nil, err
 	}
 
 	deviceStore, err := container.GetFirstDevice(ctx)
 	if err != nil {
-		return nil, err
+		_ = container.Close()
+		return nil, nil, err
 	}
 	if deviceStore == nil {
 		deviceStore = container.NewDevice()
 	}
 
-	client := whatsmeow.NewClient(deviceStore, nil)
+	client := whatsmeow.NewClient(deviceStore, log.Sub("client"))
+	if err := opts.apply(client); err != nil {
+		_ = container.Close()
+		return nil, nil, err
+	}
 	if handler != nil {
 		client.AddEventHandler(handler)
 	}
 
-	return client, nil
+	return client, container, nil
 }
 
 func ensureDir(path string) error {
-	return os.MkdirAll(path, 0o755)
+	return os.MkdirAll(path, 0o700)
 }
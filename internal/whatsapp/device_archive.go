@@ -0,0 +1,52 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// OpenDeviceDB opens the device database of a per-session directory. It
+// returns os.ErrNotExist if the session has no database yet.
+func OpenDeviceDB(sessionDir string) (*sql.DB, error) {
+	dbPath := filepath.Join(sessionDir, DBFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on")
+}
+
+// FirstDeviceJID returns the JID of the first paired device in db, or an
+// empty JID if there is none.
+func FirstDeviceJID(ctx context.Context, db *sql.DB) (types.JID, error) {
+	var raw string
+	err := db.QueryRowContext(ctx, `SELECT jid FROM whatsmeow_device LIMIT 1`).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.EmptyJID, nil
+	} else if err != nil {
+		return types.EmptyJID, err
+	}
+	return types.ParseJID(raw)
+}
+
+// ExportDevice writes the device jid from src into a new SQLite database at
+// path, in the layout of a per-session directory database.
+func ExportDevice(ctx context.Context, src *sql.DB, jid types.JID, path string, log waLog.Logger) (int, error) {
+	dst, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	if err := sqlstore.NewWithDB(dst, "sqlite3", log).Upgrade(ctx); err != nil {
+		return 0, fmt.Errorf("failed to create device database: %w", err)
+	}
+	return CopyDevice(ctx, src, dst, jid.String())
+}
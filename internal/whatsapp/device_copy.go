@@ -0,0 +1,95 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// deviceTables lists the whatsmeow tables in foreign-key order together with
+// the column that holds the owning device JID. An empty column means the table
+// is not scoped to a device and is copied in full.
+var deviceTables = []struct {
+	name      string
+	jidColumn string
+}{
+	{"whatsmeow_device", "jid"},
+	{"whatsmeow_identity_keys", "our_jid"},
+	{"whatsmeow_pre_keys", "jid"},
+	{"whatsmeow_sessions", "our_jid"},
+	{"whatsmeow_sender_keys", "our_jid"},
+	{"whatsmeow_app_state_sync_keys", "jid"},
+	{"whatsmeow_app_state_version", "jid"},
+	{"whatsmeow_app_state_mutation_macs", "jid"},
+	{"whatsmeow_contacts", "our_jid"},
+	{"whatsmeow_chat_settings", "our_jid"},
+	{"whatsmeow_message_secrets", "our_jid"},
+	{"whatsmeow_privacy_tokens", "our_jid"},
+	{"whatsmeow_lid_map", ""},
+	{"whatsmeow_event_buffer", "our_jid"},
+}
+
+// CopyDevice copies every row belonging to the device jid from src to dst.
+// Both databases must already have the whatsmeow schema at the same version.
+// Existing rows in dst are left untouched, so the copy can be retried.
+func CopyDevice(ctx context.Context, src *sql.DB, dst *sql.DB, jid string) (int, error) {
+	tx, err := dst.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	total := 0
+	for _, table := range deviceTables {
+		n, err := copyTable(ctx, src, tx, table.name, table.jidColumn, jid)
+		if err != nil {
+			return total, fmt.Errorf("copy %s: %w", table.name, err)
+		}
+		total += n
+	}
+	return total, tx.Commit()
+}
+
+func copyTable(ctx context.Context, src *sql.DB, dst *sql.Tx, table string, jidColumn string, jid string) (int, error) {
+	query := "SELECT * FROM " + table
+	var args []any
+	if jidColumn != "" {
+		query += " WHERE " + jidColumn + "=$1"
+		args = append(args, jid)
+	}
+
+	rows, err := src.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		table, strings.Join(cols, ", "), strings.Join(placeholders, ", "))
+
+	count := 0
+	for rows.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return count, err
+		}
+		if _, err := dst.ExecContext(ctx, insert, values...); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
@@ -0,0 +1,205 @@
+package whatsapp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCompanionReg"
+	"go.mau.fi/whatsmeow/proto/waWa6"
+	"go.mau.fi/whatsmeow/store"
+	"google.golang.org/protobuf/proto"
+)
+
+// DeviceInfo describes how a linked device presents itself on the phone.
+// Empty fields keep the defaults. The phone only learns these values while
+// pairing; already paired devices keep what they registered with.
+type DeviceInfo struct {
+	// Name is shown in the phone's Linked Devices list.
+	Name string
+	// Platform is a DeviceProps platform type such as "chrome", "desktop" or
+	// "ipad". It selects the icon next to the name.
+	Platform string
+	// Version is the companion version as "major.minor.patch".
+	Version string
+	// HistoryDays asks the phone to sync at most that many days of history
+	// after pairing; zero leaves it to the phone.
+	HistoryDays uint32
+}
+
+// defaultDevice is what ConfigureDefaultDevice was given.
+var defaultDevice DeviceInfo
+
+// DefaultPairClientName is the display name pairing code requests use when
+// no device name or browser platform is configured.
+const DefaultPairClientName = "Chrome (" + defaultPairOS + ")"
+
+const defaultPairOS = "Linux"
+
+// pairClients maps the browser platforms to the client types pairing code
+// requests accept and the browser part of their display name.
+var pairClients = map[waCompanionReg.DeviceProps_PlatformType]struct {
+	client  whatsmeow.PairClientType
+	browser string
+}{
+	waCompanionReg.DeviceProps_CHROME:  {whatsmeow.PairClientChrome, "Chrome"},
+	waCompanionReg.DeviceProps_FIREFOX: {whatsmeow.PairClientFirefox, "Firefox"},
+	waCompanionReg.DeviceProps_IE:      {whatsmeow.PairClientIE, "IE"},
+	waCompanionReg.DeviceProps_OPERA:   {whatsmeow.PairClientOpera, "Opera"},
+	waCompanionReg.DeviceProps_SAFARI:  {whatsmeow.PairClientSafari, "Safari"},
+	waCompanionReg.DeviceProps_EDGE:    {whatsmeow.PairClientEdge, "Edge"},
+}
+
+func (d DeviceInfo) IsZero() bool {
+	return d.Name == "" && d.Platform == "" && d.Version == "" && d.HistoryDays == 0
+}
+
+// Validate reports whether Platform and Version can be applied.
+func (d DeviceInfo) Validate() error {
+	if _, err := parsePlatform(d.Platform); err != nil {
+		return err
+	}
+	if _, err := parseVersion(d.Version); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ConfigureDefaultDevice changes the process-wide store.DeviceProps used by
+// every client that does not override them with SetDeviceInfo.
+func ConfigureDefaultDevice(info DeviceInfo) error {
+	platform, err := parsePlatform(info.Platform)
+	if err != nil {
+		return err
+	}
+	version, err := parseVersion(info.Version)
+	if err != nil {
+		return err
+	}
+
+	if info.Version != "" || info.Name != "" {
+		name := info.Name
+		if name == "" {
+			name = store.DeviceProps.GetOs()
+		}
+		if info.Version == "" {
+			v := store.DeviceProps.GetVersion()
+			version = [3]uint32{v.GetPrimary(), v.GetSecondary(), v.GetTertiary()}
+		}
+		store.SetOSInfo(name, version)
+	}
+	if platform != nil {
+		store.DeviceProps.PlatformType = platform
+	}
+	defaultDevice = info
+	return nil
+}
+
+// PairClient returns how a pairing code request for info presents the
+// device, as a client type and a "Browser (OS)" display name: the browser
+// follows the platform if it is a browser, Chrome otherwise, and the device
+// name takes the place of the OS. Empty fields fall back to the defaults
+// given to ConfigureDefaultDevice.
+func PairClient(info DeviceInfo) (whatsmeow.PairClientType, string) {
+	if info.Name == "" {
+		info.Name = defaultDevice.Name
+	}
+	if info.Platform == "" {
+		info.Platform = defaultDevice.Platform
+	}
+	pc := pairClients[waCompanionReg.DeviceProps_CHROME]
+	if platform, err := parsePlatform(info.Platform); err == nil && platform != nil {
+		if browser, ok := pairClients[*platform]; ok {
+			pc = browser
+		}
+	}
+	if info.Name == "" {
+		info.Name = defaultPairOS
+	}
+	return pc.client, pc.browser + " (" + info.Name + ")"
+}
+
+// SetDeviceInfo makes the client register with its own device properties
+// instead of the process-wide store.DeviceProps. Fields left empty fall back
+// to the defaults.
+func SetDeviceInfo(client *whatsmeow.Client, info DeviceInfo) error {
+	if info.IsZero() {
+		return nil
+	}
+	platform, err := parsePlatform(info.Platform)
+	if err != nil {
+		return err
+	}
+	version, err := parseVersion(info.Version)
+	if err != nil {
+		return err
+	}
+
+	props := proto.Clone(store.DeviceProps).(*waCompanionReg.DeviceProps)
+	if info.Name != "" {
+		props.Os = proto.String(info.Name)
+	}
+	if platform != nil {
+		props.PlatformType = platform
+	}
+	if info.HistoryDays > 0 && props.HistorySyncConfig != nil {
+		props.HistorySyncConfig.FullSyncDaysLimit = proto.Uint32(info.HistoryDays)
+	}
+	var osVersion string
+	if info.Version != "" {
+		props.Version = &waCompanionReg.DeviceProps_AppVersion{
+			Primary:   proto.Uint32(version[0]),
+			Secondary: proto.Uint32(version[1]),
+			Tertiary:  proto.Uint32(version[2]),
+		}
+		osVersion = info.Version
+	}
+
+	raw, err := proto.Marshal(props)
+	if err != nil {
+		return err
+	}
+	client.GetClientPayload = func() *waWa6.ClientPayload {
+		payload := client.Store.GetClientPayload()
+		if payload.DevicePairingData != nil {
+			payload.DevicePairingData.DeviceProps = raw
+		}
+		if osVersion != "" && payload.UserAgent != nil {
+			payload.UserAgent.OsVersion = proto.String(osVersion)
+			payload.UserAgent.OsBuildNumber = proto.String(osVersion)
+		}
+		return payload
+	}
+	return nil
+}
+
+func parsePlatform(name string) (*waCompanionReg.DeviceProps_PlatformType, error) {
+	if name == "" {
+		return nil, nil
+	}
+	value, ok := waCompanionReg.DeviceProps_PlatformType_value[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown device platform %q", name)
+	}
+	return waCompanionReg.DeviceProps_PlatformType(value).Enum(), nil
+}
+
+func parseVersion(raw string) ([3]uint32, error) {
+	var version [3]uint32
+	if raw == "" {
+		return version, nil
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return version, fmt.Errorf("device version %q must be major.minor.patch", raw)
+	}
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return version, fmt.Errorf("device version %q must be major.minor.patch", raw)
+		}
+		version[i] = uint32(n)
+	}
+	return version, nil
+}
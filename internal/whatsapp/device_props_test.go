@@ -0,0 +1,33 @@
+package whatsapp
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow"
+)
+
+func TestPairClient(t *testing.T) {
+	defer func(saved DeviceInfo) { defaultDevice = saved }(defaultDevice)
+
+	cases := []struct {
+		name     string
+		defaults DeviceInfo
+		info     DeviceInfo
+		client   whatsmeow.PairClientType
+		display  string
+	}{
+		{"nothing configured", DeviceInfo{}, DeviceInfo{}, whatsmeow.PairClientChrome, DefaultPairClientName},
+		{"session", DeviceInfo{}, DeviceInfo{Name: "Acme Support Desk", Platform: "firefox"}, whatsmeow.PairClientFirefox, "Firefox (Acme Support Desk)"},
+		{"defaults", DeviceInfo{Name: "Acme", Platform: "edge"}, DeviceInfo{}, whatsmeow.PairClientEdge, "Edge (Acme)"},
+		{"session over defaults", DeviceInfo{Name: "Acme", Platform: "edge"}, DeviceInfo{Name: "Desk"}, whatsmeow.PairClientEdge, "Edge (Desk)"},
+		{"not a browser", DeviceInfo{}, DeviceInfo{Name: "Acme", Platform: "desktop"}, whatsmeow.PairClientChrome, "Chrome (Acme)"},
+		{"browser only", DeviceInfo{}, DeviceInfo{Platform: "safari"}, whatsmeow.PairClientSafari, "Safari (Linux)"},
+	}
+	for _, c := range cases {
+		defaultDevice = c.defaults
+		client, display := PairClient(c.info)
+		if client != c.client || display != c.display {
+			t.Errorf("%s: PairClient = %v, %q; want %v, %q", c.name, client, display, c.client, c.display)
+		}
+	}
+}
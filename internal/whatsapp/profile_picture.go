@@ -0,0 +1,126 @@
+package whatsapp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// ProfilePictureSize is the width and height WhatsApp shows profile
+	// pictures at; uploads are cropped to a square and scaled to it.
+	ProfilePictureSize = 640
+	// MaxPictureUpload caps the size of an uploaded picture file and
+	// maxPicturePixels its decoded size.
+	MaxPictureUpload = 10 << 20
+	maxPicturePixels = 50_000_000
+
+	pictureQuality = 85
+)
+
+var ErrInvalidImage = errors.New("not a JPEG, PNG or GIF image")
+
+// ProfilePicture turns a JPEG, PNG or GIF image into a profile picture: the
+// centre square, scaled to ProfilePictureSize and encoded as JPEG.
+func ProfilePicture(data []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPicturePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels is too large", ErrInvalidImage, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	// Transparent areas come out white rather than black.
+	draw.Draw(square, square.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(square, square.Bounds(), img, crop.Min, draw.Over)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, resize(square, ProfilePictureSize), &jpeg.Options{Quality: pictureQuality})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales a square image to size by size pixels, averaging the source
+// pixels each target pixel covers, one axis at a time.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	if n == size {
+		return src
+	}
+	weights := resampleWeights(n, size)
+
+	// Rows first: n rows of size pixels, then columns.
+	tmp := make([]float64, n*size*4)
+	for y := 0; y < n; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range weights {
+			var sum [4]float64
+			for _, w := range ws {
+				p := row[w.index*4:]
+				for c := range sum {
+					sum[c] += float64(p[c]) * w.weight
+				}
+			}
+			copy(tmp[(y*size+x)*4:], sum[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for x := 0; x < size; x++ {
+		for y, ws := range weights {
+			var sum [4]float64
+			for _, w := range ws {
+				p := tmp[(w.index*size+x)*4:]
+				for c := range sum {
+					sum[c] += p[c] * w.weight
+				}
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			for c, v := range sum {
+				p[c] = uint8(min(max(v+0.5, 0), 255))
+			}
+		}
+	}
+	return dst
+}
+
+type sampleWeight struct {
+	index  int
+	weight float64
+}
+
+// resampleWeights returns, for each of the to target pixels, the source
+// pixels among from that its span overlaps, weighted by the overlap.
+func resampleWeights(from, to int) [][]sampleWeight {
+	scale := float64(from) / float64(to)
+	weights := make([][]sampleWeight, to)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < from && float64(j) < end; j++ {
+			overlap := min(end, float64(j+1)) - max(start, float64(j))
+			if overlap > 0 {
+				weights[i] = append(weights[i], sampleWeight{index: j, weight: overlap / scale})
+			}
+		}
+	}
+	return weights
+}
@@ -0,0 +1,81 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+const proxyDialTimeout = 5 * time.Second
+
+// ErrProxyUnreachable is returned by CheckProxy when the proxy does not
+// accept connections.
+var ErrProxyUnreachable = errors.New("proxy unreachable")
+
+// ValidateProxyURL checks that raw is an http, https or socks5 proxy URL that
+// whatsmeow can use.
+func ValidateProxyURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q, use http, https or socks5", u.Scheme)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return fmt.Errorf("proxy URL must include a host and port")
+	}
+	return nil
+}
+
+// CheckProxy validates raw and makes sure its host accepts TCP connections.
+func CheckProxy(ctx context.Context, raw string) error {
+	if err := ValidateProxyURL(raw); err != nil || raw == "" {
+		return err
+	}
+	u, _ := url.Parse(raw)
+
+	ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrProxyUnreachable, u.Host, err)
+	}
+	return conn.Close()
+}
+
+// SetProxy routes the client's websocket and media transfers through the
+// proxy at raw. An empty address restores the default of reading the proxy
+// from the environment. The websocket only picks up the change on the next
+// Connect.
+func SetProxy(client *whatsmeow.Client, raw string) error {
+	if raw == "" {
+		client.SetProxy(http.ProxyFromEnvironment)
+		return nil
+	}
+	if err := ValidateProxyURL(raw); err != nil {
+		return err
+	}
+	return client.SetProxyAddress(raw)
+}
+
+// RedactProxyURL hides the password of a proxy URL for display.
+func RedactProxyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
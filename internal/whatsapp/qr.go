@@ -2,12 +2,51 @@ package whatsapp
 
 import (
 	"encoding/base64"
+	"fmt"
+	"strings"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
 
+const (
+	DefaultQRSize = 256
+	MinQRSize     = 64
+	MaxQRSize     = 2048
+)
+
+// QROptions control how a QR code is rendered. The zero value renders a
+// 256px code with low error correction.
+type QROptions struct {
+	// Size is the width and height in pixels of PNG and SVG output.
+	Size  int
+	Level qrcode.RecoveryLevel
+}
+
+func (o QROptions) withDefaults() QROptions {
+	if o.Size == 0 {
+		o.Size = DefaultQRSize
+	}
+	return o
+}
+
+// ParseQRLevel accepts low, medium, high and highest (or L, M, Q, H). An
+// empty value means medium.
+func ParseQRLevel(s string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "medium", "m":
+		return qrcode.Medium, nil
+	case "low", "l":
+		return qrcode.Low, nil
+	case "high", "q":
+		return qrcode.High, nil
+	case "highest", "h":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("unknown error correction level %q, use low, medium, high or highest", s)
+}
+
 func QRToBase64PNG(qr string) (string, error) {
-	png, err := qrcode.Encode(qr, qrcode.Medium, 256)
+	png, err := QRToPNG(qr, QROptions{Level: qrcode.Medium})
 	if err != nil {
 		return "", err
 	}
@@ -15,3 +54,43 @@ func QRToBase64PNG(qr string) (string, error) {
 	encoded := base64.StdEncoding.EncodeToString(png)
 	return "data:image/png;base64," + encoded, nil
 }
+
+func QRToPNG(qr string, opts QROptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	return qrcode.Encode(qr, opts.Level, opts.Size)
+}
+
+// QRToSVG renders a QR code as a standalone SVG document with one path for
+// all dark modules, so it scales without blurring.
+func QRToSVG(qr string, opts QROptions) (string, error) {
+	opts = opts.withDefaults()
+	q, err := qrcode.New(qr, opts.Level)
+	if err != nil {
+		return "", err
+	}
+	bitmap := q.Bitmap()
+	n := len(bitmap)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, opts.Size, opts.Size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String(), nil
+}
+
+// QRToTerminal renders a QR code with Unicode half blocks, two modules per
+// character row, for display in a terminal. Size is ignored.
+func QRToTerminal(qr string, opts QROptions) (string, error) {
+	q, err := qrcode.New(qr, opts.Level)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
@@ -0,0 +1,184 @@
+package whatsapp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/lib/pq"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const deviceSessionsTable = "gateway_device_sessions"
+
+// SharedStore keeps the devices of all sessions in a single sqlstore container
+// (SQLite or PostgreSQL). Sessions are mapped to their device JID in a
+// gateway-owned table next to the whatsmeow tables.
+type SharedStore struct {
+	Container *sqlstore.Container
+	DB        *sql.DB
+	Dialect   string
+}
+
+func OpenSharedStore(ctx context.Context, dialect string, address string, log waLog.Logger) (*SharedStore, error) {
+	db, err := sql.Open(dialect, address)
+	if err != nil {
+		return nil, err
+	}
+
+	container := sqlstore.NewWithDB(db, dialect, log)
+	if err := container.Upgrade(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to upgrade device store: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+deviceSessionsTable+` (
+		session_id TEXT PRIMARY KEY,
+		device_jid TEXT NOT NULL UNIQUE
+	)`)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SharedStore{Container: container, DB: db, Dialect: dialect}, nil
+}
+
+func (s *SharedStore) NewClient(ctx context.Context, sessionID string, log waLog.Logger, handler func(interface{}), opts ClientOptions) (*whatsmeow.Client, error) {
+	jid, err := s.DeviceJID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var device *store.Device
+	if !jid.IsEmpty() {
+		device, err = s.Container.GetDevice(ctx, jid)
+		if err != nil {
+			return nil, err
+		}
+		if device == nil {
+			log.Warnf("Device %s bound to session %s is missing from the store, starting a new one", jid, sessionID)
+		}
+	}
+	if device == nil {
+		device = s.Container.NewDevice()
+	}
+
+	client := whatsmeow.NewClient(device, log.Sub("client"))
+	if err := opts.apply(client); err != nil {
+		return nil, err
+	}
+	if handler != nil {
+		client.AddEventHandler(handler)
+	}
+	return client, nil
+}
+
+// DeviceJID returns the device bound to a session, or an empty JID if the
+// session has not been paired yet.
+func (s *SharedStore) DeviceJID(ctx context.Context, sessionID string) (types.JID, error) {
+	var raw string
+	err := s.DB.QueryRowContext(ctx, `SELECT device_jid FROM `+deviceSessionsTable+` WHERE session_id=$1`, sessionID).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.EmptyJID, nil
+	} else if err != nil {
+		return types.EmptyJID, err
+	}
+	return types.ParseJID(raw)
+}
+
+func (s *SharedStore) BindDevice(ctx context.Context, sessionID string, jid types.JID) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO `+deviceSessionsTable+` (session_id, device_jid) VALUES ($1, $2)
+		ON CONFLICT (session_id) DO UPDATE SET device_jid=excluded.device_jid`, sessionID, jid.String())
+	return err
+}
+
+func (s *SharedStore) UnbindDevice(ctx context.Context, sessionID string) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM `+deviceSessionsTable+` WHERE session_id=$1`, sessionID)
+	return err
+}
+
+// DeleteDevice removes the device bound to a session, if any, together with
+// the binding.
+func (s *SharedStore) DeleteDevice(ctx context.Context, sessionID string) error {
+	jid, err := s.DeviceJID(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if !jid.IsEmpty() {
+		device, err := s.Container.GetDevice(ctx, jid)
+		if err != nil {
+			return err
+		}
+		if device != nil {
+			if err := device.Delete(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return s.UnbindDevice(ctx, sessionID)
+}
+
+func (s *SharedStore) Close() error {
+	return s.Container.Close()
+}
+
+// ImportSessionDir copies the device stored in a per-session directory into the
+// shared store and binds it to the session. It returns an empty JID if the
+// directory has no paired device. The source database is left in place.
+func (s *SharedStore) ImportSessionDir(ctx context.Context, sessionDir string, sessionID string, log waLog.Logger) (types.JID, int, error) {
+	dbPath := filepath.Join(sessionDir, DBFileName)
+	if _, err := os.Stat(dbPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return types.EmptyJID, 0, nil
+		}
+		return types.EmptyJID, 0, err
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+dbPath+"?_foreign_keys=on")
+	if err != nil {
+		return types.EmptyJID, 0, err
+	}
+	defer src.Close()
+
+	// Bring the source schema to the same version as the destination so the
+	// column sets match.
+	container := sqlstore.NewWithDB(src, "sqlite3", log)
+	if err := container.Upgrade(ctx); err != nil {
+		return types.EmptyJID, 0, fmt.Errorf("failed to upgrade %s: %w", dbPath, err)
+	}
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		return types.EmptyJID, 0, err
+	}
+	if device.ID == nil {
+		return types.EmptyJID, 0, nil
+	}
+
+	jid := *device.ID
+	existing, err := s.Container.GetDevice(ctx, jid)
+	if err != nil {
+		return jid, 0, err
+	}
+	rows, err := CopyDevice(ctx, src, s.DB, jid.String())
+	if err != nil {
+		return jid, rows, err
+	}
+	if err := s.BindDevice(ctx, sessionID, jid); err != nil {
+		// Do not leave behind a copy that no session is bound to.
+		if existing == nil {
+			if copied, gerr := s.Container.GetDevice(ctx, jid); gerr == nil && copied != nil {
+				err = errors.Join(err, copied.Delete(ctx))
+			}
+		}
+		return jid, rows, err
+	}
+	return jid, rows, nil
+}
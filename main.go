@@ -1,47 +1,22 @@
 package main
 
 import (
-	"context"
-	"log"
-	"net/http"
+	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
-	"github.com/go-chi/chi/v5"
-	"wa-mvp-api/internal/api"
-	"wa-mvp-api/internal/session"
+	"wa-mvp-api/internal/cli"
+	"wa-mvp-api/internal/config"
 )
 
 func main() {
-	manager := session.GetManager()
-	if err := manager.RestoreSessionsOnStartup(); err != nil {
-		log.Printf("restore sessions error: %v", err)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
 	}
 
-	r := chi.NewRouter()
-	r.Get("/", api.HandleDocs)
-	api.RegisterSessionRoutes(r)
-
-	srv := &http.Server{
-		Addr:              ":9090",
-		Handler:           r,
-		ReadHeaderTimeout: 5 * time.Second,
+	if err := cli.Run(cfg, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
-
-	go func() {
-		log.Printf("server listening on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
-		}
-	}()
-
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
-	_ = srv.Shutdown(shutdownCtx)
 }